@@ -0,0 +1,250 @@
+/*
+	Package client provides a typed client of the Comment Parser API, so tools do not have to
+	build the HTTP requests and decode the responses themselves
+*/
+package client
+
+import (
+	"bytes"
+	"commentparser/models"
+	"commentparser/signing"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// the error of a response of the API that is not successful. The message is the body of the
+// response, and the scans stopped before they completed also describe how far they went
+type APIError struct {
+	StatusCode int                          // the HTTP status of the response
+	Message    string                       // the message of the error
+	Incomplete *models.IncompleteScanResult // how far the scan went, only for 504 (Gateway Timeout) responses
+	RetryAfter time.Duration                // when the request can be sent again, only for 429 (Too Many Requests) responses
+}
+
+// the message of the error with the status of the response
+func (err *APIError) Error() string {
+	return fmt.Sprintf("%v %s: %s", err.StatusCode, http.StatusText(err.StatusCode), err.Message)
+}
+
+// the key requests are signed with, for the servers configured with SigningKeys
+type Signer struct {
+	KeyID  string // the ID of the key
+	Secret []byte // the secret of the key
+}
+
+// Client sends the requests of the API to a server
+type Client struct {
+	BaseURL    string       // the URL of the server, such as "http://localhost:8080"
+	HTTPClient *http.Client // sends the requests, http.DefaultClient if nil
+	Signer     *Signer      // signs the requests, they are not signed if nil
+	APIKey     string       // the API key of the requests, for the servers configured with APIKeys
+}
+
+// create a new Client of the server at the base URL
+func NewClient(baseURL string) *Client {
+	return &Client{BaseURL: strings.TrimSuffix(baseURL, "/")}
+}
+
+// the query of GET "/" for a request, the inverse of the decoding of the server
+func requestQuery(request models.CommentParsingRequest) url.Values {
+
+	values := url.Values{}
+	set := func(name, value string) {
+		if len(value) > 0 {
+			values.Set(name, value)
+		}
+	}
+	setBool := func(name string, value bool) {
+		if value {
+			values.Set(name, "true")
+		}
+	}
+	set("package", request.PackageName)
+	set("directory", request.Directory)
+	// every token is a parameter of its own, marked as repeated so that the server does not
+	// split a single token containing commas
+	if len(request.Tokens) > 0 {
+		values["tokens"] = request.Tokens
+		values.Set("repeated", "true")
+	}
+	set("mode", request.MatchMode)
+	setBool("ignorecase", request.IgnoreCase)
+	setBool("wholeword", request.WholeWord)
+	set("files", strings.Join(request.FileClasses, ","))
+	set("goos", request.GOOS)
+	set("goarch", request.GOARCH)
+	set("tags", strings.Join(request.BuildTags, ","))
+	if request.CgoEnabled != nil {
+		values.Set("cgo", strconv.FormatBool(*request.CgoEnabled))
+	}
+	setBool("allplatforms", request.AllPlatforms)
+	if request.ContextLines != 0 {
+		values.Set("context", strconv.Itoa(request.ContextLines))
+	}
+	set("declarations", strings.Join(request.DeclarationKinds, ","))
+	setBool("exported", request.ExportedOnly)
+	set("sort", strings.Join(request.SortBy, ","))
+	setBool("blame", request.Blame)
+	return values
+}
+
+// send a request with the given body and decode the json of a successful (2xx) response into
+// result, other responses result in an *APIError. The request is signed if the client has a Signer
+func (client *Client) do(request *http.Request, body []byte, result interface{}) error {
+
+	if len(client.APIKey) > 0 {
+		request.Header.Set("Authorization", "Bearer "+client.APIKey)
+	}
+	if client.Signer != nil {
+		err := signing.SignRequest(request, client.Signer.KeyID, client.Signer.Secret, body, time.Now())
+		if err != nil {
+			return err
+		}
+	}
+	httpClient := client.HTTPClient
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+	response, err := httpClient.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	responseBody, err := ioutil.ReadAll(response.Body)
+	if err != nil {
+		return err
+	}
+
+	if response.StatusCode < 200 || response.StatusCode > 299 {
+		apiErr := &APIError{
+			StatusCode: response.StatusCode,
+			Message:    strings.TrimSpace(string(responseBody)),
+		}
+		if seconds, err := strconv.Atoi(response.Header.Get("Retry-After")); err == nil {
+			apiErr.RetryAfter = time.Duration(seconds) * time.Second
+		}
+		// the timeouts with a json body describe how far the scan went, the other errors with a
+		// json body are rejected by the authentication of the server
+		if strings.HasPrefix(response.Header.Get("Content-Type"), "application/json") {
+			if response.StatusCode == http.StatusGatewayTimeout {
+				var incomplete models.IncompleteScanResult
+				if json.Unmarshal(responseBody, &incomplete) == nil {
+					apiErr.Message = incomplete.Message
+					apiErr.Incomplete = &incomplete
+				}
+			} else {
+				var rejected models.AuthenticationError
+				if json.Unmarshal(responseBody, &rejected) == nil {
+					apiErr.Message = rejected.Message
+				}
+			}
+		}
+		return apiErr
+	}
+	return json.Unmarshal(responseBody, result)
+}
+
+// GET "/"
+// Extract the comments of a package matching the tokens of the request, with the request
+// given in the query
+func (client *Client) Index(
+	ctx context.Context,
+	request models.CommentParsingRequest) (models.CommentParsingResult, error) {
+
+	var result models.CommentParsingResult
+	httpRequest, err := http.NewRequestWithContext(
+		ctx, "GET", client.BaseURL+"/?"+requestQuery(request).Encode(), nil)
+	if err != nil {
+		return result, err
+	}
+	err = client.do(httpRequest, nil, &result)
+	return result, err
+}
+
+// POST "/parse"
+// Extract the comments of a package matching the tokens of the request
+func (client *Client) Parse(
+	ctx context.Context,
+	request models.CommentParsingRequest) (models.CommentParsingResult, error) {
+
+	var result models.CommentParsingResult
+	body, err := json.Marshal(request)
+	if err != nil {
+		return result, err
+	}
+	httpRequest, err := http.NewRequestWithContext(ctx, "POST", client.BaseURL+"/parse", bytes.NewReader(body))
+	if err != nil {
+		return result, err
+	}
+	httpRequest.Header.Set("Content-Type", "application/json")
+	err = client.do(httpRequest, body, &result)
+	return result, err
+}
+
+// POST "/jobs"
+// Queue a scan job, which runs in the background of the server. The state of the job is
+// given by Job, and its result by JobResult once it succeeded
+func (client *Client) SubmitJob(
+	ctx context.Context,
+	request models.ScanJobRequest) (models.ScanJob, error) {
+
+	var job models.ScanJob
+	body, err := json.Marshal(request)
+	if err != nil {
+		return job, err
+	}
+	httpRequest, err := http.NewRequestWithContext(ctx, "POST", client.BaseURL+"/jobs", bytes.NewReader(body))
+	if err != nil {
+		return job, err
+	}
+	httpRequest.Header.Set("Content-Type", "application/json")
+	err = client.do(httpRequest, body, &job)
+	return job, err
+}
+
+// GET "/jobs/{id}"
+// The state and progress of a scan job
+func (client *Client) Job(ctx context.Context, id string) (models.ScanJob, error) {
+
+	var job models.ScanJob
+	httpRequest, err := http.NewRequestWithContext(ctx, "GET", client.BaseURL+"/jobs/"+url.PathEscape(id), nil)
+	if err != nil {
+		return job, err
+	}
+	err = client.do(httpRequest, nil, &job)
+	return job, err
+}
+
+// GET "/jobs/{id}/result"
+// Decode the result of a scan job that succeeded into result, a *models.MultiPackageParsingResult
+// for the jobs of several packages and a *models.CommentParsingResult otherwise. The result of
+// a job that is not finished is a 409 (Conflict) *APIError
+func (client *Client) JobResult(ctx context.Context, id string, result interface{}) error {
+
+	httpRequest, err := http.NewRequestWithContext(
+		ctx, "GET", client.BaseURL+"/jobs/"+url.PathEscape(id)+"/result", nil)
+	if err != nil {
+		return err
+	}
+	return client.do(httpRequest, nil, result)
+}
+
+// DELETE "/jobs/{id}"
+// Cancel a queued or running scan job
+func (client *Client) CancelJob(ctx context.Context, id string) (models.ScanJob, error) {
+
+	var job models.ScanJob
+	httpRequest, err := http.NewRequestWithContext(ctx, "DELETE", client.BaseURL+"/jobs/"+url.PathEscape(id), nil)
+	if err != nil {
+		return job, err
+	}
+	err = client.do(httpRequest, nil, &job)
+	return job, err
+}
@@ -0,0 +1,76 @@
+package client
+
+import (
+	"commentparser/models"
+	"context"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestClient_RequestQuery(t *testing.T) {
+
+	cgoEnabled := false
+	values := requestQuery(models.CommentParsingRequest{
+		PackageName:  "fmt",
+		Tokens:       []string{"TODO", "FIXME"},
+		WholeWord:    true,
+		CgoEnabled:   &cgoEnabled,
+		ContextLines: 2,
+	})
+	assert.Equal(t, "cgo=false&context=2&package=fmt&repeated=true&tokens=TODO&tokens=FIXME&wholeword=true", values.Encode())
+}
+
+func TestClient_Errors(t *testing.T) {
+
+	server := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		if request.URL.Path == "/parse" {
+			writer.Header().Set("Content-Type", "application/json")
+			writer.WriteHeader(http.StatusGatewayTimeout)
+			writer.Write([]byte(`{"Message": "The scan was stopped", "PackagesTotal": 1}`))
+			return
+		}
+		http.Error(writer, "The parameter `Tokens` cannot be empty", http.StatusBadRequest)
+	}))
+	defer server.Close()
+	client := NewClient(server.URL + "/")
+
+	_, err := client.Index(context.Background(), models.CommentParsingRequest{PackageName: "fmt"})
+	assert.Equal(t, &APIError{StatusCode: 400, Message: "The parameter `Tokens` cannot be empty"}, err)
+	assert.Equal(t, "400 Bad Request: The parameter `Tokens` cannot be empty", err.Error())
+
+	_, err = client.Parse(context.Background(), models.CommentParsingRequest{PackageName: "fmt", Tokens: []string{"TODO"}})
+	assert.Equal(t, &APIError{
+		StatusCode: 504,
+		Message:    "The scan was stopped",
+		Incomplete: &models.IncompleteScanResult{Message: "The scan was stopped", PackagesTotal: 1},
+	}, err)
+}
+
+func TestClient_RetryAfter(t *testing.T) {
+
+	server := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		writer.Header().Set("Retry-After", "3")
+		http.Error(writer, "Too many requests, the limit is 1 requests per second", http.StatusTooManyRequests)
+	}))
+	defer server.Close()
+
+	_, err := NewClient(server.URL).Parse(context.Background(), models.CommentParsingRequest{PackageName: "fmt"})
+	assert.Equal(t, &APIError{
+		StatusCode: 429,
+		Message:    "Too many requests, the limit is 1 requests per second",
+		RetryAfter: 3 * time.Second,
+	}, err)
+}
+
+func TestClient_RequestQuery_Queries(t *testing.T) {
+
+	values := requestQuery(models.CommentParsingRequest{
+		PackageName: "fmt",
+		Tokens:      []string{`deprecated AND "x,y"`, "TODO"},
+		MatchMode:   models.MatchMode_QUERY,
+	})
+	assert.Equal(t, []string{`deprecated AND "x,y"`, "TODO"}, values["tokens"])
+}
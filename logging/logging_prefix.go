@@ -0,0 +1,59 @@
+package logging
+
+import "strings"
+
+// implementation of the logging interface that prefixes the messages of another Logging, such as
+// with the caller of a request
+type PrefixLogger struct {
+	logger Logging // the logging the prefixed messages are written to
+	prefix string  // the prefix of the messages
+}
+
+// creates a new implementation that writes the messages to the given logging, prefixed with
+// "[prefix] "
+func NewPrefixLogging(logger Logging, prefix string) PrefixLogger {
+	return PrefixLogger{
+		logger: logger,
+		prefix: "[" + prefix + "] ",
+	}
+}
+
+// write log with the given LogLevel, message and object
+func (bundle PrefixLogger) Log(level LogLevel, message string, vars []interface{}) {
+	prefix := bundle.prefix
+	if len(vars) > 0 {
+		// the message is formatted with the vars, the prefix must not be
+		prefix = strings.Replace(prefix, "%", "%%", -1)
+	}
+	bundle.logger.Log(level, prefix+message, vars)
+}
+
+// write Debug log with the given message and object
+func (bundle PrefixLogger) Debug(message string, vars ...interface{}) {
+	bundle.Log(LogLevel_DEBUG, message, vars)
+}
+
+// write Verbose log with the given message and object
+func (bundle PrefixLogger) Verbose(message string, vars ...interface{}) {
+	bundle.Log(LogLevel_VERBOSE, message, vars)
+}
+
+// write Info log with the given message and object
+func (bundle PrefixLogger) Info(message string, vars ...interface{}) {
+	bundle.Log(LogLevel_INFO, message, vars)
+}
+
+// write Warning log with the given message and object
+func (bundle PrefixLogger) Warning(message string, vars ...interface{}) {
+	bundle.Log(LogLevel_WARNING, message, vars)
+}
+
+// write Error log with the given message and object
+func (bundle PrefixLogger) Error(message string, vars ...interface{}) {
+	bundle.Log(LogLevel_ERROR, message, vars)
+}
+
+// write Critical log with the given message and object
+func (bundle PrefixLogger) Critical(message string, vars ...interface{}) {
+	bundle.Log(LogLevel_CRITICAL, message, vars)
+}
@@ -64,3 +64,16 @@ func TestLogging_Formatting(t *testing.T) {
 	assert.Equal(t, "[Critical] The value is 500 and index is 232.232300 "+
 		"and params were [data todo]\n", output)
 }
+
+func TestLogging_Prefix(t *testing.T) {
+	var _ Logging = PrefixLogger{}
+
+	bs := bytes.NewBufferString("")
+	buf := bufio.NewWriter(bs)
+	logger := NewPrefixLogging(NewWriterLogging(buf), "ci 100%")
+
+	logger.Info("A 50% message")
+	logger.Warning("The value is %v", "500")
+	buf.Flush()
+	assert.Equal(t, "[Info] [ci 100%] A 50% message\n[Warning] [ci 100%] The value is 500\n", bs.String())
+}
@@ -5,17 +5,20 @@ import (
 	"bytes"
 	"fmt"
 	"os"
+	"sync"
 )
 
 // implementation with io writers
 type WriterLogger struct {
 	writer *bufio.Writer
+	mutex  *sync.Mutex // serializes the writes of concurrent scans
 }
 
 // creates a new implementation that writes to the given writer
 func NewConsoleLogging() WriterLogger {
 	return WriterLogger{
 		writer: bufio.NewWriter(os.Stdout),
+		mutex:  &sync.Mutex{},
 	}
 }
 
@@ -23,6 +26,7 @@ func NewConsoleLogging() WriterLogger {
 func NewWriterLogging(writer *bufio.Writer) WriterLogger {
 	return WriterLogger{
 		writer: writer,
+		mutex:  &sync.Mutex{},
 	}
 }
 
@@ -31,6 +35,7 @@ func NewWriterLogging(writer *bufio.Writer) WriterLogger {
 func NewMockLogging() WriterLogger {
 	return WriterLogger{
 		writer: bufio.NewWriter(bytes.NewBufferString("")),
+		mutex:  &sync.Mutex{},
 	}
 }
 
@@ -62,6 +67,8 @@ func (bundle WriterLogger) Log(level LogLevel, message string, vars []interface{
 	if len(vars) > 0 {
 		payload = fmt.Sprintf(payload, vars...)
 	}
+	bundle.mutex.Lock()
+	defer bundle.mutex.Unlock()
 	bundle.writer.WriteString(payload + "\n")
 }
 
@@ -7,16 +7,212 @@ import (
 	"commentparser/server"
 	"commentparser/services"
 	"encoding/json"
+	"errors"
+	"flag"
 	"fmt"
 	"golang.org/x/net/context"
 	"google.golang.org/api/option"
 	"io/ioutil"
 	"log"
 	"os"
+	"os/signal"
 	"strings"
 	"time"
 )
 
+// the options of a local search that configure the scanner rather than the request
+type localOptions struct {
+	workingDirectory string        // the directory packages are resolved from
+	workers          int           // the maximum number of files or packages processed concurrently
+	cacheDirectory   string        // the directory the comments of parsed files are persisted to, empty for no cache
+	debounce         time.Duration // in watch mode, how long the files must stop changing before they are scanned again
+	base             string        // in diff mode, the directory or git revision of the old revision
+	head             string        // in diff mode, the directory or git revision of the new revision
+	repository       string        // in diff mode, the git repository the revisions are checked out from
+}
+
+// parse the arguments of a local search, which are optional flags followed by the package
+// name and the search terms, comma-seperated unless they are regular expressions or queries.
+// The options of the scanner, such as the working directory given with -dir, are returned
+// alongside the request
+func parseLocalRequest(args []string) (models.CommentParsingRequest, localOptions, error) {
+
+	var request models.CommentParsingRequest
+	var options localOptions
+	flags := flag.NewFlagSet("commentparser", flag.ContinueOnError)
+	flags.StringVar(&options.workingDirectory, "dir", "", "the directory packages are resolved from, such as the root of a module")
+	flags.IntVar(&options.workers, "workers", 0, "the maximum number of files parsed concurrently, the number of CPUs if not set")
+	flags.StringVar(&options.cacheDirectory, "cache-dir", "", "a directory caching the comments of parsed files, so the next runs only parse changed files")
+	flags.DurationVar(&options.debounce, "debounce", services.DefaultWatchDebounce,
+		"in watch mode, how long the files must stop changing before they are scanned again")
+	flags.StringVar(&options.base, "base", "", "in diff mode, the directory or git revision of the old revision")
+	flags.StringVar(&options.head, "head", "", "in diff mode, the directory or git revision of the new revision")
+	flags.StringVar(&options.repository, "repo", "", "in diff mode, the git repository the revisions are checked out from")
+	flags.StringVar(&request.MatchMode, "mode", models.MatchMode_LITERAL, "how tokens are matched: literal, regex, query or annotation")
+	flags.BoolVar(&request.IgnoreCase, "ignore-case", false, "match tokens regardless of letter case")
+	flags.BoolVar(&request.WholeWord, "whole-word", false, "only match tokens that are whole words")
+	fileClasses := flags.String("files", models.FileClass_GO,
+		"comma-seperated classes of files to scan: go, cgo, test, xtest and ignored")
+	flags.StringVar(&request.GOOS, "goos", "", "the operating system files are selected for, the current one if empty")
+	flags.StringVar(&request.GOARCH, "goarch", "", "the architecture files are selected for, the current one if empty")
+	tags := flags.String("tags", "", "comma-seperated additional build tags")
+	cgoEnabled := flags.Bool("cgo", false, "whether files importing \"C\" are selected, the go command's default if not given")
+	flags.BoolVar(&request.AllPlatforms, "all-platforms", false, "select the files of every known GOOS/GOARCH")
+	flags.IntVar(&request.ContextLines, "C", 0, "the number of source lines to print before and after each comment")
+	declarationKinds := flags.String("declarations", "",
+		"comma-seperated kinds of declarations to report: func, method, type, field, const, var and import")
+	flags.BoolVar(&request.ExportedOnly, "exported", false, "only report the comments of exported declarations")
+	sortBy := flags.String("sort", "", "comma-seperated keys to sort the matches by: file, line, token, declaration, age and author (default file,line)")
+	flags.BoolVar(&request.Blame, "blame", false, "print the commit, author and date of the line of each comment, from git blame")
+
+	if err := flags.Parse(args); err != nil {
+		return request, options, err
+	}
+	if flags.NArg() < 2 {
+		return request, options, errors.New(
+			"Two parameters required: package_name and (comma-seperated) search_terms")
+	}
+	request.PackageName = flags.Arg(0)
+	// regular expressions and queries can contain commas, so each of them is an argument of its
+	// own, while the literal tokens and annotation kinds are comma seperated
+	for _, arg := range flags.Args()[1:] {
+		if request.MatchMode == models.MatchMode_REGEX || request.MatchMode == models.MatchMode_QUERY {
+			request.Tokens = append(request.Tokens, arg)
+		} else {
+			request.Tokens = append(request.Tokens, strings.Split(arg, ",")...)
+		}
+	}
+	request.FileClasses = strings.Split(*fileClasses, ",")
+	if len(*tags) > 0 {
+		request.BuildTags = strings.Split(*tags, ",")
+	}
+	if len(*declarationKinds) > 0 {
+		request.DeclarationKinds = strings.Split(*declarationKinds, ",")
+	}
+	// the matches of every token are printed together, like grep does
+	request.SortBy = []string{"file", "line"}
+	if len(*sortBy) > 0 {
+		request.SortBy = strings.Split(*sortBy, ",")
+	}
+	flags.Visit(func(f *flag.Flag) {
+		if f.Name == "cgo" {
+			request.CgoEnabled = cgoEnabled
+		}
+	})
+	return request, options, nil
+}
+
+// describe the declaration of a match in the output of the CLI, such as " (method (*Buffer).Add)"
+func describeDeclaration(declaration *models.Declaration) string {
+	if declaration == nil {
+		return ""
+	}
+	name := declaration.Name
+	switch {
+	case len(declaration.Receiver) > 0:
+		name = fmt.Sprintf("(%s).%s", declaration.Receiver, name)
+	case len(declaration.Type) > 0:
+		name = declaration.Type + "." + name
+	}
+	return fmt.Sprintf(" (%s %s)", declaration.Kind, strings.TrimSpace(name))
+}
+
+// describe the commit of a match in the output of the CLI, such as " [1a2b3c4d alice 2024-05-01]"
+func describeBlame(blame *models.Blame) string {
+	if blame == nil {
+		return ""
+	}
+	return fmt.Sprintf(" [%.8s %s %s]", blame.Commit, blame.Author, blame.Date.Format("2006-01-02"))
+}
+
+// print the matches of a single package to the standard output in the order of the request's
+// SortBy, with their context lines seperated by "--" like grep -C does
+func printMatches(res models.CommentParsingResult) {
+	for _, match := range res.OrderedMatches {
+		fmt.Fprintf(
+			os.Stdout,
+			"%s:%v:%s%s\n",
+			match.FileName,
+			match.LineNumber,
+			describeDeclaration(match.Declaration),
+			describeBlame(match.Blame))
+		for _, line := range match.ContextBefore {
+			fmt.Fprintln(os.Stdout, line)
+		}
+		fmt.Fprintln(os.Stdout, match.LineContent)
+		for _, line := range match.ContextAfter {
+			fmt.Fprintln(os.Stdout, line)
+		}
+		if len(match.ContextBefore) > 0 || len(match.ContextAfter) > 0 {
+			fmt.Fprintln(os.Stdout, "--")
+		}
+	}
+	for _, fileErr := range res.FileErrors {
+		fmt.Fprintf(os.Stderr, "%s:%v:%v: %s\n", fileErr.FileName, fileErr.LineNumber, fileErr.Column, fileErr.Message)
+	}
+}
+
+// print a match on a single line prefixed with the kind of change, such as "+" for an added match
+func printChange(prefix string, match models.MatchedComment) {
+	fmt.Fprintf(os.Stdout, "%s %s:%v:%s %s\n", prefix, match.FileName, match.LineNumber,
+		describeDeclaration(match.Declaration), strings.TrimSpace(match.LineContent))
+}
+
+// print the matches added and removed since the previous scan of a watched request, prefixed
+// with "+" and "-" like a diff. Errors are printed to the standard error
+func printWatchEvent(event services.WatchEvent) {
+	if event.Err != nil {
+		fmt.Fprintln(os.Stderr, event.Err)
+		return
+	}
+	for _, match := range event.Removed {
+		printChange("-", match)
+	}
+	for _, match := range event.Added {
+		printChange("+", match)
+	}
+}
+
+// print the diff of two revisions: the removed, moved and added matches prefixed with "-", "~"
+// and "+", then the number of each
+func printDiff(res models.CommentDiffResult) {
+	for _, match := range res.Removed {
+		printChange("-", match)
+	}
+	for _, change := range res.Moved {
+		printChange(fmt.Sprintf("~ %s:%v ->", change.Base.FileName, change.Base.LineNumber), change.Head)
+	}
+	for _, match := range res.Added {
+		printChange("+", match)
+	}
+	fmt.Fprintf(os.Stdout, "%v added, %v removed, %v moved\n", len(res.Added), len(res.Removed), len(res.Moved))
+}
+
+// create the scanner of a local search or watch
+func localScanner(options localOptions) services.Scanner {
+	scanner := services.NewScanner(options.workingDirectory, cplogging.NewConsoleLogging())
+	scanner.Workers = options.workers
+	if len(options.cacheDirectory) > 0 {
+		cache, err := services.NewParseCache(0, options.cacheDirectory)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(2)
+		}
+		scanner.Cache = cache
+	}
+	return scanner
+}
+
+// report the error of a scan on stderr and exit, the errors caused by the request exit like
+// the errors of the arguments. Scans stopped by an interrupt exit once their matches are printed
+func exitWithError(err error) {
+	fmt.Fprintln(os.Stderr, err)
+	if _, ok := err.(services.InvalidRequestError); ok {
+		os.Exit(2)
+	}
+	os.Exit(1)
+}
+
 // entry point for the application, see readme.md for instructions
 func main() {
 
@@ -24,7 +220,7 @@ func main() {
 	// look for server mode
 	if len(os.Args) < 2 {
 		os.Stderr.WriteString("Two parameters required: package_name and (comma-seperated) search_terms or " +
-			"'server' optionally followed by configuration path location")
+			"'server' optionally followed by configuration path location, or 'watch' or 'diff' followed by the parameters of a search")
 	} else if os.Args[1] == "server" {
 		ctx := context.Background()
 
@@ -71,32 +267,73 @@ func main() {
 		// measurement
 		measurementGC := server.NewMeasurementStackdriver(loggerGC)
 
-		server.CommentParserHttpServer(config, loggingGC, measurementGC)
+		if err := server.CommentParserHttpServer(config, loggingGC, measurementGC); err != nil {
+			log.Fatalf("Failed to start the server: %v", err)
+		}
 
 		if err := client.Close(); err != nil {
 			log.Fatalf("Failed to close client: %v", err)
 		}
+	} else if os.Args[1] == "watch" {
+		request, options, err := parseLocalRequest(os.Args[2:])
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(2)
+		}
+		// an interrupt stops watching
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+		defer stop()
+		if err := localScanner(options).Watch(ctx, request, options.debounce, printWatchEvent); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+	} else if os.Args[1] == "diff" {
+		request, options, err := parseLocalRequest(os.Args[2:])
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(2)
+		}
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+		defer stop()
+		res, err := localScanner(options).DiffComments(ctx, models.CommentDiffRequest{
+			Base:       options.base,
+			Head:       options.head,
+			Repository: options.repository,
+			Request:    request,
+		})
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		printDiff(res)
 	} else {
-		searchTerms := strings.Split(os.Args[2], ",")
-		request := models.CommentParsingRequest{
-			PackageName: os.Args[1],
-			Tokens:      searchTerms,
-		}
-		res, err := services.ExtractRelevantComments(request, cplogging.NewConsoleLogging())
-
-		for _, matches := range res.Matches {
-			for _, match := range matches {
-				fmt.Fprintf(
-					os.Stdout,
-					"%s:%v:\n%s\n",
-					match.FileName,
-					match.LineNumber,
-					match.LineContent)
-			}
+		request, options, err := parseLocalRequest(os.Args[1:])
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(2)
 		}
+		scanner := localScanner(options)
+		// an interrupt stops the scan, printing the matches of the packages scanned so far
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+		defer stop()
+		if services.IsPackagePattern(request.PackageName) {
+			res, err := scanner.ExtractRelevantCommentsForPattern(ctx, request)
 
-		if err != nil {
-			panic(err)
+			for _, packageRes := range res.Packages {
+				printMatches(packageRes)
+			}
+
+			if err != nil {
+				exitWithError(err)
+			}
+		} else {
+			res, err := scanner.ExtractRelevantComments(ctx, request)
+
+			printMatches(res)
+
+			if err != nil {
+				exitWithError(err)
+			}
 		}
 	}
 	os.Exit(0)
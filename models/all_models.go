@@ -1,21 +1,245 @@
 package models
 
+import "time"
+
+// the modes in which the tokens of a CommentParsingRequest can be matched
+const (
+	// (default) tokens are matched as literal substrings of the comment
+	MatchMode_LITERAL = "literal"
+	// tokens are compiled as Go regular expressions (https://golang.org/pkg/regexp/syntax/)
+	MatchMode_REGEX = "regex"
+	// tokens are boolean queries of words and "quoted phrases" combined with AND, OR, NOT and parentheses
+	MatchMode_QUERY = "query"
+	// tokens are annotation kinds such as TODO or FIXME, parsed into MatchedComment.Annotations
+	MatchMode_ANNOTATION = "annotation"
+)
+
+// the classes of the files of a package, as reported by go/build
+const (
+	// (default) the Go sources of the package, excluding cgo and test files
+	FileClass_GO = "go"
+	// the Go sources that import "C"
+	FileClass_CGO = "cgo"
+	// the _test.go files of the package itself
+	FileClass_TEST = "test"
+	// the _test.go files of the external test package (package name_test)
+	FileClass_XTEST = "xtest"
+	// the Go sources excluded by build constraints
+	FileClass_IGNORED = "ignored"
+)
+
+// the kinds of declarations a matched comment can belong to
+const (
+	// a function
+	DeclarationKind_FUNC = "func"
+	// a method, either with a receiver or in an interface type
+	DeclarationKind_METHOD = "method"
+	// a type
+	DeclarationKind_TYPE = "type"
+	// a field of a struct type
+	DeclarationKind_FIELD = "field"
+	// a constant or a block of constants
+	DeclarationKind_CONST = "const"
+	// a variable or a block of variables
+	DeclarationKind_VAR = "var"
+	// an import or a block of imports
+	DeclarationKind_IMPORT = "import"
+)
+
+// the states of a scan job
+const (
+	// the job waits for a worker of the server
+	JobState_QUEUED = "queued"
+	// the job is scanning
+	JobState_RUNNING = "running"
+	// the result of the job is ready
+	JobState_SUCCEEDED = "succeeded"
+	// the job failed, see its Error
+	JobState_FAILED = "failed"
+	// the job was cancelled by its client
+	JobState_CANCELLED = "cancelled"
+)
+
+// the scopes an API key can be granted
+const (
+	// scan packages, directories and archives
+	Scope_PARSE_READ = "parse:read"
+	// view and rebuild the index, an admin key is granted every scope
+	Scope_ADMIN = "admin"
+)
+
 // the request model for Comment Parsing
 type CommentParsingRequest struct {
-	PackageName string   // the package name to search for comments
-	Tokens      []string // the tokens/words to search for
+	PackageName      string   // the package name to search for comments
+	Directory        string   // a directory to search for comments instead of PackageName, relative to the working directory
+	Tokens           []string // the tokens/words to search for
+	MatchMode        string   // how the tokens are matched, MatchMode_LITERAL if empty
+	IgnoreCase       bool     // if true, tokens are matched regardless of (Unicode) letter case
+	WholeWord        bool     // if true, a match must not be surrounded by letters, digits or underscores
+	FileClasses      []string // the classes of files to scan (FileClass_*), only FileClass_GO if empty
+	GOOS             string   // the operating system files are selected for, the one of the server if empty
+	GOARCH           string   // the architecture files are selected for, the one of the server if empty
+	BuildTags        []string // additional build tags satisfied when selecting files, such as "integration"
+	CgoEnabled       *bool    // if set, whether files importing "C" are selected, the server's default if nil
+	AllPlatforms     bool     // if true, files are selected for every known GOOS/GOARCH, GOOS and GOARCH must be empty
+	ContextLines     int      // the number of source lines to return before and after each matched comment
+	DeclarationKinds []string // only report the matches in these kinds of declarations (DeclarationKind_*), all if empty
+	ExportedOnly     bool     // if true, only report the matches in exported declarations
+	SortBy           []string // the keys matches are sorted by: "file", "line", "token", "declaration", "age" and "author", file then line if empty
+	Blame            bool     // if true, the matches of files in a git repository are annotated with the commit of their line
 }
 
 // the result model for Comment Parsing
 type CommentParsingResult struct {
-	PackageName string                      // the package name in which the matches were made
-	BinaryOnly  bool                        // true if the package was binary only
-	Matches     map[string][]MatchedComment // the matched comments
+	PackageName    string                      // the package name in which the matches were made
+	ImportPath     string                      // the import path of the package
+	BinaryOnly     bool                        // true if the package was binary only
+	Matches        map[string][]MatchedComment // the matched comments
+	OrderedMatches []MatchedComment            // every match of every token in the order of the request's SortBy, only when SortBy is given
+	FileErrors     []FileError                 // the files of the package that could not be read or parsed, ordered by file and line
+}
+
+// an error found while reading or parsing a file. The comments of a file with syntax errors are
+// still scanned as far as the parser could recover
+type FileError struct {
+	FileName   string // the file that caused the error
+	LineNumber int    // the line of the error, 0 if the error is not about a position
+	Column     int    // the column of the error, 0 if the error is not about a position
+	Message    string // the description of the error
+}
+
+// describes how far a scan went when it was stopped before completing, such as when its
+// deadline expired
+type IncompleteScanResult struct {
+	Message         string // why the scan was stopped
+	PackagesTotal   int    // the number of packages to scan, 0 if the pattern was not expanded
+	PackagesScanned int    // the number of packages whose files were all scanned
+	FilesTotal      int    // the number of files of the imported packages
+	FilesScanned    int    // the number of files scanned before the scan was stopped
+}
+
+// the state of the index of the server, which answers the requests for the packages it contains
+type IndexStatus struct {
+	Patterns  []string  // the package patterns indexed, such as "./..."
+	Packages  int       // the number of packages indexed
+	Files     int       // the number of files indexed
+	Comments  int       // the number of comments indexed
+	BuiltAt   time.Time // when the index was last built from scratch, zero if never
+	UpdatedAt time.Time // when the index was last updated, requests are answered with the files as they were then
+	Updating  bool      // true while the index is being updated or a rebuild is pending
+	LastError string    // the error of the last update, empty if it succeeded
+}
+
+// describes why a request was rejected by the authentication of the server, the body of the
+// 401 (Unauthorized) and 403 (Forbidden) responses
+type AuthenticationError struct {
+	Message string // why the request was rejected
+	Scope   string // the scope required by the endpoint
+}
+
+// the result model for Comment Parsing of several packages, as matched by a pattern
+type MultiPackageParsingResult struct {
+	Pattern  string                 // the pattern given as the PackageName of the request
+	Packages []CommentParsingResult // the result for every package matched, ordered by import path
+}
+
+// the request model of a scan job, which runs in the background of the server
+type ScanJobRequest struct {
+	Request  CommentParsingRequest // the request of the scan
+	Packages bool                  // if true, the PackageName of the request is a pattern, or every package below its Directory is scanned, like POST /parse/packages
+}
+
+// the state of a scan job
+type ScanJob struct {
+	ID          string                // identifies the job in the paths of the jobs endpoints
+	State       string                // JobState_QUEUED, JobState_RUNNING, JobState_SUCCEEDED, JobState_FAILED or JobState_CANCELLED
+	Packages    bool                  // the Packages of the job request, the result is a MultiPackageParsingResult if true, a CommentParsingResult otherwise
+	SubmittedAt time.Time             // when the job was submitted
+	StartedAt   time.Time             // when the job started running, zero while it is queued
+	FinishedAt  time.Time             // when the job finished or was cancelled, zero until then
+	Progress    ScanProgress          // the packages and files scanned so far
+	Error       string                // why the job failed, empty unless it failed
+	Incomplete  *IncompleteScanResult // how far the scan went, if it was stopped by its timeout or cancelled while running
+}
+
+// counts the packages and files of a running scan
+type ScanProgress struct {
+	PackagesTotal    int // the number of packages to scan, 0 until the pattern is expanded
+	PackagesImported int // the number of packages imported
+	FilesTotal       int // the number of files of the imported packages, once they are all imported
+	FilesScanned     int // the number of files scanned
+}
+
+// the request model for the diff of the comments of two revisions of the same packages
+type CommentDiffRequest struct {
+	Base       string                // the directory of the old revision, or a git revision of Repository
+	Head       string                // the directory of the new revision, or a git revision of Repository
+	Repository string                // if set, the git repository Base and Head are checked out from
+	Request    CommentParsingRequest // the tokens and options, with a PackageName relative to both revisions such as "./..." (the default)
+}
+
+// the result model for the diff of the comments of two revisions, the file names are relative
+// to the root of each revision
+type CommentDiffResult struct {
+	Base    string           // the Base of the request
+	Head    string           // the Head of the request
+	Added   []MatchedComment // the matches of the head revision that are not in the base revision
+	Removed []MatchedComment // the matches of the base revision that are not in the head revision
+	Moved   []MovedComment   // the matches found in both revisions at another position or with a similar content
+}
+
+// a match found in both revisions of a diff
+type MovedComment struct {
+	Base       MatchedComment // the match in the base revision
+	Head       MatchedComment // the match in the head revision
+	Similarity float64        // how similar the contents of the comments are, 1 if they are the same
 }
 
 // result model for a single matched comment
 type MatchedComment struct {
-	FileName    string // the file name where the comment was found
-	LineNumber  int    // the line number where the comment was found
-	LineContent string // the content of the comment itself
+	FileName        string       // the file name where the comment was found
+	Token           string       // the token of the request that matched the comment
+	LineNumber      int          // the line number where the comment was found
+	LineContent     string       // the content of the comment itself
+	MatchedText     string       // the part of the comment that matched the token
+	MatchOffset     int          // the byte offset of MatchedText within LineContent
+	FileClass       string       // the class of the file (FileClass_*)
+	BuildConstraint string       // the build constraint of the file from its name and //go:build line, empty if none
+	EndLineNumber   int          // the line number where the comment ends
+	EndColumn       int          // the column just after the end of the comment, in bytes starting at 1
+	ContextBefore   []string     // the source lines before the comment, as many as the request's ContextLines
+	ContextAfter    []string     // the source lines after the comment, as many as the request's ContextLines
+	NodeKind        string       // the kind of AST node the comment is attached to, such as "FuncDecl" or "Field"
+	Declaration     *Declaration // the declaration enclosing the comment, nil for comments of the file itself
+	IsDocComment    bool         // true if the comment is the doc comment of the node it is attached to
+	Annotations     []Annotation // the annotations of the token's kind in the comment, in the annotation match mode
+	Blame           *Blame       // the commit that last changed the first line of the comment, only when the request asks for it
+}
+
+// the commit that last changed a line, as reported by git blame
+type Blame struct {
+	Commit      string    // the hash of the commit
+	Author      string    // the name of the author of the commit
+	AuthorEmail string    // the email address of the author, without angle brackets
+	Date        time.Time // when the commit was authored
+	AgeDays     int       // the number of whole days since the commit was authored
+}
+
+// a conventional annotation of a comment, such as "TODO(alice, #123): message"
+type Annotation struct {
+	Kind    string   // the kind of annotation, such as "TODO" or "FIXME"
+	Owner   string   // the owner given in the details of the annotation, without a leading @
+	Issues  []string // the issue references given in the details, such as "#123", "JIRA-42" or a URL
+	DueDate string   // the due date given in the details, formatted as YYYY-MM-DD
+	Message string   // the text following the annotation up to the end of the line
+	Offset  int      // the byte offset of the annotation within LineContent
+}
+
+// the declaration enclosing a matched comment
+type Declaration struct {
+	Kind     string // the kind of declaration (DeclarationKind_*)
+	Name     string // the declared name or import path, empty for blocks declaring several names
+	Receiver string // the receiver type of a method, such as "*Counter", or the interface declaring it
+	Type     string // the type declaring a field
+	Exported bool   // true if the name is exported
 }
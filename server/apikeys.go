@@ -0,0 +1,145 @@
+package server
+
+import (
+	"commentparser/logging"
+	"commentparser/models"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+)
+
+// an API key accepted by the server. Only the hash of the key is configured, so neither the
+// configuration nor the key file contain the keys themselves
+type APIKey struct {
+	Name   string   // identifies the caller in the logs and measurements of its requests
+	Hash   string   // the hex encoded SHA-256 of the key, see HashAPIKey
+	Scopes []string // what the key is allowed to do, such as models.Scope_PARSE_READ
+}
+
+// true if the key is granted the scope, an admin key is granted every scope
+func (key APIKey) allows(scope string) bool {
+	for _, granted := range key.Scopes {
+		if granted == scope || granted == models.Scope_ADMIN {
+			return true
+		}
+	}
+	return false
+}
+
+// the hash of an API key as configured in APIKey
+func HashAPIKey(key string) string {
+	hash := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(hash[:])
+}
+
+// the key of the context value holding the caller of an authenticated request
+type callerContextKey struct{}
+
+// the API keys of the configuration and of its key file by hash, validating them
+func (config *Configuration) loadAPIKeys() (map[string]APIKey, error) {
+
+	keys := append([]APIKey{}, config.APIKeys...)
+	if len(config.APIKeyFile) > 0 {
+		content, err := ioutil.ReadFile(config.APIKeyFile)
+		if err != nil {
+			return nil, err
+		}
+		var fileKeys []APIKey
+		if err := json.Unmarshal(content, &fileKeys); err != nil {
+			return nil, fmt.Errorf("Could not parse the API key file %s: %v", config.APIKeyFile, err)
+		}
+		keys = append(keys, fileKeys...)
+	}
+
+	byHash := make(map[string]APIKey)
+	for _, key := range keys {
+		if len(key.Name) < 1 {
+			return nil, errors.New("Every API key must have a Name")
+		}
+		hash := strings.ToLower(key.Hash)
+		if decoded, err := hex.DecodeString(hash); err != nil || len(decoded) != sha256.Size {
+			return nil, fmt.Errorf("The Hash of the API key %s is not a hex encoded SHA-256", key.Name)
+		}
+		for _, scope := range key.Scopes {
+			if scope != models.Scope_PARSE_READ && scope != models.Scope_ADMIN {
+				return nil, fmt.Errorf("The scope %s of the API key %s is unknown", scope, key.Name)
+			}
+		}
+		if _, found := byHash[hash]; found {
+			return nil, fmt.Errorf("The API key %s is configured twice", key.Name)
+		}
+		byHash[hash] = key
+	}
+	return byHash, nil
+}
+
+// the key of a request, given in its Authorization header as a bearer token
+func requestAPIKey(request *http.Request) string {
+	authorization := request.Header.Get("Authorization")
+	if len(authorization) > len("Bearer ") && strings.EqualFold(authorization[:len("Bearer ")], "Bearer ") {
+		return strings.TrimSpace(authorization[len("Bearer "):])
+	}
+	return ""
+}
+
+// Reject the requests without a known API key with a 401 (Unauthorized), and the requests whose
+// key is not granted the scope with a 403 (Forbidden), before calling the handler. The name of
+// the key is given to the handler in the context of the request, see callerInstrumentation.
+// Every request is allowed when the server has no API keys
+func (config *Configuration) requireScope(
+	scope string,
+	next http.HandlerFunc,
+	logging logging.Logging) http.HandlerFunc {
+
+	if len(config.apiKeys) < 1 {
+		return next
+	}
+	return func(writer http.ResponseWriter, request *http.Request) {
+
+		key, found := config.apiKeys[HashAPIKey(requestAPIKey(request))]
+		if !found {
+			writer.Header().Set("WWW-Authenticate", "Bearer")
+			message := "The request does not have a valid API key"
+			config.errorPkgHandle(ErrorWithBody(
+				http.StatusUnauthorized,
+				fmt.Errorf("Rejected the request %s %s: %s", request.Method, request.URL.Path, message),
+				models.AuthenticationError{Message: message, Scope: scope}), writer, logging)
+			return
+		}
+		if !key.allows(scope) {
+			message := fmt.Sprintf("The API key %s is not granted the scope %s", key.Name, scope)
+			config.errorPkgHandle(ErrorWithBody(
+				http.StatusForbidden,
+				fmt.Errorf("Rejected the request %s %s: %s", request.Method, request.URL.Path, message),
+				models.AuthenticationError{Message: message, Scope: scope}), writer, logging)
+			return
+		}
+
+		next(writer, request.WithContext(context.WithValue(request.Context(), callerContextKey{}, key.Name)))
+	}
+}
+
+// the name of the API key of a request once it is authenticated, empty if it is not
+func requestCaller(request *http.Request) string {
+	caller, _ := request.Context().Value(callerContextKey{}).(string)
+	return caller
+}
+
+// the logging and measurement of a request, which identify its caller once it is authenticated
+func callerInstrumentation(
+	request *http.Request,
+	logger logging.Logging,
+	measurement Measurement) (logging.Logging, Measurement) {
+
+	caller, found := request.Context().Value(callerContextKey{}).(string)
+	if !found {
+		return logger, measurement
+	}
+	return logging.NewPrefixLogging(logger, caller), measurement.WithCaller(caller)
+}
@@ -3,9 +3,10 @@ package server
 // Provides an implementation to hold information required to handle errors within am http server
 // where the server may want to sanitize errors that contain sensitive information
 type ErrorPkg struct {
-	innerError  error // the error being packaged
-	httpStatus  int   // the status code that this error should result in
-	isSanitized bool  // if true, the error message will be passed thru to the http result
+	innerError  error       // the error being packaged
+	httpStatus  int         // the status code that this error should result in
+	isSanitized bool        // if true, the error message will be passed thru to the http result
+	body        interface{} // if set, the http result is this object as json instead of the error message
 }
 
 // true if an error has occured
@@ -43,3 +44,14 @@ func ErrorWithCodeSantized(statusCode int, err error) ErrorPkg {
 		isSanitized: true,
 	}
 }
+
+// create an instance of ErrorPkg which is sanitized, has the given error code and results in the
+// body serialized as json
+func ErrorWithBody(statusCode int, err error, body interface{}) ErrorPkg {
+	return ErrorPkg{
+		innerError:  err,
+		httpStatus:  statusCode,
+		isSanitized: true,
+		body:        body,
+	}
+}
@@ -0,0 +1,462 @@
+package server
+
+import (
+	"commentparser/logging"
+	"commentparser/models"
+	"commentparser/services"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/gorilla/mux"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+// the default maximum number of jobs waiting for a worker
+const defaultJobQueueSize = 100
+
+// the default maximum duration of a job
+const defaultJobTimeout = 10 * time.Minute
+
+// the default duration finished jobs are kept for their results to be read
+const defaultJobRetention = time.Hour
+
+// the default maximum number of finished jobs kept for their results to be read
+const defaultJobMaxRetained = 1000
+
+// the maximum interval between the removals of the expired jobs
+const jobSweepInterval = time.Minute
+
+// a scan job of the queue
+type scanJob struct {
+	request  models.ScanJobRequest  // the request of the job
+	caller   string                 // the name of the API key that submitted the job, empty if not authenticated
+	progress *services.ScanProgress // counts the packages and files scanned by the job
+	ctx      context.Context        // done once the job is cancelled
+	cancel   context.CancelFunc     // cancels the job
+
+	// guarded by the mutex of the queue
+	status models.ScanJob // the state of the job, without its progress
+	result interface{}    // the result of a job that succeeded
+	err    error          // the error of a job that failed or was stopped
+}
+
+// the scan jobs of the server, run in the background by a bounded number of workers. A jobQueue
+// is safe for concurrent use
+type jobQueue struct {
+	scanner     func() services.Scanner // creates the scanner of a job
+	development bool                    // true if the errors of failed jobs are not sanitized
+	timeout     time.Duration           // the maximum duration of a job
+	retention   time.Duration           // how long finished jobs are kept
+	maxRetained int                     // the maximum number of finished jobs kept
+	now         func() time.Time        // the time of the server
+	logging     logging.Logging         // logs the failures of the jobs
+	measure     Measurement             // measures the duration of the jobs
+	counts      *requestCounts          // counts the cache hits of the jobs, nil if not counted
+	pending     chan *scanJob           // the jobs waiting for a worker
+	slots       chan struct{}           // the slots of the scans shared with the requests, nil if not limited
+	done        chan struct{}           // closed once the queue is stopped, which ends its goroutines
+	stopOnce    sync.Once               // closes done only once
+
+	mutex sync.Mutex          // guards the jobs and their status
+	jobs  map[string]*scanJob // the jobs by ID
+}
+
+// create the job queue of the server and start its workers
+func (config *Configuration) startJobs(logging logging.Logging, measurement Measurement) *jobQueue {
+
+	workers := config.JobWorkers
+	if workers < 1 {
+		workers = 1
+	}
+	queueSize := config.JobQueueSize
+	if queueSize < 1 {
+		queueSize = defaultJobQueueSize
+	}
+	timeout := time.Duration(config.JobTimeoutSeconds) * time.Second
+	if timeout <= 0 {
+		timeout = defaultJobTimeout
+	}
+	retention := time.Duration(config.JobRetentionSeconds) * time.Second
+	if retention <= 0 {
+		retention = defaultJobRetention
+	}
+	maxRetained := config.JobMaxRetained
+	if maxRetained < 1 {
+		maxRetained = defaultJobMaxRetained
+	}
+
+	queue := &jobQueue{
+		scanner:     func() services.Scanner { return config.scanner(logging) },
+		development: config.Development,
+		timeout:     timeout,
+		retention:   retention,
+		maxRetained: maxRetained,
+		now:         time.Now,
+		logging:     logging,
+		measure:     measurement,
+		counts:      config.counts,
+		pending:     make(chan *scanJob, queueSize),
+		slots:       config.scans,
+		jobs:        make(map[string]*scanJob),
+		done:        make(chan struct{}),
+	}
+	for worker := 0; worker < workers; worker++ {
+		go func() {
+			for {
+				select {
+				case job := <-queue.pending:
+					queue.run(job)
+				case <-queue.done:
+					return
+				}
+			}
+		}()
+	}
+
+	// the expired jobs are removed even when no job is submitted
+	sweepInterval := retention
+	if sweepInterval > jobSweepInterval {
+		sweepInterval = jobSweepInterval
+	}
+	go func() {
+		ticker := time.NewTicker(sweepInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				queue.mutex.Lock()
+				queue.removeExpired()
+				queue.mutex.Unlock()
+			case <-queue.done:
+				return
+			}
+		}
+	}()
+	return queue
+}
+
+// stop the workers and the removal of the expired jobs of the queue, and cancel the jobs that
+// are queued or running. The jobs submitted afterwards are never run
+func (queue *jobQueue) stop() {
+	queue.stopOnce.Do(func() {
+		close(queue.done)
+		queue.mutex.Lock()
+		jobs := make([]*scanJob, 0, len(queue.jobs))
+		for _, job := range queue.jobs {
+			jobs = append(jobs, job)
+		}
+		queue.mutex.Unlock()
+		for _, job := range jobs {
+			queue.cancelJob(job)
+		}
+	})
+}
+
+// a random job ID of 16 bytes, hex encoded
+func newJobID() (string, error) {
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(id), nil
+}
+
+// queue a job for the caller, the job is rejected if the queue is full
+func (queue *jobQueue) submit(request models.ScanJobRequest, caller string) (models.ScanJob, error) {
+
+	id, err := newJobID()
+	if err != nil {
+		return models.ScanJob{}, err
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	job := &scanJob{
+		request:  request,
+		caller:   caller,
+		progress: &services.ScanProgress{},
+		ctx:      ctx,
+		cancel:   cancel,
+		status: models.ScanJob{
+			ID:          id,
+			State:       models.JobState_QUEUED,
+			Packages:    request.Packages,
+			SubmittedAt: queue.now(),
+		},
+	}
+
+	queue.mutex.Lock()
+	defer queue.mutex.Unlock()
+	queue.removeExpired()
+	select {
+	case queue.pending <- job:
+	default:
+		cancel()
+		return models.ScanJob{}, errors.New("The job queue is full, try again later")
+	}
+	queue.jobs[id] = job
+	return job.status, nil
+}
+
+// remove the jobs that finished before the retention, and the oldest finished jobs over
+// maxRetained. The mutex must be held
+func (queue *jobQueue) removeExpired() {
+	expired := queue.now().Add(-queue.retention)
+	var finished []*scanJob
+	for id, job := range queue.jobs {
+		if job.status.FinishedAt.IsZero() {
+			continue
+		}
+		if job.status.FinishedAt.Before(expired) {
+			delete(queue.jobs, id)
+		} else {
+			finished = append(finished, job)
+		}
+	}
+	if len(finished) <= queue.maxRetained {
+		return
+	}
+	sort.Slice(finished, func(i, j int) bool {
+		return finished[i].status.FinishedAt.Before(finished[j].status.FinishedAt)
+	})
+	for _, job := range finished[:len(finished)-queue.maxRetained] {
+		delete(queue.jobs, job.status.ID)
+	}
+}
+
+// the job with the ID, only found for the caller that submitted it
+func (queue *jobQueue) job(id string, caller string) (*scanJob, bool) {
+	queue.mutex.Lock()
+	defer queue.mutex.Unlock()
+	job, found := queue.jobs[id]
+	if !found || job.caller != caller {
+		return nil, false
+	}
+	return job, true
+}
+
+// the state of a job with its progress
+func (queue *jobQueue) status(job *scanJob) models.ScanJob {
+	queue.mutex.Lock()
+	defer queue.mutex.Unlock()
+	status := job.status
+	status.Progress = job.progress.Snapshot()
+	return status
+}
+
+// run a job unless it was cancelled while it was queued. The job waits for a slot of
+// MaxConcurrentScans first, and stays queued meanwhile
+func (queue *jobQueue) run(job *scanJob) {
+
+	if queue.slots != nil {
+		select {
+		case queue.slots <- struct{}{}:
+			defer func() { <-queue.slots }()
+		case <-job.ctx.Done():
+			return // cancelled while queued
+		}
+	}
+
+	queue.mutex.Lock()
+	if job.status.State != models.JobState_QUEUED {
+		queue.mutex.Unlock()
+		return
+	}
+	job.status.State = models.JobState_RUNNING
+	job.status.StartedAt = queue.now()
+	queue.mutex.Unlock()
+
+	ctx, cancel := context.WithTimeout(job.ctx, queue.timeout)
+	defer cancel()
+	defer job.cancel() // releases the context of the job, its state is not changed
+	scanner := queue.scanner()
+	scanner.Progress = job.progress
+	start := time.Now()
+	var result interface{}
+	var err error
+	if job.request.Packages {
+		result, err = scanner.ExtractRelevantCommentsForPattern(ctx, job.request.Request)
+	} else {
+		result, err = scanner.ExtractRelevantComments(ctx, job.request.Request)
+	}
+	measurement := queue.measure
+	if len(job.caller) > 0 {
+		measurement = measurement.WithCaller(job.caller)
+	}
+	measurement.Log("/jobs", time.Since(start).Nanoseconds()/1000000)
+	countCache(queue.counts, job.caller, scanner)
+
+	queue.mutex.Lock()
+	defer queue.mutex.Unlock()
+	defer queue.removeExpired()
+	job.status.FinishedAt = queue.now()
+	if incomplete, ok := err.(services.IncompleteScanError); ok {
+		job.status.Incomplete = &incomplete.Result
+	}
+	switch {
+	case err == nil:
+		job.status.State = models.JobState_SUCCEEDED
+		job.result = result
+	case job.status.State == models.JobState_CANCELLED:
+		// cancelled while running, the state was already set
+		job.err = err
+	default:
+		queue.logging.Warning("The job %s failed: %v", job.status.ID, err)
+		job.status.State = models.JobState_FAILED
+		job.status.Error = err.Error()
+		job.err = err
+		// like the synchronous endpoints, only the errors caused by the request are described
+		if errPkg := serviceError(err); !queue.development && errPkg.httpStatus == 500 && !errPkg.isSanitized {
+			job.status.Error = "An internal server has occurred, please contact support@corporate.biz"
+		}
+	}
+}
+
+// cancel a queued or running job. A running job is cancelled once its scan stops
+func (queue *jobQueue) cancelJob(job *scanJob) models.ScanJob {
+	queue.mutex.Lock()
+	if job.status.State == models.JobState_QUEUED || job.status.State == models.JobState_RUNNING {
+		if job.status.State == models.JobState_QUEUED {
+			job.status.FinishedAt = queue.now()
+		}
+		job.status.State = models.JobState_CANCELLED
+		job.cancel()
+	}
+	queue.mutex.Unlock()
+	return queue.status(job)
+}
+
+// Represents an action on the scan jobs of the server, the job is nil when submitting
+type apiJobAction func(writer http.ResponseWriter, body []byte, jobs *jobQueue, job *scanJob, caller string) ErrorPkg
+
+// basic handling for the actions on the scan jobs of the queue of the configuration, finding
+// the job of the path before calling the action. Jobs are only found for the caller that
+// submitted them
+func baseJobHandler(
+	handler apiJobAction,
+	config Configuration,
+	logging logging.Logging,
+	measurement Measurement) http.HandlerFunc {
+
+	// the handlers of the jobs share the queue of the server
+	jobs := config.jobs
+	return func(writer http.ResponseWriter, request *http.Request) {
+		logging, measurement := callerInstrumentation(request, logging, measurement)
+		if jobs == nil {
+			config.errorPkgHandle(ErrorWithCodeSantized(
+				http.StatusNotFound, errors.New("The server does not run scan jobs")), writer, logging)
+			return
+		}
+
+		requestBody, err := ioutil.ReadAll(request.Body)
+		defer request.Body.Close()
+		if config.errorHandle(err, writer, logging) {
+			return
+		}
+
+		caller := requestCaller(request)
+		var job *scanJob
+		if id, found := mux.Vars(request)["id"]; found {
+			if job, found = jobs.job(id, caller); !found {
+				config.errorPkgHandle(ErrorWithCodeSantized(
+					http.StatusNotFound, fmt.Errorf("The job %s cannot be found", id)), writer, logging)
+				return
+			}
+		}
+
+		// the jobs are measured by the template of their path, not by their ID
+		name := request.URL.Path
+		if route := mux.CurrentRoute(request); route != nil {
+			if template, err := route.GetPathTemplate(); err == nil {
+				name = template
+			}
+		}
+		start := time.Now()
+		errPkg := handler(writer, requestBody, jobs, job, caller)
+		measurement.Log(name, time.Since(start).Nanoseconds()/1000000)
+		config.errorPkgHandle(errPkg, writer, logging)
+	}
+}
+
+// POST "/jobs"
+// Queue a scan job, the body should be a models.ScanJobRequest. The response is the
+// models.ScanJob of the queued job, with a 202 (Accepted)
+func SubmitJobAction(
+	writer http.ResponseWriter,
+	body []byte,
+	jobs *jobQueue,
+	job *scanJob,
+	caller string) ErrorPkg {
+
+	request, errPkg := jobRequestFromBody(body)
+	if errPkg.Error() {
+		return errPkg
+	}
+	// like the synchronous endpoints, an invalid request is a 400 rather than a failed job
+	if err := services.ValidateRequest(request.Request); err != nil {
+		return serviceError(err)
+	}
+
+	status, err := jobs.submit(request, caller)
+	if err != nil {
+		return ErrorWithCodeSantized(http.StatusServiceUnavailable, err)
+	}
+	writer.Header().Set("Location", "/jobs/"+status.ID)
+	writer.WriteHeader(http.StatusAccepted)
+	return writeJson(writer, status)
+}
+
+// GET "/jobs/{id}"
+// The models.ScanJob of a job, with its state and progress
+func JobStatusAction(
+	writer http.ResponseWriter,
+	body []byte,
+	jobs *jobQueue,
+	job *scanJob,
+	caller string) ErrorPkg {
+
+	return writeJson(writer, jobs.status(job))
+}
+
+// GET "/jobs/{id}/result"
+// The result of a job that succeeded, a models.MultiPackageParsingResult if the job scanned
+// several packages and a models.CommentParsingResult otherwise. A job that failed results in
+// the error of its scan, like the synchronous endpoints
+func JobResultAction(
+	writer http.ResponseWriter,
+	body []byte,
+	jobs *jobQueue,
+	job *scanJob,
+	caller string) ErrorPkg {
+
+	jobs.mutex.Lock()
+	status, result, err := job.status, job.result, job.err
+	jobs.mutex.Unlock()
+
+	switch status.State {
+	case models.JobState_SUCCEEDED:
+		return writeJson(writer, result)
+	case models.JobState_FAILED:
+		return serviceError(err)
+	case models.JobState_CANCELLED:
+		return ErrorWithCodeSantized(http.StatusConflict, fmt.Errorf("The job %s was cancelled", status.ID))
+	}
+	return ErrorWithCodeSantized(http.StatusConflict, fmt.Errorf("The job %s is %s", status.ID, status.State))
+}
+
+// DELETE "/jobs/{id}"
+// Cancel a queued or running job, the response is its models.ScanJob. Finished jobs are
+// left as they are
+func CancelJobAction(
+	writer http.ResponseWriter,
+	body []byte,
+	jobs *jobQueue,
+	job *scanJob,
+	caller string) ErrorPkg {
+
+	return writeJson(writer, jobs.cancelJob(job))
+}
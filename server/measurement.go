@@ -1,16 +1,80 @@
 package server
 
-import "cloud.google.com/go/logging"
+import (
+	"cloud.google.com/go/logging"
+	"sync"
+	"time"
+)
+
+// the interval between the reports of the counts of the requests and the gauges of the server
+const countReportInterval = time.Minute
 
 // Create a generic interface that allows logging measurement
 type Measurement interface {
-	Log(name string, timeMillis int64) // log a measurement
+	Log(name string, timeMillis int64)    // log a measurement
+	Count(name string, count int64)       // log a count, such as the cache hits of a request
+	WithCaller(caller string) Measurement // the measurement of the requests of an authenticated caller
 }
 
 // a model that represents a single measurement
 type MeasurementModel struct {
-	Name string // a name to identify the measurement
-	Time int64  // the time taken for the code block being measured to execute
+	Name   string // a name to identify the measurement
+	Time   int64  // the time taken for the code block being measured to execute
+	Caller string // the name of the API key of the request, empty if not authenticated
+}
+
+// a model that represents a single count
+type CountModel struct {
+	Name   string // a name to identify the count
+	Count  int64  // the number of occurrences counted
+	Caller string // the name of the API key of the request, empty if not authenticated
+}
+
+// the name and caller of a count
+type countKey struct {
+	name   string // the name of the count
+	caller string // the name of the API key of the requests counted, empty if not authenticated
+}
+
+// sums the counts of the requests, such as the rejected requests, until they are reported, so
+// the requests do not each log a measurement. A requestCounts is safe for concurrent use, and
+// a nil one counts nothing
+type requestCounts struct {
+	mutex  sync.Mutex         // guards counts
+	counts map[countKey]int64 // the sums since the last report
+}
+
+// create a new requestCounts without any count
+func newRequestCounts() *requestCounts {
+	return &requestCounts{counts: make(map[countKey]int64)}
+}
+
+// add to the count of a name for a caller
+func (counts *requestCounts) add(name string, caller string, count int64) {
+	if counts == nil {
+		return
+	}
+	counts.mutex.Lock()
+	defer counts.mutex.Unlock()
+	counts.counts[countKey{name: name, caller: caller}] += count
+}
+
+// log the sums counted since the last report and start again from zero
+func (counts *requestCounts) report(measurement Measurement) {
+	if counts == nil {
+		return
+	}
+	counts.mutex.Lock()
+	sums := counts.counts
+	counts.counts = make(map[countKey]int64)
+	counts.mutex.Unlock()
+	for key, count := range sums {
+		if len(key.caller) > 0 {
+			measurement.WithCaller(key.caller).Count(key.name, count)
+		} else {
+			measurement.Count(key.name, count)
+		}
+	}
 }
 
 // blank measurement for development mode
@@ -30,11 +94,22 @@ func (m MeasurementBlank) Log(name string, timeMillis int64) {
 	// do nothing
 }
 
+// log a count
+func (m MeasurementBlank) Count(name string, count int64) {
+	// do nothing
+}
+
+// the measurement of the requests of a caller
+func (m MeasurementBlank) WithCaller(caller string) Measurement {
+	return m
+}
+
 // a implementation to provide Stackdriver measurement logging
 type MeasurementStackdriver struct {
 	flushSize int64
 	logCount  int64
 	logger    *logging.Logger
+	caller    string // the caller of the measured requests, empty if not authenticated
 }
 
 // create a new instace of the MeasurementStackdriver
@@ -51,8 +126,9 @@ func (m MeasurementStackdriver) Log(name string, timeMillis int64) {
 
 	m.logger.Log(logging.Entry{
 		Payload: MeasurementModel{
-			Name: name,
-			Time: timeMillis,
+			Name:   name,
+			Time:   timeMillis,
+			Caller: m.caller,
 		},
 	})
 
@@ -62,3 +138,27 @@ func (m MeasurementStackdriver) Log(name string, timeMillis int64) {
 		m.logCount = 0
 	}
 }
+
+// log a count
+func (m MeasurementStackdriver) Count(name string, count int64) {
+
+	m.logger.Log(logging.Entry{
+		Payload: CountModel{
+			Name:   name,
+			Count:  count,
+			Caller: m.caller,
+		},
+	})
+
+	m.logCount += 1
+	if m.logCount >= m.flushSize {
+		m.logger.Flush()
+		m.logCount = 0
+	}
+}
+
+// the measurement of the requests of a caller
+func (m MeasurementStackdriver) WithCaller(caller string) Measurement {
+	m.caller = caller
+	return m
+}
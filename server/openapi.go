@@ -0,0 +1,237 @@
+package server
+
+import (
+	"commentparser/models"
+	"commentparser/services"
+	"commentparser/signing"
+	"context"
+	"net/http"
+	"net/url"
+	"reflect"
+	"time"
+)
+
+// the version of the OpenAPI specification the document follows
+const openAPIVersion = "3.0.3"
+
+// the version of the API described by the document
+const apiVersion = "1.0.0"
+
+// a query parameter of GET "/", in the order of the document
+type queryParameter struct {
+	name        string // the name of the parameter
+	schema      string // the OpenAPI type of the parameter: string, boolean, integer or array of strings
+	description string // what the parameter selects, referring to the field of the request
+}
+
+// the query parameters of GET "/", see requestFromQuery
+var indexQueryParameters = []queryParameter{
+	{"package", "string", "the package to scan (PackageName), required unless directory is given"},
+	{"directory", "string", "a directory to scan instead of a package (Directory)"},
+	{"tokens", "array", "the comma seperated tokens to search for (Tokens), or one token per parameter when repeated or in the regex and query modes"},
+	{"repeated", "boolean", "take every tokens parameter as a single token, even when there is only one"},
+	{"mode", "string", "how the tokens are matched (MatchMode): literal, regex, query or annotation"},
+	{"ignorecase", "boolean", "match the tokens regardless of letter case (IgnoreCase)"},
+	{"wholeword", "boolean", "only match whole words (WholeWord)"},
+	{"files", "string", "the comma seperated classes of files to scan (FileClasses)"},
+	{"goos", "string", "the operating system files are selected for (GOOS)"},
+	{"goarch", "string", "the architecture files are selected for (GOARCH)"},
+	{"tags", "string", "the comma seperated additional build tags (BuildTags)"},
+	{"cgo", "boolean", "whether files importing \"C\" are selected (CgoEnabled)"},
+	{"allplatforms", "boolean", "select the files of every known platform (AllPlatforms)"},
+	{"context", "integer", "the number of source lines returned around each comment (ContextLines)"},
+	{"declarations", "string", "the comma seperated kinds of declarations to report (DeclarationKinds)"},
+	{"exported", "boolean", "only report the comments of exported declarations (ExportedOnly)"},
+	{"sort", "string", "the comma seperated keys the matches are sorted by (SortBy)"},
+	{"blame", "boolean", "annotate the matches with the git commit of their line (Blame)"},
+}
+
+// the headers of a signed request, see signing.SignRequest
+var signatureHeaders = []queryParameter{
+	{signing.Header_KEY_ID, "string", "the ID of the key the request is signed with, required when the server has SigningKeys"},
+	{signing.Header_TIMESTAMP, "integer", "when the request was signed in seconds since the Unix epoch, required when the server has SigningKeys"},
+	{signing.Header_NONCE, "string", "a random value never used twice with the same key, required when the server has SigningKeys"},
+	{signing.Header_SIGNATURE, "string", "the hex encoded HMAC-SHA256 of the method, path, query, SHA-256 of the body, timestamp and nonce of the request, each on its own line, required when the server has SigningKeys"},
+}
+
+// the schema of a Go type, adding the schemas of the structs it refers to to schemas. Structs
+// are referenced by name and time.Time is a date-time string, like encoding/json serializes it
+func typeSchema(t reflect.Type, schemas map[string]interface{}) map[string]interface{} {
+
+	switch t.Kind() {
+	case reflect.Bool:
+		return map[string]interface{}{"type": "boolean"}
+	case reflect.Int, reflect.Int32:
+		return map[string]interface{}{"type": "integer", "format": "int32"}
+	case reflect.Int64:
+		return map[string]interface{}{"type": "integer", "format": "int64"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]interface{}{"type": "number", "format": "double"}
+	case reflect.String:
+		return map[string]interface{}{"type": "string"}
+	case reflect.Slice:
+		return map[string]interface{}{"type": "array", "items": typeSchema(t.Elem(), schemas)}
+	case reflect.Map:
+		return map[string]interface{}{"type": "object", "additionalProperties": typeSchema(t.Elem(), schemas)}
+	case reflect.Ptr:
+		// a reference cannot have siblings in OpenAPI 3.0, so it is wrapped to be nullable
+		return map[string]interface{}{"nullable": true, "allOf": []interface{}{typeSchema(t.Elem(), schemas)}}
+	case reflect.Struct:
+		if t == reflect.TypeOf(time.Time{}) {
+			return map[string]interface{}{"type": "string", "format": "date-time"}
+		}
+		if _, found := schemas[t.Name()]; !found {
+			schemas[t.Name()] = nil // the struct can refer to itself
+			properties := make(map[string]interface{})
+			for idx := 0; idx < t.NumField(); idx++ {
+				field := t.Field(idx)
+				if field.PkgPath == "" {
+					properties[field.Name] = typeSchema(field.Type, schemas)
+				}
+			}
+			schemas[t.Name()] = map[string]interface{}{"type": "object", "properties": properties}
+		}
+		return map[string]interface{}{"$ref": "#/components/schemas/" + t.Name()}
+	}
+	return map[string]interface{}{}
+}
+
+// the OpenAPI 3 document describing GET "/" and POST "/parse", with the schemas of their
+// models derived from the models package so the document follows their changes
+func openAPIDocument() map[string]interface{} {
+
+	schemas := make(map[string]interface{})
+	requestSchema := typeSchema(reflect.TypeOf(models.CommentParsingRequest{}), schemas)
+	resultSchema := typeSchema(reflect.TypeOf(models.CommentParsingResult{}), schemas)
+	incompleteSchema := typeSchema(reflect.TypeOf(models.IncompleteScanResult{}), schemas)
+	authenticationSchema := typeSchema(reflect.TypeOf(models.AuthenticationError{}), schemas)
+
+	text := map[string]interface{}{
+		"text/plain": map[string]interface{}{"schema": map[string]interface{}{"type": "string"}},
+	}
+	responses := map[string]interface{}{
+		"200": map[string]interface{}{
+			"description": "The matches of the package",
+			"content":     map[string]interface{}{"application/json": map[string]interface{}{"schema": resultSchema}},
+		},
+		"400": map[string]interface{}{
+			"description": "The request is not valid, such as a token that is not a valid regular expression",
+			"content":     text,
+		},
+		"500": map[string]interface{}{
+			"description": "The package cannot be scanned, such as a package that cannot be found",
+			"content":     text,
+		},
+		"504": map[string]interface{}{
+			"description": "The scan was stopped by its timeout before it completed",
+			"content":     map[string]interface{}{"application/json": map[string]interface{}{"schema": incompleteSchema}},
+		},
+		"401": map[string]interface{}{
+			"description": "The request does not have a valid API key, when the server has API keys (application/json), " +
+				"or a valid signature, when the server has signing keys (text/plain)",
+			"content": map[string]interface{}{
+				"application/json": map[string]interface{}{"schema": authenticationSchema},
+				"text/plain":       map[string]interface{}{"schema": map[string]interface{}{"type": "string"}},
+			},
+		},
+		"403": map[string]interface{}{
+			"description": "The API key of the request is not granted the parse:read scope",
+			"content":     map[string]interface{}{"application/json": map[string]interface{}{"schema": authenticationSchema}},
+		},
+		"429": map[string]interface{}{
+			"description": "The client sent more requests than its rate limit, or too many requests are being scanned",
+			"headers": map[string]interface{}{
+				"Retry-After": map[string]interface{}{
+					"description": "the number of seconds to wait before sending the request again",
+					"schema":      map[string]interface{}{"type": "integer"},
+				},
+			},
+			"content": text,
+		},
+	}
+
+	// the signature headers are shared by the operations
+	headerParameters := make(map[string]interface{})
+	var headerReferences []interface{}
+	for _, header := range signatureHeaders {
+		headerParameters[header.name] = map[string]interface{}{
+			"name":        header.name,
+			"in":          "header",
+			"required":    false,
+			"description": header.description,
+			"schema":      map[string]interface{}{"type": header.schema},
+		}
+		headerReferences = append(headerReferences,
+			map[string]interface{}{"$ref": "#/components/parameters/" + header.name})
+	}
+
+	var parameters []interface{}
+	for _, param := range indexQueryParameters {
+		parameter := map[string]interface{}{
+			"name":        param.name,
+			"in":          "query",
+			"required":    param.name == "tokens",
+			"description": param.description,
+			"schema":      map[string]interface{}{"type": param.schema},
+		}
+		// the values of an array are repeated parameters, such as tokens=a&tokens=b
+		if param.schema == "array" {
+			parameter["schema"] = map[string]interface{}{"type": "array", "items": map[string]interface{}{"type": "string"}}
+			parameter["style"] = "form"
+			parameter["explode"] = true
+		}
+		parameters = append(parameters, parameter)
+	}
+	parameters = append(parameters, headerReferences...)
+
+	return map[string]interface{}{
+		"openapi": openAPIVersion,
+		"info": map[string]interface{}{
+			"title":   "Comment Parser",
+			"version": apiVersion,
+		},
+		"paths": map[string]interface{}{
+			"/": map[string]interface{}{
+				"get": map[string]interface{}{
+					"operationId": "index",
+					"summary":     "Extract the comments of a package matching the tokens",
+					"parameters":  parameters,
+					"responses":   responses,
+				},
+			},
+			"/parse": map[string]interface{}{
+				"post": map[string]interface{}{
+					"operationId": "parse",
+					"summary":     "Extract the comments of a package matching the tokens of the request",
+					"parameters":  headerReferences,
+					"requestBody": map[string]interface{}{
+						"required": true,
+						"content":  map[string]interface{}{"application/json": map[string]interface{}{"schema": requestSchema}},
+					},
+					"responses": responses,
+				},
+			},
+		},
+		// the API key is optional, the server only requires one when it has APIKeys
+		"security": []interface{}{map[string]interface{}{"apiKey": []interface{}{}}, map[string]interface{}{}},
+		"components": map[string]interface{}{
+			"schemas":    schemas,
+			"parameters": headerParameters,
+			"securitySchemes": map[string]interface{}{
+				"apiKey": map[string]interface{}{"type": "http", "scheme": "bearer"},
+			},
+		},
+	}
+}
+
+// GET "/openapi.json"
+// Describe GET "/" and POST "/parse" as an OpenAPI 3 document
+func OpenAPIAction(
+	ctx context.Context,
+	writer http.ResponseWriter,
+	values url.Values,
+	scanner services.Scanner) ErrorPkg {
+
+	writer.Header().Set("Content-Type", "application/json")
+	return writeJson(writer, openAPIDocument())
+}
@@ -0,0 +1,235 @@
+package server
+
+import (
+	"commentparser/logging"
+	"errors"
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// the tokens of a client, refilled at the rate of the limiter up to its burst
+type tokenBucket struct {
+	tokens  float64   // the requests the client can send now
+	updated time.Time // when tokens was last refilled
+}
+
+// limits the rate of the requests of every client with a token bucket per client. A rateLimiter
+// is safe for concurrent use
+type rateLimiter struct {
+	rate  float64          // the tokens added to a bucket per second
+	burst float64          // the maximum tokens of a bucket
+	now   func() time.Time // the time of the server
+
+	mutex     sync.Mutex              // guards the fields below
+	buckets   map[string]*tokenBucket // the buckets by client
+	nextSweep time.Time               // when the full buckets are removed next
+}
+
+// create a new rateLimiter allowing each client rate requests per second, and burst requests
+// at once
+func newRateLimiter(rate float64, burst int) *rateLimiter {
+	return &rateLimiter{
+		rate:    rate,
+		burst:   float64(burst),
+		now:     time.Now,
+		buckets: make(map[string]*tokenBucket),
+	}
+}
+
+// take a token of the client, if it has none the request is not allowed and can be retried
+// after the returned duration
+func (limiter *rateLimiter) allow(client string) (bool, time.Duration) {
+
+	now := limiter.now()
+	limiter.mutex.Lock()
+	defer limiter.mutex.Unlock()
+
+	// the buckets that were refilled are the same as new ones, so they are removed
+	if now.After(limiter.nextSweep) {
+		for key, bucket := range limiter.buckets {
+			if limiter.refill(bucket, now) >= limiter.burst {
+				delete(limiter.buckets, key)
+			}
+		}
+		limiter.nextSweep = now.Add(time.Minute)
+	}
+
+	bucket, found := limiter.buckets[client]
+	if !found {
+		bucket = &tokenBucket{tokens: limiter.burst, updated: now}
+		limiter.buckets[client] = bucket
+	}
+	if limiter.refill(bucket, now) < 1 {
+		wait := (1 - bucket.tokens) / limiter.rate
+		return false, time.Duration(wait * float64(time.Second))
+	}
+	bucket.tokens--
+	return true, 0
+}
+
+// add the tokens of the time elapsed since the last refill of the bucket, and return its tokens
+func (limiter *rateLimiter) refill(bucket *tokenBucket, now time.Time) float64 {
+	if elapsed := now.Sub(bucket.updated).Seconds(); elapsed > 0 {
+		bucket.tokens = math.Min(limiter.burst, bucket.tokens+elapsed*limiter.rate)
+		bucket.updated = now
+	}
+	return bucket.tokens
+}
+
+// the number of clients whose bucket is not full
+func (limiter *rateLimiter) clients() int {
+	limiter.mutex.Lock()
+	defer limiter.mutex.Unlock()
+	return len(limiter.buckets)
+}
+
+// the rate limiter of the configuration, nil if the requests are not rate limited
+func (config *Configuration) rateLimiter() *rateLimiter {
+	if config.RateLimitPerSecond <= 0 {
+		return nil
+	}
+	burst := config.RateLimitBurst
+	if burst < 1 {
+		burst = int(math.Ceil(config.RateLimitPerSecond))
+	}
+	return newRateLimiter(config.RateLimitPerSecond, burst)
+}
+
+// the client a request is rate limited as: the name of its API key when it has a known one,
+// else its IP address. Unknown keys are not trusted so they cannot be rotated to avoid the limit
+func (config *Configuration) rateLimitClient(request *http.Request) (string, bool) {
+	if key, found := config.apiKeys[HashAPIKey(requestAPIKey(request))]; found {
+		return "key:" + key.Name, true
+	}
+	host, _, err := net.SplitHostPort(request.RemoteAddr)
+	if err != nil {
+		host = request.RemoteAddr
+	}
+	return "ip:" + host, false
+}
+
+// reject a request with a 429 (Too Many Requests) whose Retry-After header tells when to retry
+func (config *Configuration) tooManyRequests(
+	writer http.ResponseWriter,
+	retryAfter time.Duration,
+	err error,
+	logging logging.Logging) {
+
+	seconds := int(math.Ceil(retryAfter.Seconds()))
+	if seconds < 1 {
+		seconds = 1
+	}
+	writer.Header().Set("Retry-After", strconv.Itoa(seconds))
+	config.errorPkgHandle(ErrorWithCodeSantized(http.StatusTooManyRequests, err), writer, logging)
+}
+
+// Reject the requests of the clients over the rate limit with a 429 (Too Many Requests). The
+// rejections are counted until the next report of the counts, see reportCounts
+func (config *Configuration) limitRequests(
+	next http.Handler,
+	logging logging.Logging) http.Handler {
+
+	limiter := config.rateLimits
+	if limiter == nil {
+		limiter = config.rateLimiter()
+	}
+	if limiter == nil {
+		return next
+	}
+
+	return http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+
+		client, known := config.rateLimitClient(request)
+		caller := ""
+		if known {
+			caller = client[len("key:"):]
+		}
+
+		allowed, retryAfter := limiter.allow(client)
+		if !allowed {
+			config.counts.add("rate limited", caller, 1)
+			config.tooManyRequests(writer, retryAfter, fmt.Errorf(
+				"Too many requests, the limit is %v requests per second", config.RateLimitPerSecond), logging)
+			return
+		}
+
+		next.ServeHTTP(writer, request)
+	})
+}
+
+// the slots of the scans run at once, shared by the scanning requests and the scan jobs. nil
+// if the scans are not limited
+func (config *Configuration) scanSlots() chan struct{} {
+	if config.MaxConcurrentScans < 1 {
+		return nil
+	}
+	return make(chan struct{}, config.MaxConcurrentScans)
+}
+
+// Reject the scanning requests received while MaxConcurrentScans scans run, including the
+// running scan jobs, with a 429 (Too Many Requests). The rejections are counted until the next
+// report of the counts, see reportCounts
+func (config *Configuration) limitScans(
+	next http.HandlerFunc,
+	logging logging.Logging) http.HandlerFunc {
+
+	slots := config.scans
+	if slots == nil {
+		return next
+	}
+	return func(writer http.ResponseWriter, request *http.Request) {
+		select {
+		case slots <- struct{}{}:
+			defer func() { <-slots }()
+		default:
+			logging, _ := callerInstrumentation(request, logging, NewBlankMeasurementTool())
+			config.counts.add("concurrency limited", requestCaller(request), 1)
+			config.tooManyRequests(writer, time.Second,
+				errors.New("Too many requests are being scanned, try again later"), logging)
+			return
+		}
+		next(writer, request)
+	}
+}
+
+// log the counts of the requests since the last report, along with the gauges of the server:
+// the number of rate limited clients whose bucket is not full and the number of running scans
+func (config *Configuration) reportCounts(measurement Measurement) {
+	config.counts.report(measurement)
+	if config.rateLimits != nil {
+		measurement.Count("rate limit clients", int64(config.rateLimits.clients()))
+	}
+	if config.scans != nil {
+		measurement.Count("concurrent scans", int64(len(config.scans)))
+	}
+}
+
+// report the counts every countReportInterval until the returned function is called, which
+// reports them a last time
+func (config *Configuration) startCountReports(measurement Measurement) func() {
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		ticker := time.NewTicker(countReportInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				config.reportCounts(measurement)
+			case <-done:
+				config.reportCounts(measurement)
+				return
+			}
+		}
+	}()
+	return func() {
+		close(done)
+		<-stopped
+	}
+}
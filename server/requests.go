@@ -0,0 +1,213 @@
+package server
+
+import (
+	"commentparser/models"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// Decode and validate the models.CommentParsingRequest in the body of a POST request
+func requestFromBody(body []byte) (models.CommentParsingRequest, ErrorPkg) {
+
+	var request models.CommentParsingRequest
+	err := json.Unmarshal(body, &request)
+
+	if err != nil {
+		return request, ErrorWithCodeSantized(400, err)
+	}
+
+	if len(request.PackageName) < 1 && len(request.Directory) < 1 {
+		return request, ErrorWithCodeSantized(
+			400,
+			errors.New("The parameter `PackageName` cannot be empty"))
+	}
+
+	if len(request.Tokens) < 1 {
+		return request, ErrorWithCodeSantized(
+			400,
+			errors.New("The parameter `Tokens` cannot be empty"))
+	}
+
+	return request, ErrorPkg{}
+}
+
+// Decode and validate the models.CommentDiffRequest in the body of a POST request, the
+// package name of a diff can be empty
+func diffRequestFromBody(body []byte) (models.CommentDiffRequest, ErrorPkg) {
+
+	var request models.CommentDiffRequest
+	err := json.Unmarshal(body, &request)
+
+	if err != nil {
+		return request, ErrorWithCodeSantized(400, err)
+	}
+
+	if len(request.Request.Tokens) < 1 {
+		return request, ErrorWithCodeSantized(
+			400,
+			errors.New("The parameter `Tokens` cannot be empty"))
+	}
+
+	return request, ErrorPkg{}
+}
+
+// Decode and validate the models.ScanJobRequest in the body of a POST request
+func jobRequestFromBody(body []byte) (models.ScanJobRequest, ErrorPkg) {
+
+	var request models.ScanJobRequest
+	err := json.Unmarshal(body, &request)
+
+	if err != nil {
+		return request, ErrorWithCodeSantized(400, err)
+	}
+
+	if len(request.Request.PackageName) < 1 && len(request.Request.Directory) < 1 {
+		return request, ErrorWithCodeSantized(
+			400,
+			errors.New("The parameter `PackageName` cannot be empty"))
+	}
+
+	if len(request.Request.Tokens) < 1 {
+		return request, ErrorWithCodeSantized(
+			400,
+			errors.New("The parameter `Tokens` cannot be empty"))
+	}
+
+	return request, ErrorPkg{}
+}
+
+// Build and validate a models.CommentParsingRequest from the query of a GET request, which
+// names either a package or a directory
+func requestFromQuery(values url.Values) (models.CommentParsingRequest, ErrorPkg) {
+
+	qPackage := values.Get("package")
+	qDirectory := values.Get("directory")
+	if len(qPackage) < 1 && len(qDirectory) < 1 {
+		return models.CommentParsingRequest{}, ErrorWithCodeSantized(
+			400, errors.New("the query must contain the parameter `package`"))
+	}
+
+	request, errPkg := requestOptionsFromQuery(values)
+	request.PackageName = qPackage
+	request.Directory = qDirectory
+	return request, errPkg
+}
+
+// Build and validate the tokens and options of a models.CommentParsingRequest from a query,
+// without the package to scan
+func requestOptionsFromQuery(values url.Values) (models.CommentParsingRequest, ErrorPkg) {
+
+	var request models.CommentParsingRequest
+	qTokens := values.Get("tokens")
+	if len(qTokens) < 1 {
+		return request, ErrorWithCodeSantized(400, errors.New("the query must contain the parameter `tokens`"))
+	}
+	ignoreCase, err := boolQueryParam(values, "ignorecase")
+	if err != nil {
+		return request, ErrorWithCodeSantized(400, err)
+	}
+	wholeWord, err := boolQueryParam(values, "wholeword")
+	if err != nil {
+		return request, ErrorWithCodeSantized(400, err)
+	}
+	allPlatforms, err := boolQueryParam(values, "allplatforms")
+	if err != nil {
+		return request, ErrorWithCodeSantized(400, err)
+	}
+	contextLines, err := intQueryParam(values, "context")
+	if err != nil {
+		return request, ErrorWithCodeSantized(400, err)
+	}
+	exportedOnly, err := boolQueryParam(values, "exported")
+	if err != nil {
+		return request, ErrorWithCodeSantized(400, err)
+	}
+	blame, err := boolQueryParam(values, "blame")
+	if err != nil {
+		return request, ErrorWithCodeSantized(400, err)
+	}
+	tokens, err := tokensFromQuery(values)
+	if err != nil {
+		return request, ErrorWithCodeSantized(400, err)
+	}
+	request = models.CommentParsingRequest{
+		Tokens:       tokens,
+		MatchMode:    values.Get("mode"),
+		IgnoreCase:   ignoreCase,
+		WholeWord:    wholeWord,
+		GOOS:         values.Get("goos"),
+		GOARCH:       values.Get("goarch"),
+		AllPlatforms: allPlatforms,
+		ContextLines: contextLines,
+		ExportedOnly: exportedOnly,
+		Blame:        blame,
+	}
+	if qFiles := values.Get("files"); len(qFiles) > 0 {
+		request.FileClasses = strings.Split(qFiles, ",")
+	}
+	if qKinds := values.Get("declarations"); len(qKinds) > 0 {
+		request.DeclarationKinds = strings.Split(qKinds, ",")
+	}
+	if qSort := values.Get("sort"); len(qSort) > 0 {
+		request.SortBy = strings.Split(qSort, ",")
+	}
+	if qTags := values.Get("tags"); len(qTags) > 0 {
+		request.BuildTags = strings.Split(qTags, ",")
+	}
+	// unlike the other options, cgo is only overridden when the parameter is given
+	if len(values.Get("cgo")) > 0 {
+		cgoEnabled, err := boolQueryParam(values, "cgo")
+		if err != nil {
+			return request, ErrorWithCodeSantized(400, err)
+		}
+		request.CgoEnabled = &cgoEnabled
+	}
+	return request, ErrorPkg{}
+}
+
+// The tokens of a query. Only a single `tokens` parameter of the literal and annotation modes
+// is comma seperated, repeated parameters, the parameters marked with a true `repeated` and the
+// regular expressions and queries of the other modes are taken as they are, so they can
+// contain commas
+func tokensFromQuery(values url.Values) ([]string, error) {
+	repeated, err := boolQueryParam(values, "repeated")
+	if err != nil {
+		return nil, err
+	}
+	mode := values.Get("mode")
+	if len(values["tokens"]) > 1 || repeated ||
+		mode == models.MatchMode_REGEX || mode == models.MatchMode_QUERY {
+		return values["tokens"], nil
+	}
+	return strings.Split(values.Get("tokens"), ","), nil
+}
+
+// Read an optional boolean query parameter, a missing parameter is false
+func boolQueryParam(values url.Values, name string) (bool, error) {
+	value := values.Get(name)
+	if len(value) < 1 {
+		return false, nil
+	}
+	parsed, err := strconv.ParseBool(value)
+	if err != nil {
+		return false, fmt.Errorf("the query parameter `%s` must be a boolean", name)
+	}
+	return parsed, nil
+}
+
+// Read an optional integer query parameter, a missing parameter is 0
+func intQueryParam(values url.Values, name string) (int, error) {
+	value := values.Get(name)
+	if len(value) < 1 {
+		return 0, nil
+	}
+	parsed, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, fmt.Errorf("the query parameter `%s` must be an integer", name)
+	}
+	return parsed, nil
+}
@@ -9,96 +9,338 @@ import (
 	"commentparser/logging"
 	"commentparser/models"
 	"commentparser/services"
+	"context"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"github.com/gorilla/mux"
 	"io/ioutil"
 	"net/url"
-	"strings"
 	"time"
 )
 
 // this is the model representing the configuration options that the server uses
 type Configuration struct {
-	Development          bool   // true if the application is in development mode, false in production
-	Address              string // the address to bind the server to
-	LogName              string // path to log to
-	GoogleCloudProjectID string // the google cloud project ID
-	GoogleCloudCredFile  string // google cloud API credentials file
+	Development            bool              // true if the application is in development mode, false in production
+	Address                string            // the address to bind the server to
+	LogName                string            // path to log to
+	GoogleCloudProjectID   string            // the google cloud project ID
+	GoogleCloudCredFile    string            // google cloud API credentials file
+	WorkingDirectory       string            // the directory packages are resolved from, such as the root of a module, defaults to the process working directory
+	AllowDirectoryScans    bool              // if true, requests can scan any directory of the server with their Directory
+	ArchiveMaxBytes        int64             // the maximum size of an uploaded archive, 32MB if not set
+	ArchiveMaxFiles        int               // the maximum number of files in an uploaded archive, see services.DefaultArchiveLimits
+	ArchiveMaxExtracted    int64             // the maximum extracted size of an uploaded archive, see services.DefaultArchiveLimits
+	ScanWorkers            int               // the maximum number of files or packages a request processes concurrently, the number of CPUs if not set
+	ScanTimeoutSeconds     int               // the maximum duration of the scan of a request in seconds, 10 if not set
+	ParseCacheEntries      int               // the number of parsed files kept in memory, see services.DefaultParseCacheEntries, negative to disable the cache
+	ParseCacheDirectory    string            // the directory parsed files are persisted to, empty to only keep them in memory
+	IndexPatterns          []string          // the packages indexed when the server starts, such as "./...", none if empty
+	IndexDirectory         string            // the directory the index is persisted to, empty to only keep it in memory
+	IndexRefreshSeconds    int               // the interval between the incremental updates of the index in seconds, 60 if not set
+	SigningKeys            map[string]string // the secrets requests are signed with by key ID, requests are not verified if empty
+	SignatureMaxAgeSeconds int               // how far the signature timestamp of a request can be from the time of the server in seconds, 300 if not set
+	APIKeys                []APIKey          // the API keys requests are authenticated with, requests are not authenticated if there are none
+	APIKeyFile             string            // a json file of more API keys, read when the server starts
+	RateLimitPerSecond     float64           // the requests per second each API key or IP address can send, not limited if 0
+	RateLimitBurst         int               // the requests each API key or IP address can send at once, RateLimitPerSecond rounded up if not set
+	MaxConcurrentScans     int               // the maximum number of scanning requests and scan jobs run at once, not limited if 0
+	JobWorkers             int               // the number of scan jobs run at once, 1 if not set
+	JobQueueSize           int               // the maximum number of scan jobs waiting to run, 100 if not set
+	JobTimeoutSeconds      int               // the maximum duration of a scan job in seconds, 600 if not set
+	JobRetentionSeconds    int               // how long the finished scan jobs are kept in seconds, 3600 if not set
+	JobMaxRetained         int               // the maximum number of finished scan jobs kept, the oldest are removed first, 1000 if not set
+
+	parseCache *services.ParseCache // the cache shared by the scans of every request, created when the server starts
+	index      *services.Index      // the index answering the requests for indexed packages, created when the server starts
+	signatures *signatureVerifier   // verifies the signatures of every request and remembers their nonces, created when the server starts
+	apiKeys    map[string]APIKey    // the API keys of the configuration and of its key file by hash, loaded when the server starts
+	rateLimits *rateLimiter         // the token buckets of the clients, created when the server starts
+	jobs       *jobQueue            // the scan jobs run in the background, created when the server starts
+	scans      chan struct{}        // the slots of the scans run at once, nil if not limited, created when the server starts
+	counts     *requestCounts       // the counts of the requests reported periodically, nil if not counted, created when the server starts
+}
+
+// the default maximum size of an uploaded archive
+const defaultArchiveMaxBytes = 32 << 20
+
+// the default maximum duration of a scan, shorter than the write timeout of the server so the
+// client gets a response
+const defaultScanTimeout = 10 * time.Second
+
+// the minimum time the server has to write its responses
+const defaultWriteTimeout = 15 * time.Second
+
+// how long the response of a stopped scan can take to be encoded and written, the write
+// timeout of the server is at least the scan timeout plus this margin
+const writeTimeoutMargin = 5 * time.Second
+
+// the default interval between the incremental updates of the index
+const defaultIndexRefresh = 60 * time.Second
+
+// create the scanner used by the actions of the server
+func (config *Configuration) scanner(logging logging.Logging) services.Scanner {
+	scanner := services.NewScanner(config.WorkingDirectory, logging)
+	scanner.AllowDirectories = config.AllowDirectoryScans
+	scanner.Workers = config.ScanWorkers
+	if config.parseCache != nil {
+		scanner.Cache = config.parseCache
+		scanner.CacheStats = &services.CacheStats{}
+	}
+	scanner.Index = config.index
+	return scanner
+}
+
+// create the index of the server and keep it up to date in the background, once it is loaded
+// from its directory
+func (config *Configuration) startIndex(logging logging.Logging) *services.Index {
+	scanner := config.scanner(logging)
+	scanner.CacheStats = nil // the hits and misses are only measured for requests
+	index := services.NewIndex(scanner, config.IndexPatterns, config.IndexDirectory)
+	if err := index.Load(); err != nil {
+		logging.Warning("Could not load the index from %s: %v", config.IndexDirectory, err)
+	}
+	refresh := time.Duration(config.IndexRefreshSeconds) * time.Second
+	if refresh <= 0 {
+		refresh = defaultIndexRefresh
+	}
+	go index.Run(context.Background(), refresh)
+	return index
+}
+
+// count the files of the scan of a request that were found in the parse cache, and those parsed,
+// until the next report of the counts
+func countCache(counts *requestCounts, caller string, scanner services.Scanner) {
+	if scanner.CacheStats == nil {
+		return
+	}
+	stats := scanner.CacheStats.Snapshot()
+	counts.add("parse cache hits", caller, stats.Hits)
+	counts.add("parse cache misses", caller, stats.Misses)
+}
+
+// the context of the scan of a request, which is done when the client is gone or when the
+// scan timeout expires
+func (config *Configuration) scanContext(parent context.Context) (context.Context, context.CancelFunc) {
+	return context.WithTimeout(parent, config.scanTimeout())
+}
+
+// the maximum duration of the scan of a request
+func (config *Configuration) scanTimeout() time.Duration {
+	timeout := time.Duration(config.ScanTimeoutSeconds) * time.Second
+	if timeout <= 0 {
+		return defaultScanTimeout
+	}
+	return timeout
+}
+
+// the HTTP server serving the handler. Its write timeout outlasts the scan timeout, so the 504
+// of a scan stopped by its timeout reaches the client
+func (config *Configuration) httpServer(handler http.Handler) *http.Server {
+	writeTimeout := config.scanTimeout() + writeTimeoutMargin
+	if writeTimeout < defaultWriteTimeout {
+		writeTimeout = defaultWriteTimeout
+	}
+	return &http.Server{
+		Handler:      handler,
+		Addr:         config.Address,
+		WriteTimeout: writeTimeout,
+		ReadTimeout:  15 * time.Second,
+	}
+}
+
+// the maximum size of an uploaded archive, and of the body of any request
+func (config *Configuration) archiveMaxBytes() int64 {
+	if config.ArchiveMaxBytes < 1 {
+		return defaultArchiveMaxBytes
+	}
+	return config.ArchiveMaxBytes
+}
+
+// the limits applied to uploaded archives
+func (config *Configuration) archiveLimits() services.ArchiveLimits {
+	return services.ArchiveLimits{
+		MaxFiles:          config.ArchiveMaxFiles,
+		MaxExtractedBytes: config.ArchiveMaxExtracted,
+	}
 }
 
 // POST "/parse"
 // Extract the comments where comments contains the specified tokens in the
 // provided package name, the body should be a models.CommentParsingRequest
 func ParseAction(
+	ctx context.Context,
 	writer http.ResponseWriter,
 	body []byte,
-	logging logging.Logging) ErrorPkg {
+	scanner services.Scanner) ErrorPkg {
 
-	var err error
-	var request models.CommentParsingRequest
-	err = json.Unmarshal(body, &request)
+	request, errPkg := requestFromBody(body)
+	if errPkg.Error() {
+		return errPkg
+	}
+
+	resObj, err := scanner.ExtractRelevantComments(ctx, request)
 
 	if err != nil {
-		return ErrorWithCodeSantized(400, err)
+		return serviceError(err)
+	}
+
+	return writeJson(writer, resObj)
+}
+
+// GET "/"
+// Extract the comments where comments contains the specified tokens in the
+// provided package name
+func IndexAction(
+	ctx context.Context,
+	writer http.ResponseWriter,
+	values url.Values,
+	scanner services.Scanner) ErrorPkg {
+
+	request, errPkg := requestFromQuery(values)
+	if errPkg.Error() {
+		return errPkg
 	}
 
-	if len(request.PackageName) < 1 {
-		return ErrorWithCodeSantized(
-			400,
-			errors.New("The parameter `PackageName` cannot be empty"))
+	resObj, err := scanner.ExtractRelevantComments(ctx, request)
+
+	if err != nil {
+		return serviceError(err)
 	}
 
-	if len(request.Tokens) < 1 {
-		return ErrorWithCodeSantized(
-			400,
-			errors.New("The parameter `Tokens` cannot be empty"))
+	return writeJson(writer, resObj)
+}
+
+// POST "/parse/packages"
+// Extract the comments where comments contains the specified tokens in every package
+// matched by the pattern given as the package name (such as "net/..." or "std"), the
+// body should be a models.CommentParsingRequest
+func ParsePackagesAction(
+	ctx context.Context,
+	writer http.ResponseWriter,
+	body []byte,
+	scanner services.Scanner) ErrorPkg {
+
+	request, errPkg := requestFromBody(body)
+	if errPkg.Error() {
+		return errPkg
 	}
 
-	resObj, err := services.ExtractRelevantComments(request, logging)
+	resObj, err := scanner.ExtractRelevantCommentsForPattern(ctx, request)
 
 	if err != nil {
-		return Error(err)
+		return serviceError(err)
 	}
 
-	res, err := json.Marshal(resObj)
+	return writeJson(writer, resObj)
+}
+
+// GET "/packages"
+// Extract the comments where comments contains the specified tokens in every package
+// matched by the pattern given as the package name (such as "net/..." or "std")
+func PackagesAction(
+	ctx context.Context,
+	writer http.ResponseWriter,
+	values url.Values,
+	scanner services.Scanner) ErrorPkg {
+
+	request, errPkg := requestFromQuery(values)
+	if errPkg.Error() {
+		return errPkg
+	}
+
+	resObj, err := scanner.ExtractRelevantCommentsForPattern(ctx, request)
 
 	if err != nil {
-		return Error(err)
+		return serviceError(err)
 	}
 
-	writer.Write(res)
+	return writeJson(writer, resObj)
+}
 
-	return ErrorPkg{}
+// POST "/parse/diff"
+// Report the matches added, removed and moved between two directories or two git revisions
+// of a repository, the body should be a models.CommentDiffRequest
+func ParseDiffAction(
+	ctx context.Context,
+	writer http.ResponseWriter,
+	body []byte,
+	scanner services.Scanner) ErrorPkg {
+
+	request, errPkg := diffRequestFromBody(body)
+	if errPkg.Error() {
+		return errPkg
+	}
+
+	resObj, err := scanner.DiffComments(ctx, request)
+
+	if err != nil {
+		return serviceError(err)
+	}
+
+	return writeJson(writer, resObj)
 }
 
-// GET "/"
-// Extract the comments where comments contains the specified tokens in the
-// provided package name
-func IndexAction(
+// POST "/parse/archive"
+// Extract the comments where comments contains the specified tokens in every package of
+// the tar.gz or zip archive in the body, the tokens and options are given in the query
+// like for GET "/"
+func ParseArchiveAction(
+	ctx context.Context,
 	writer http.ResponseWriter,
 	values url.Values,
-	logging logging.Logging) ErrorPkg {
+	body []byte,
+	scanner services.Scanner,
+	limits services.ArchiveLimits) ErrorPkg {
 
-	qPackage := values.Get("package")
-	qTokens := values.Get("tokens")
-	if len(qPackage) < 1 {
-		return ErrorWithCodeSantized(400, errors.New("the query must contain the parameter `package`"))
+	request, errPkg := requestOptionsFromQuery(values)
+	if errPkg.Error() {
+		return errPkg
 	}
-	if len(qTokens) < 1 {
-		return ErrorWithCodeSantized(400, errors.New("the query must contain the parameter `tokens`"))
+
+	resObj, err := scanner.ExtractRelevantCommentsFromArchive(ctx, request, body, limits)
+
+	if err != nil {
+		return serviceError(err)
 	}
-	request := models.CommentParsingRequest{
-		PackageName: qPackage,
-		Tokens:      strings.Split(qTokens, ","),
+
+	return writeJson(writer, resObj)
+}
+
+// GET "/admin/index"
+// Report the state of the index of the server, such as when it was last updated
+func IndexStatusAction(
+	ctx context.Context,
+	writer http.ResponseWriter,
+	values url.Values,
+	scanner services.Scanner) ErrorPkg {
+
+	if scanner.Index == nil {
+		return ErrorWithCodeSantized(404, errors.New("The server has no index"))
 	}
+	return writeJson(writer, scanner.Index.Status())
+}
 
-	resObj, err := services.ExtractRelevantComments(request, logging)
+// POST "/admin/index/rebuild"
+// Start rebuilding the index of the server from scratch in the background, and report the
+// state of the index
+func RebuildIndexAction(
+	ctx context.Context,
+	writer http.ResponseWriter,
+	body []byte,
+	scanner services.Scanner) ErrorPkg {
 
-	if err != nil {
-		return Error(err)
+	if scanner.Index == nil {
+		return ErrorWithCodeSantized(404, errors.New("The server has no index"))
 	}
+	// the rebuild outlives the request
+	scanner.Index.Rebuild(context.Background())
+	writer.WriteHeader(http.StatusAccepted)
+	return writeJson(writer, scanner.Index.Status())
+}
 
+// Serialize the result of an action as the json response
+func writeJson(writer http.ResponseWriter, resObj interface{}) ErrorPkg {
 	res, err := json.Marshal(resObj)
 
 	if err != nil {
@@ -110,11 +352,34 @@ func IndexAction(
 	return ErrorPkg{}
 }
 
+// Convert an error returned by the services package into an ErrorPkg, errors caused by the
+// content of the request are sanitized and result in a 400 (Bad Request), and scans stopped
+// by their timeout or by the client result in a 504 (Gateway Timeout) describing how far the
+// scan went
+func serviceError(err error) ErrorPkg {
+	if _, ok := err.(services.InvalidRequestError); ok {
+		return ErrorWithCodeSantized(400, err)
+	}
+	if incomplete, ok := err.(services.IncompleteScanError); ok {
+		return ErrorWithBody(504, err, incomplete.Result)
+	}
+	return Error(err)
+}
+
 // Represents a POST action that handles a request body
-type apiPostAction func(w http.ResponseWriter, body []byte, logging logging.Logging) ErrorPkg
+type apiPostAction func(ctx context.Context, w http.ResponseWriter, body []byte, scanner services.Scanner) ErrorPkg
 
 // Represents a GET action that handles a request body
-type apiGetAction func(w http.ResponseWriter, values url.Values, logging logging.Logging) ErrorPkg
+type apiGetAction func(ctx context.Context, w http.ResponseWriter, values url.Values, scanner services.Scanner) ErrorPkg
+
+// Represents a POST action that handles an uploaded archive alongside the query
+type apiUploadAction func(
+	ctx context.Context,
+	w http.ResponseWriter,
+	values url.Values,
+	body []byte,
+	scanner services.Scanner,
+	limits services.ArchiveLimits) ErrorPkg
 
 // Mask errors and log them at the top level
 func (config *Configuration) errorHandle(
@@ -146,6 +411,14 @@ func (config *Configuration) errorPkgHandle(
 			!err.isSanitized {
 			errorString = "An internal server has occurred, please contact support@corporate.biz"
 		}
+		if err.body != nil {
+			if res, jsonErr := json.Marshal(err.body); jsonErr == nil {
+				writer.Header().Set("Content-Type", "application/json")
+				writer.WriteHeader(err.httpStatus)
+				writer.Write(res)
+				return true
+			}
+		}
 		http.Error(writer, errorString, err.httpStatus)
 		return true
 	}
@@ -160,11 +433,17 @@ func baseGetHandler(
 	logging logging.Logging,
 	measurement Measurement) http.HandlerFunc {
 	return func(writer http.ResponseWriter, request *http.Request) {
+		logging, measurement := callerInstrumentation(request, logging, measurement)
 
 		if request.Method == "GET" {
+			ctx, cancel := config.scanContext(request.Context())
+			defer cancel()
+
+			scanner := config.scanner(logging)
 			start := time.Now()
-			err := hander(writer, request.URL.Query(), logging)
+			err := hander(ctx, writer, request.URL.Query(), scanner)
 			measurement.Log(request.URL.Path, time.Since(start).Nanoseconds()/1000000)
+			countCache(config.counts, requestCaller(request), scanner)
 
 			if config.errorPkgHandle(err, writer, logging) {
 				return
@@ -183,6 +462,7 @@ func basePostHandler(
 	logging logging.Logging,
 	measurement Measurement) http.HandlerFunc {
 	return func(writer http.ResponseWriter, request *http.Request) {
+		logging, measurement := callerInstrumentation(request, logging, measurement)
 		if request.Method == "POST" {
 
 			requestBody, err := ioutil.ReadAll(request.Body)
@@ -192,8 +472,51 @@ func basePostHandler(
 				return
 			}
 
+			ctx, cancel := config.scanContext(request.Context())
+			defer cancel()
+
+			scanner := config.scanner(logging)
+			start := time.Now()
+			errPkg := handler(ctx, writer, requestBody, scanner)
+			measurement.Log(request.URL.Path, time.Since(start).Nanoseconds()/1000000)
+			countCache(config.counts, requestCaller(request), scanner)
+
+			if config.errorPkgHandle(errPkg, writer, logging) {
+				return
+			}
+		} else {
+			http.Error(writer, "Unsupported HTTP method", 422)
+		}
+	}
+}
+
+// basic handling for actions receiving an upload, the size of the body is limited by
+// ArchiveMaxBytes
+func baseUploadHandler(
+	handler apiUploadAction,
+	config Configuration,
+	logging logging.Logging,
+	measurement Measurement) http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		logging, measurement := callerInstrumentation(request, logging, measurement)
+		if request.Method == "POST" {
+
+			maxBytes := config.archiveMaxBytes()
+			requestBody, err := ioutil.ReadAll(http.MaxBytesReader(writer, request.Body, maxBytes))
+			defer request.Body.Close()
+
+			if err != nil {
+				config.errorPkgHandle(ErrorWithCodeSantized(
+					413,
+					fmt.Errorf("The upload cannot be larger than %v bytes", maxBytes)), writer, logging)
+				return
+			}
+
+			ctx, cancel := config.scanContext(request.Context())
+			defer cancel()
+
 			start := time.Now()
-			errPkg := handler(writer, requestBody, logging)
+			errPkg := handler(ctx, writer, request.URL.Query(), requestBody, config.scanner(logging), config.archiveLimits())
 			measurement.Log(request.URL.Path, time.Since(start).Nanoseconds()/1000000)
 
 			if config.errorPkgHandle(errPkg, writer, logging) {
@@ -222,6 +545,48 @@ func commonGetRouteSetup(routes ...*mux.Route) {
 	}
 }
 
+// the routes of the server, behind the rate limit of its clients. The signature verifier, API
+// keys, rate limiter, scan slots and job queue of the configuration must be created first
+func (config Configuration) newRouter(logging logging.Logging, measurement Measurement) http.Handler {
+
+	// the signature is verified first, so the requests that are not signed are rejected before
+	// they are authenticated or take a slot of MaxConcurrentScans
+	parseRead := func(handler http.HandlerFunc) http.HandlerFunc {
+		return config.verifySignatures(config.requireScope(models.Scope_PARSE_READ, handler, logging), logging)
+	}
+	admin := func(handler http.HandlerFunc) http.HandlerFunc {
+		return config.verifySignatures(config.requireScope(models.Scope_ADMIN, handler, logging), logging)
+	}
+	// the requests that scan packages share the slots of MaxConcurrentScans with the jobs
+	scan := func(handler http.HandlerFunc) http.HandlerFunc {
+		return config.limitScans(handler, logging)
+	}
+
+	router := mux.NewRouter().StrictSlash(true)
+	commonPostRouteSetup(
+		router.HandleFunc("/parse", parseRead(scan(basePostHandler(ParseAction, config, logging, measurement)))),
+		router.HandleFunc("/parse/packages", parseRead(scan(basePostHandler(ParsePackagesAction, config, logging, measurement)))),
+		router.HandleFunc("/parse/diff", parseRead(scan(basePostHandler(ParseDiffAction, config, logging, measurement)))),
+		router.HandleFunc("/jobs", parseRead(baseJobHandler(SubmitJobAction, config, logging, measurement))),
+	)
+	router.HandleFunc("/parse/archive", parseRead(scan(baseUploadHandler(ParseArchiveAction, config, logging, measurement)))).
+		Methods("POST")
+	router.HandleFunc("/admin/index/rebuild", admin(basePostHandler(RebuildIndexAction, config, logging, measurement))).
+		Methods("POST")
+	commonGetRouteSetup(
+		router.HandleFunc("/", parseRead(scan(baseGetHandler(IndexAction, config, logging, measurement)))),
+		router.HandleFunc("/packages", parseRead(scan(baseGetHandler(PackagesAction, config, logging, measurement)))),
+		router.HandleFunc("/admin/index", admin(baseGetHandler(IndexStatusAction, config, logging, measurement))),
+		router.HandleFunc("/jobs/{id}", parseRead(baseJobHandler(JobStatusAction, config, logging, measurement))),
+		router.HandleFunc("/jobs/{id}/result", parseRead(baseJobHandler(JobResultAction, config, logging, measurement))),
+		// the document is public so clients can be generated before they have a key
+		router.HandleFunc("/openapi.json", baseGetHandler(OpenAPIAction, config, logging, measurement)),
+	)
+	router.HandleFunc("/jobs/{id}", parseRead(baseJobHandler(CancelJobAction, config, logging, measurement))).
+		Methods("DELETE")
+	return config.limitRequests(router, logging)
+}
+
 // This is the entry point for the server application, will start a server that provides comment parsing
 // as a REST-ful service
 func CommentParserHttpServer(
@@ -229,22 +594,37 @@ func CommentParserHttpServer(
 	logging logging.Logging,
 	measurement Measurement) error {
 
-	router := mux.NewRouter().StrictSlash(true)
-	commonPostRouteSetup(
-		router.HandleFunc("/parse", basePostHandler(ParseAction, config, logging, measurement)),
-	)
-	commonGetRouteSetup(
-		router.HandleFunc("/", baseGetHandler(IndexAction, config, logging, measurement)),
-	)
-	http.Handle("/", router)
-	srv := &http.Server{
-		Handler:      router,
-		Addr:         config.Address,
-		WriteTimeout: 15 * time.Second,
-		ReadTimeout:  15 * time.Second,
+	if config.ParseCacheEntries >= 0 {
+		parseCache, err := services.NewParseCache(config.ParseCacheEntries, config.ParseCacheDirectory)
+		if err != nil {
+			return err
+		}
+		config.parseCache = parseCache
+	}
+	if len(config.IndexPatterns) > 0 {
+		config.index = config.startIndex(logging)
 	}
+	if len(config.SigningKeys) > 0 {
+		config.signatures = newSignatureVerifier(config.SigningKeys, config.signatureMaxAge())
+	}
+	apiKeys, err := config.loadAPIKeys()
+	if err != nil {
+		return err
+	}
+	config.apiKeys = apiKeys
+	config.rateLimits = config.rateLimiter()
+	config.scans = config.scanSlots()
+	config.counts = newRequestCounts()
+	config.jobs = config.startJobs(logging, measurement)
+	stopCountReports := config.startCountReports(measurement)
+
+	handler := config.newRouter(logging, measurement)
+	http.Handle("/", handler)
+	srv := config.httpServer(handler)
 
 	srvError := srv.ListenAndServe()
+	config.jobs.stop()
+	stopCountReports()
 	logging.Critical(srvError.Error())
 	return nil
 }
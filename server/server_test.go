@@ -1,16 +1,29 @@
 package server
 
 import (
+	"archive/zip"
+	"bufio"
 	"bytes"
+	"commentparser/client"
 	"commentparser/logging"
 	"commentparser/models"
+	"commentparser/services"
+	"commentparser/signing"
+	"context"
 	"encoding/json"
 	"fmt"
+	"github.com/gorilla/mux"
 	"github.com/stretchr/testify/assert"
+	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"reflect"
 	"strings"
+	"sync"
 	"testing"
+	"time"
 )
 
 func TestServer_PostParse_Success(t *testing.T) {
@@ -117,6 +130,29 @@ func TestServer_GetIndex_Success(t *testing.T) {
 	assert.Equal(t, 747, res.Matches["TODO"][1].LineNumber)
 }
 
+func TestServer_GetIndex_CacheCounts(t *testing.T) {
+
+	config := Configuration{Development: false, AllowDirectoryScans: true}
+	parseCache, err := services.NewParseCache(100, "")
+	assert.Nil(t, err)
+	config.parseCache = parseCache
+	config.counts = newRequestCounts()
+	handler := http.HandlerFunc(baseGetHandler(IndexAction, config, logging.NewMockLogging(), NewBlankMeasurementTool()))
+
+	for i := 0; i < 2; i++ {
+		req, _ := http.NewRequest("GET", "/?directory=../services/testdata/sample&tokens=TODO", nil)
+		rrec := httptest.NewRecorder()
+		handler.ServeHTTP(rrec, req)
+		assert.Equal(t, http.StatusOK, rrec.Code)
+	}
+
+	// the counts of both requests are summed until they are reported
+	measurement := countingMeasurement{mutex: &sync.Mutex{}, counts: make(map[string]int64)}
+	config.reportCounts(measurement)
+	assert.True(t, measurement.counts["parse cache misses"] > 0)
+	assert.Equal(t, measurement.counts["parse cache misses"], measurement.counts["parse cache hits"])
+}
+
 func TestServer_GetIndex_MissingQueryParams(t *testing.T) {
 
 	config := Configuration{Development: false}
@@ -145,3 +181,1003 @@ func TestServer_GetIndex_MissingQueryParams(t *testing.T) {
 		assert.Equal(t, "the query must contain the parameter `tokens`\n", resStr2)
 	}
 }
+
+func TestServer_GetIndex_InvalidRegex(t *testing.T) {
+
+	config := Configuration{Development: false}
+	handlerFunc := baseGetHandler(IndexAction, config, logging.NewMockLogging(), NewBlankMeasurementTool())
+	handler := http.HandlerFunc(handlerFunc)
+
+	req, _ := http.NewRequest("GET", "/?package=fmt&tokens=TODO%28&mode=regex", nil)
+	rrec := httptest.NewRecorder()
+
+	handler.ServeHTTP(rrec, req)
+
+	assert.Equal(t, http.StatusBadRequest, rrec.Code)
+	resStr := fmt.Sprintf("%s", rrec.Body)
+	assert.True(t, strings.HasPrefix(resStr, "The token `TODO(` is not a valid regular expression"))
+}
+
+func TestServer_GetIndex_InvalidBoolean(t *testing.T) {
+
+	config := Configuration{Development: false}
+	handlerFunc := baseGetHandler(IndexAction, config, logging.NewMockLogging(), NewBlankMeasurementTool())
+	handler := http.HandlerFunc(handlerFunc)
+
+	req, _ := http.NewRequest("GET", "/?package=fmt&tokens=todo&ignorecase=maybe", nil)
+	rrec := httptest.NewRecorder()
+
+	handler.ServeHTTP(rrec, req)
+
+	assert.Equal(t, http.StatusBadRequest, rrec.Code)
+	resStr := fmt.Sprintf("%s", rrec.Body)
+	assert.Equal(t, "the query parameter `ignorecase` must be a boolean\n", resStr)
+}
+
+func TestServer_GetIndex_UnknownPlatform(t *testing.T) {
+
+	config := Configuration{Development: false}
+	handlerFunc := baseGetHandler(IndexAction, config, logging.NewMockLogging(), NewBlankMeasurementTool())
+	handler := http.HandlerFunc(handlerFunc)
+
+	req, _ := http.NewRequest("GET", "/?package=fmt&tokens=todo&goos=windows&goarch=s390x&cgo=false", nil)
+	rrec := httptest.NewRecorder()
+
+	handler.ServeHTTP(rrec, req)
+
+	assert.Equal(t, http.StatusBadRequest, rrec.Code)
+	resStr := fmt.Sprintf("%s", rrec.Body)
+	assert.Equal(t, "Unknown platform `windows/s390x`\n", resStr)
+}
+
+func TestServer_GetIndex_Cancelled(t *testing.T) {
+
+	config := Configuration{Development: false}
+	handlerFunc := baseGetHandler(IndexAction, config, logging.NewMockLogging(), NewBlankMeasurementTool())
+	handler := http.HandlerFunc(handlerFunc)
+
+	// the client is gone before the scan starts
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	req, _ := http.NewRequestWithContext(ctx, "GET", "/?package=fmt&tokens=todo", nil)
+	rrec := httptest.NewRecorder()
+
+	handler.ServeHTTP(rrec, req)
+
+	assert.Equal(t, http.StatusGatewayTimeout, rrec.Code)
+	var res models.IncompleteScanResult
+	assert.Nil(t, json.Unmarshal(rrec.Body.Bytes(), &res))
+	assert.Equal(t, 1, res.PackagesTotal)
+	assert.Equal(t, 0, res.PackagesScanned)
+	assert.Contains(t, res.Message, "context canceled")
+}
+
+func TestServer_ScanTimeout_LongerThanWriteTimeout(t *testing.T) {
+
+	if testing.Short() {
+		t.Skip("waits for a scan timeout of 15 seconds")
+	}
+	config := Configuration{Development: false, ScanTimeoutSeconds: 15}
+	// a scan that only stops with its timeout
+	action := func(ctx context.Context, writer http.ResponseWriter, values url.Values, scanner services.Scanner) ErrorPkg {
+		<-ctx.Done()
+		return serviceError(services.IncompleteScanError{
+			Cause:  ctx.Err(),
+			Result: models.IncompleteScanResult{Message: "The scan was stopped", PackagesTotal: 1},
+		})
+	}
+	srv := config.httpServer(baseGetHandler(action, config, logging.NewMockLogging(), NewBlankMeasurementTool()))
+	assert.Equal(t, 20*time.Second, srv.WriteTimeout)
+	server := httptest.NewUnstartedServer(srv.Handler)
+	server.Config.WriteTimeout = srv.WriteTimeout
+	server.Config.ReadTimeout = srv.ReadTimeout
+	server.Start()
+	defer server.Close()
+
+	response, err := http.Get(server.URL + "/")
+	assert.Nil(t, err)
+	defer response.Body.Close()
+	assert.Equal(t, http.StatusGatewayTimeout, response.StatusCode)
+	var res models.IncompleteScanResult
+	assert.Nil(t, json.NewDecoder(response.Body).Decode(&res))
+	assert.Equal(t, models.IncompleteScanResult{Message: "The scan was stopped", PackagesTotal: 1}, res)
+}
+
+func TestServer_PostParse_InvalidQuery(t *testing.T) {
+
+	config := Configuration{Development: false}
+	handlerFunc := basePostHandler(ParseAction, config, logging.NewMockLogging(), NewBlankMeasurementTool())
+	handler := http.HandlerFunc(handlerFunc)
+
+	reqStr := "{\"PackageName\":\"fmt\",\"Tokens\":[\"(deprecated NOT\"],\"MatchMode\":\"query\"}"
+	req, _ := http.NewRequest("POST", "/", strings.NewReader(reqStr))
+	rrec := httptest.NewRecorder()
+	handler.ServeHTTP(rrec, req)
+
+	assert.Equal(t, http.StatusBadRequest, rrec.Code)
+	str := fmt.Sprintf("%s", rrec.Body)
+	assert.Equal(t, "Invalid query `(deprecated NOT`: unexpected end of query\n", str)
+}
+
+func TestServer_GetPackages_Success(t *testing.T) {
+
+	config := Configuration{Development: false}
+	handlerFunc := baseGetHandler(PackagesAction, config, logging.NewMockLogging(), NewBlankMeasurementTool())
+	handler := http.HandlerFunc(handlerFunc)
+
+	req, _ := http.NewRequest("GET", "/packages?package=net%2Fhttp%2F...&tokens=TODO", nil)
+	rrec := httptest.NewRecorder()
+
+	handler.ServeHTTP(rrec, req)
+
+	assert.Equal(t, http.StatusOK, rrec.Code)
+
+	var res models.MultiPackageParsingResult
+	json.Unmarshal(rrec.Body.Bytes(), &res)
+
+	assert.Equal(t, "net/http/...", res.Pattern)
+	assert.True(t, len(res.Packages) > 1)
+	assert.Equal(t, "net/http", res.Packages[0].ImportPath)
+}
+
+func TestServer_GetIndex_RejectsPattern(t *testing.T) {
+
+	config := Configuration{Development: false}
+	handlerFunc := baseGetHandler(IndexAction, config, logging.NewMockLogging(), NewBlankMeasurementTool())
+	handler := http.HandlerFunc(handlerFunc)
+
+	req, _ := http.NewRequest("GET", "/?package=std&tokens=TODO", nil)
+	rrec := httptest.NewRecorder()
+
+	handler.ServeHTTP(rrec, req)
+
+	assert.Equal(t, http.StatusBadRequest, rrec.Code)
+}
+
+func TestServer_PostArchive(t *testing.T) {
+
+	var buf bytes.Buffer
+	zipWriter := zip.NewWriter(&buf)
+	fileWriter, _ := zipWriter.Create("hello/hello.go")
+	fileWriter.Write([]byte("package hello\n\n// TODO: say hello\nfunc Hello() {}\n"))
+	zipWriter.Close()
+
+	config := Configuration{Development: false}
+	handlerFunc := baseUploadHandler(ParseArchiveAction, config, logging.NewMockLogging(), NewBlankMeasurementTool())
+	handler := http.HandlerFunc(handlerFunc)
+
+	{
+		req, _ := http.NewRequest("POST", "/parse/archive?tokens=TODO", bytes.NewReader(buf.Bytes()))
+		rrec := httptest.NewRecorder()
+		handler.ServeHTTP(rrec, req)
+
+		assert.Equal(t, http.StatusOK, rrec.Code)
+
+		var res models.MultiPackageParsingResult
+		json.Unmarshal(rrec.Body.Bytes(), &res)
+		assert.Equal(t, 1, len(res.Packages))
+		assert.Equal(t, "hello/hello.go", res.Packages[0].Matches["TODO"][0].FileName)
+	}
+	{
+		config.ArchiveMaxBytes = 16
+		handler := http.HandlerFunc(baseUploadHandler(ParseArchiveAction, config, logging.NewMockLogging(), NewBlankMeasurementTool()))
+		req, _ := http.NewRequest("POST", "/parse/archive?tokens=TODO", bytes.NewReader(buf.Bytes()))
+		rrec := httptest.NewRecorder()
+		handler.ServeHTTP(rrec, req)
+
+		assert.Equal(t, http.StatusRequestEntityTooLarge, rrec.Code)
+	}
+}
+
+func TestServer_GetIndex_DirectoryNotAllowed(t *testing.T) {
+
+	config := Configuration{Development: false}
+	handlerFunc := baseGetHandler(IndexAction, config, logging.NewMockLogging(), NewBlankMeasurementTool())
+	handler := http.HandlerFunc(handlerFunc)
+
+	req, _ := http.NewRequest("GET", "/?directory=%2Fetc&tokens=TODO", nil)
+	rrec := httptest.NewRecorder()
+	handler.ServeHTTP(rrec, req)
+
+	assert.Equal(t, http.StatusBadRequest, rrec.Code)
+	assert.Equal(t, "Scanning directories is not enabled\n", fmt.Sprintf("%s", rrec.Body))
+}
+
+func TestServer_IndexStatus(t *testing.T) {
+
+	{
+		config := Configuration{Development: false}
+		handler := http.HandlerFunc(baseGetHandler(IndexStatusAction, config, logging.NewMockLogging(), NewBlankMeasurementTool()))
+		req, _ := http.NewRequest("GET", "/admin/index", nil)
+		rrec := httptest.NewRecorder()
+		handler.ServeHTTP(rrec, req)
+
+		assert.Equal(t, http.StatusNotFound, rrec.Code)
+	}
+	{
+		config := Configuration{Development: false, IndexPatterns: []string{"./..."}}
+		config.index = services.NewIndex(config.scanner(logging.NewMockLogging()), config.IndexPatterns, "")
+		handler := http.HandlerFunc(basePostHandler(RebuildIndexAction, config, logging.NewMockLogging(), NewBlankMeasurementTool()))
+		req, _ := http.NewRequest("POST", "/admin/index/rebuild", strings.NewReader(""))
+		rrec := httptest.NewRecorder()
+		handler.ServeHTTP(rrec, req)
+
+		assert.Equal(t, http.StatusAccepted, rrec.Code)
+		var status models.IndexStatus
+		json.Unmarshal(rrec.Body.Bytes(), &status)
+		assert.Equal(t, []string{"./..."}, status.Patterns)
+	}
+}
+
+func TestServer_PostDiff_DirectoryNotAllowed(t *testing.T) {
+
+	config := Configuration{Development: false}
+	handler := http.HandlerFunc(basePostHandler(ParseDiffAction, config, logging.NewMockLogging(), NewBlankMeasurementTool()))
+
+	{
+		req, _ := http.NewRequest("POST", "/parse/diff", strings.NewReader(`{"Base": "old", "Head": "new", "Request": {}}`))
+		rrec := httptest.NewRecorder()
+		handler.ServeHTTP(rrec, req)
+
+		assert.Equal(t, http.StatusBadRequest, rrec.Code)
+		assert.Equal(t, "The parameter `Tokens` cannot be empty\n", fmt.Sprintf("%s", rrec.Body))
+	}
+	{
+		req, _ := http.NewRequest("POST", "/parse/diff", strings.NewReader(`{"Base": "old", "Head": "new", "Request": {"Tokens": ["TODO"]}}`))
+		rrec := httptest.NewRecorder()
+		handler.ServeHTTP(rrec, req)
+
+		assert.Equal(t, http.StatusBadRequest, rrec.Code)
+		assert.Equal(t, "Scanning directories is not enabled\n", fmt.Sprintf("%s", rrec.Body))
+	}
+}
+
+func TestServer_OpenAPI(t *testing.T) {
+
+	config := Configuration{Development: false}
+	handler := http.HandlerFunc(baseGetHandler(OpenAPIAction, config, logging.NewMockLogging(), NewBlankMeasurementTool()))
+	req, _ := http.NewRequest("GET", "/openapi.json", nil)
+	rrec := httptest.NewRecorder()
+	handler.ServeHTTP(rrec, req)
+
+	assert.Equal(t, http.StatusOK, rrec.Code)
+	var document struct {
+		OpenAPI string `json:"openapi"`
+		Paths   map[string]map[string]struct {
+			Parameters []struct {
+				Name    string
+				Ref     string `json:"$ref"`
+				Schema  map[string]interface{}
+				Style   string
+				Explode bool
+			}
+			Responses map[string]struct {
+				Headers map[string]interface{}
+			}
+		}
+		Security   []map[string]interface{}
+		Components struct {
+			Schemas map[string]struct {
+				Properties map[string]interface{}
+			}
+			Parameters map[string]struct {
+				Name string
+				In   string
+			}
+		}
+	}
+	assert.Nil(t, json.Unmarshal(rrec.Body.Bytes(), &document))
+	assert.Equal(t, "3.0.3", document.OpenAPI)
+	assert.Equal(t, len(indexQueryParameters)+len(signatureHeaders), len(document.Paths["/"]["get"].Parameters))
+	assert.Contains(t, document.Paths["/parse"], "post")
+	// the tokens may be repeated, and the API key is optional
+	tokens := document.Paths["/"]["get"].Parameters[2]
+	assert.Equal(t, "tokens", tokens.Name)
+	assert.Equal(t, map[string]interface{}{"type": "array", "items": map[string]interface{}{"type": "string"}}, tokens.Schema)
+	assert.Equal(t, "form", tokens.Style)
+	assert.True(t, tokens.Explode)
+	assert.Equal(t, []map[string]interface{}{{"apiKey": []interface{}{}}, {}}, document.Security)
+
+	// the signature headers of both operations, and the rate limits
+	for _, operation := range []string{"/ get", "/parse post"} {
+		path, method := strings.Fields(operation)[0], strings.Fields(operation)[1]
+		parameters := document.Paths[path][method].Parameters
+		assert.Equal(t, "#/components/parameters/"+signing.Header_SIGNATURE, parameters[len(parameters)-1].Ref, operation)
+		assert.Contains(t, document.Paths[path][method].Responses["429"].Headers, "Retry-After", operation)
+	}
+	assert.Equal(t, len(signatureHeaders), len(document.Components.Parameters))
+	assert.Equal(t, "header", document.Components.Parameters[signing.Header_KEY_ID].In)
+
+	// every field of the models is described
+	for _, model := range []interface{}{models.CommentParsingRequest{}, models.CommentParsingResult{}, models.MatchedComment{}} {
+		modelType := reflect.TypeOf(model)
+		schema := document.Components.Schemas[modelType.Name()]
+		assert.Equal(t, modelType.NumField(), len(schema.Properties), modelType.Name())
+	}
+	assert.Equal(t, map[string]interface{}{"type": "string", "format": "date-time"},
+		document.Components.Schemas["Blame"].Properties["Date"])
+}
+
+func TestServer_Client(t *testing.T) {
+
+	config := Configuration{Development: false, AllowDirectoryScans: true}
+	router := mux.NewRouter()
+	router.HandleFunc("/", baseGetHandler(IndexAction, config, logging.NewMockLogging(), NewBlankMeasurementTool()))
+	router.HandleFunc("/parse", basePostHandler(ParseAction, config, logging.NewMockLogging(), NewBlankMeasurementTool()))
+	server := httptest.NewServer(router)
+	defer server.Close()
+	apiClient := client.NewClient(server.URL)
+
+	cgoEnabled := false
+	request := models.CommentParsingRequest{
+		Directory:        "../services/testdata/sample",
+		Tokens:           []string{"todo", "fixme"},
+		IgnoreCase:       true,
+		FileClasses:      []string{models.FileClass_GO, models.FileClass_TEST},
+		BuildTags:        []string{"integration"},
+		CgoEnabled:       &cgoEnabled,
+		ContextLines:     1,
+		DeclarationKinds: []string{models.DeclarationKind_FUNC},
+		SortBy:           []string{"token", "line"},
+	}
+	expected, err := services.NewScanner("", logging.NewMockLogging()).ExtractRelevantComments(context.Background(), request)
+	assert.Nil(t, err)
+
+	res, err := apiClient.Index(context.Background(), request)
+	assert.Nil(t, err)
+	assert.Equal(t, expected, res)
+	res, err = apiClient.Parse(context.Background(), request)
+	assert.Nil(t, err)
+	assert.Equal(t, expected, res)
+
+	request.MatchMode = models.MatchMode_REGEX
+	request.Tokens = []string{"TODO("}
+	_, err = apiClient.Parse(context.Background(), request)
+	assert.IsType(t, &client.APIError{}, err)
+	assert.Equal(t, http.StatusBadRequest, err.(*client.APIError).StatusCode)
+	assert.Contains(t, err.(*client.APIError).Message, "missing closing )")
+}
+
+func TestServer_Signatures(t *testing.T) {
+
+	config := Configuration{
+		Development:         false,
+		AllowDirectoryScans: true,
+		SigningKeys:         map[string]string{"ci": "secret"},
+	}
+	now := time.Unix(1700000000, 0)
+	config.signatures = newSignatureVerifier(config.SigningKeys, config.signatureMaxAge())
+	config.signatures.now = func() time.Time { return now }
+	handler := config.verifySignatures(
+		basePostHandler(ParseAction, config, logging.NewMockLogging(), NewBlankMeasurementTool()), logging.NewMockLogging())
+
+	body := []byte(`{"Directory": "../services/testdata/sample", "Tokens": ["TODO"]}`)
+	post := func(sign func(request *http.Request)) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest("POST", "/parse", bytes.NewReader(body))
+		sign(req)
+		rrec := httptest.NewRecorder()
+		handler.ServeHTTP(rrec, req)
+		return rrec
+	}
+
+	rrec := post(func(request *http.Request) {})
+	assert.Equal(t, http.StatusUnauthorized, rrec.Code)
+	assert.Equal(t, "The request is not signed\n", rrec.Body.String())
+
+	rrec = post(func(request *http.Request) { signing.SignRequest(request, "other", []byte("secret"), body, now) })
+	assert.Equal(t, "The signing key of the request is unknown\n", rrec.Body.String())
+
+	rrec = post(func(request *http.Request) { signing.SignRequest(request, "ci", []byte("wrong"), body, now) })
+	assert.Equal(t, "The signature of the request is not valid\n", rrec.Body.String())
+
+	rrec = post(func(request *http.Request) {
+		signing.SignRequest(request, "ci", []byte("secret"), body, now.Add(-10*time.Minute))
+	})
+	assert.Equal(t, "The signature of the request has expired\n", rrec.Body.String())
+
+	var signed http.Header
+	rrec = post(func(request *http.Request) {
+		signing.SignRequest(request, "ci", []byte("secret"), body, now)
+		signed = request.Header.Clone()
+	})
+	assert.Equal(t, http.StatusOK, rrec.Code)
+	assert.Contains(t, rrec.Body.String(), "OrderedMatches")
+
+	// the same signature cannot be used again
+	rrec = post(func(request *http.Request) { request.Header = signed })
+	assert.Equal(t, http.StatusUnauthorized, rrec.Code)
+	assert.Equal(t, "The signature of the request was already used\n", rrec.Body.String())
+
+	// the body is part of the signature
+	body = []byte(`{"Directory": "../services/testdata/sample", "Tokens": ["FIXME"]}`)
+	rrec = post(func(request *http.Request) {
+		signing.SignRequest(request, "ci", []byte("secret"), []byte("{}"), now)
+	})
+	assert.Equal(t, "The signature of the request is not valid\n", rrec.Body.String())
+
+	// the OpenAPI document is public
+	req, _ := http.NewRequest("GET", "/openapi.json", nil)
+	rrec = httptest.NewRecorder()
+	baseGetHandler(OpenAPIAction, config, logging.NewMockLogging(), NewBlankMeasurementTool()).ServeHTTP(rrec, req)
+	assert.Equal(t, http.StatusOK, rrec.Code)
+}
+
+func TestServer_Client_Signed(t *testing.T) {
+
+	config := Configuration{
+		Development:         false,
+		AllowDirectoryScans: true,
+		SigningKeys:         map[string]string{"ci": "secret"},
+	}
+	config.signatures = newSignatureVerifier(config.SigningKeys, config.signatureMaxAge())
+	server := httptest.NewServer(config.newRouter(logging.NewMockLogging(), NewBlankMeasurementTool()))
+	defer server.Close()
+	apiClient := client.NewClient(server.URL)
+
+	request := models.CommentParsingRequest{Directory: "../services/testdata/sample", Tokens: []string{"TODO"}}
+	_, err := apiClient.Parse(context.Background(), request)
+	assert.IsType(t, &client.APIError{}, err)
+	assert.Equal(t, http.StatusUnauthorized, err.(*client.APIError).StatusCode)
+
+	apiClient.Signer = &client.Signer{KeyID: "ci", Secret: []byte("secret")}
+	_, err = apiClient.Index(context.Background(), request)
+	assert.Nil(t, err)
+	_, err = apiClient.Parse(context.Background(), request)
+	assert.Nil(t, err)
+}
+
+func TestServer_Signatures_BeforeScopesAndSlots(t *testing.T) {
+
+	config := Configuration{
+		Development:         false,
+		AllowDirectoryScans: true,
+		SigningKeys:         map[string]string{"ci": "secret"},
+		APIKeys:             []APIKey{{Name: "ci", Hash: HashAPIKey("ci-key"), Scopes: []string{models.Scope_PARSE_READ}}},
+		MaxConcurrentScans:  1,
+	}
+	config.signatures = newSignatureVerifier(config.SigningKeys, config.signatureMaxAge())
+	apiKeys, err := config.loadAPIKeys()
+	assert.Nil(t, err)
+	config.apiKeys = apiKeys
+	config.scans = config.scanSlots()
+	config.counts = newRequestCounts()
+	router := config.newRouter(logging.NewMockLogging(), NewBlankMeasurementTool())
+
+	// an unsigned request is rejected without being authenticated
+	req, _ := http.NewRequest("GET", "/?directory=../services/testdata/sample&tokens=TODO", http.NoBody)
+	rrec := httptest.NewRecorder()
+	router.ServeHTTP(rrec, req)
+	assert.Equal(t, http.StatusUnauthorized, rrec.Code)
+	assert.Equal(t, "The request is not signed\n", rrec.Body.String())
+
+	// nor does it wait for a slot of the scans, or take one
+	config.scans <- struct{}{}
+	req, _ = http.NewRequest("GET", "/?directory=../services/testdata/sample&tokens=TODO", http.NoBody)
+	req.Header.Set("Authorization", "Bearer ci-key")
+	rrec = httptest.NewRecorder()
+	router.ServeHTTP(rrec, req)
+	assert.Equal(t, http.StatusUnauthorized, rrec.Code)
+	measurement := countingMeasurement{mutex: &sync.Mutex{}, counts: make(map[string]int64)}
+	config.counts.report(measurement)
+	assert.Equal(t, int64(0), measurement.counts["concurrency limited"])
+	<-config.scans
+
+	req, _ = http.NewRequest("GET", "/?directory=../services/testdata/sample&tokens=TODO", http.NoBody)
+	req.Header.Set("Authorization", "Bearer ci-key")
+	signing.SignRequest(req, "ci", []byte("secret"), nil, time.Now())
+	rrec = httptest.NewRecorder()
+	router.ServeHTTP(rrec, req)
+	assert.Equal(t, http.StatusOK, rrec.Code)
+}
+
+func TestServer_APIKeys(t *testing.T) {
+
+	keyFile := filepath.Join(t.TempDir(), "keys.json")
+	assert.Nil(t, ioutil.WriteFile(keyFile, []byte(
+		`[{"Name": "ops", "Hash": "`+HashAPIKey("ops-key")+`", "Scopes": ["admin"]}]`), 0600))
+	config := Configuration{
+		Development:         false,
+		AllowDirectoryScans: true,
+		APIKeys:             []APIKey{{Name: "ci", Hash: HashAPIKey("ci-key"), Scopes: []string{models.Scope_PARSE_READ}}},
+		APIKeyFile:          keyFile,
+	}
+	apiKeys, err := config.loadAPIKeys()
+	assert.Nil(t, err)
+	assert.Len(t, apiKeys, 2)
+	config.apiKeys = apiKeys
+
+	logs := bytes.NewBufferString("")
+	logWriter := bufio.NewWriter(logs)
+	logger := logging.NewWriterLogging(logWriter)
+	router := mux.NewRouter()
+	router.HandleFunc("/", config.requireScope(models.Scope_PARSE_READ,
+		baseGetHandler(IndexAction, config, logger, NewBlankMeasurementTool()), logger))
+	router.HandleFunc("/admin/index", config.requireScope(models.Scope_ADMIN,
+		baseGetHandler(IndexStatusAction, config, logger, NewBlankMeasurementTool()), logger))
+	get := func(path string, key string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest("GET", path, nil)
+		if len(key) > 0 {
+			req.Header.Set("Authorization", "Bearer "+key)
+		}
+		rrec := httptest.NewRecorder()
+		router.ServeHTTP(rrec, req)
+		return rrec
+	}
+	var rejected models.AuthenticationError
+
+	rrec := get("/?directory=../services/testdata/sample&tokens=TODO", "")
+	assert.Equal(t, http.StatusUnauthorized, rrec.Code)
+	assert.Equal(t, "Bearer", rrec.Header().Get("WWW-Authenticate"))
+	assert.Nil(t, json.Unmarshal(rrec.Body.Bytes(), &rejected))
+	assert.Equal(t, models.AuthenticationError{
+		Message: "The request does not have a valid API key",
+		Scope:   models.Scope_PARSE_READ,
+	}, rejected)
+
+	rrec = get("/?directory=../services/testdata/sample&tokens=TODO", "wrong-key")
+	assert.Equal(t, http.StatusUnauthorized, rrec.Code)
+
+	rrec = get("/admin/index", "ci-key")
+	assert.Equal(t, http.StatusForbidden, rrec.Code)
+	assert.Nil(t, json.Unmarshal(rrec.Body.Bytes(), &rejected))
+	assert.Equal(t, "The API key ci is not granted the scope admin", rejected.Message)
+
+	rrec = get("/?directory=../services/testdata/sample&tokens=TODO", "ci-key")
+	assert.Equal(t, http.StatusOK, rrec.Code)
+	// an admin key is granted every scope
+	rrec = get("/?directory=../services/testdata/sample&tokens=TODO", "ops-key")
+	assert.Equal(t, http.StatusOK, rrec.Code)
+
+	// the logs of a request identify its caller
+	rrec = get("/?directory=../services/testdata/sample&tokens=TODO(&mode=regex", "ci-key")
+	assert.Equal(t, http.StatusBadRequest, rrec.Code)
+	logWriter.Flush()
+	assert.Contains(t, logs.String(), "[Error] [ci] ")
+}
+
+func TestServer_APIKeys_Invalid(t *testing.T) {
+
+	for _, keys := range [][]APIKey{
+		{{Name: "", Hash: HashAPIKey("key")}},
+		{{Name: "ci", Hash: "key"}},
+		{{Name: "ci", Hash: HashAPIKey("key"), Scopes: []string{"parse:write"}}},
+		{{Name: "ci", Hash: HashAPIKey("key")}, {Name: "ops", Hash: HashAPIKey("key")}},
+	} {
+		config := Configuration{APIKeys: keys}
+		_, err := config.loadAPIKeys()
+		assert.NotNil(t, err, "%v", keys)
+	}
+
+	config := Configuration{APIKeyFile: filepath.Join(t.TempDir(), "missing.json")}
+	_, err := config.loadAPIKeys()
+	assert.NotNil(t, err)
+}
+
+func TestServer_Client_APIKey(t *testing.T) {
+
+	config := Configuration{
+		Development:         false,
+		AllowDirectoryScans: true,
+		APIKeys:             []APIKey{{Name: "ci", Hash: HashAPIKey("ci-key"), Scopes: []string{models.Scope_PARSE_READ}}},
+	}
+	config.apiKeys, _ = config.loadAPIKeys()
+	server := httptest.NewServer(config.requireScope(models.Scope_PARSE_READ,
+		basePostHandler(ParseAction, config, logging.NewMockLogging(), NewBlankMeasurementTool()), logging.NewMockLogging()))
+	defer server.Close()
+	apiClient := client.NewClient(server.URL)
+
+	request := models.CommentParsingRequest{Directory: "../services/testdata/sample", Tokens: []string{"TODO"}}
+	_, err := apiClient.Parse(context.Background(), request)
+	assert.Equal(t, &client.APIError{StatusCode: 401, Message: "The request does not have a valid API key"}, err)
+
+	apiClient.APIKey = "ci-key"
+	_, err = apiClient.Parse(context.Background(), request)
+	assert.Nil(t, err)
+}
+
+// counts the measurements of the tests
+type countingMeasurement struct {
+	mutex  *sync.Mutex
+	counts map[string]int64 // the last count by name and caller
+}
+
+func (m countingMeasurement) Log(name string, timeMillis int64) {}
+
+func (m countingMeasurement) Count(name string, count int64) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.counts[name] = count
+}
+
+func (m countingMeasurement) WithCaller(caller string) Measurement {
+	return m
+}
+
+func TestServer_RateLimit(t *testing.T) {
+
+	config := Configuration{
+		Development:        false,
+		RateLimitPerSecond: 1,
+		RateLimitBurst:     2,
+		APIKeys:            []APIKey{{Name: "ci", Hash: HashAPIKey("ci-key"), Scopes: []string{models.Scope_PARSE_READ}}},
+	}
+	config.apiKeys, _ = config.loadAPIKeys()
+	now := time.Unix(1700000000, 0)
+	config.rateLimits = config.rateLimiter()
+	config.rateLimits.now = func() time.Time { return now }
+	config.counts = newRequestCounts()
+	measurement := countingMeasurement{mutex: &sync.Mutex{}, counts: make(map[string]int64)}
+	handler := config.limitRequests(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		writer.Write([]byte("ok"))
+	}), logging.NewMockLogging())
+
+	get := func(remoteAddr string, key string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest("GET", "/", nil)
+		req.RemoteAddr = remoteAddr
+		if len(key) > 0 {
+			req.Header.Set("Authorization", "Bearer "+key)
+		}
+		rrec := httptest.NewRecorder()
+		handler.ServeHTTP(rrec, req)
+		return rrec
+	}
+
+	assert.Equal(t, http.StatusOK, get("10.0.0.1:1234", "").Code)
+	assert.Equal(t, http.StatusOK, get("10.0.0.1:1235", "").Code)
+	rrec := get("10.0.0.1:1236", "")
+	assert.Equal(t, http.StatusTooManyRequests, rrec.Code)
+	assert.Equal(t, "1", rrec.Header().Get("Retry-After"))
+	assert.Equal(t, "Too many requests, the limit is 1 requests per second\n", rrec.Body.String())
+	config.reportCounts(measurement)
+	assert.Equal(t, int64(1), measurement.counts["rate limited"])
+
+	// other clients have their own bucket, and a known key is limited regardless of its address
+	assert.Equal(t, http.StatusOK, get("10.0.0.2:1234", "").Code)
+	assert.Equal(t, http.StatusOK, get("10.0.0.1:1234", "ci-key").Code)
+	assert.Equal(t, http.StatusOK, get("10.0.0.3:1234", "ci-key").Code)
+	assert.Equal(t, http.StatusTooManyRequests, get("10.0.0.4:1234", "ci-key").Code)
+	// unknown keys are limited by address
+	assert.Equal(t, http.StatusTooManyRequests, get("10.0.0.1:1234", "other-key").Code)
+	config.reportCounts(measurement)
+	assert.Equal(t, int64(3), measurement.counts["rate limit clients"])
+	// the counts start again from zero after they are reported
+	assert.Empty(t, config.counts.counts)
+
+	// the bucket is refilled at the rate of the limit
+	now = now.Add(1500 * time.Millisecond)
+	assert.Equal(t, http.StatusOK, get("10.0.0.1:1234", "").Code)
+	rrec = get("10.0.0.1:1234", "")
+	assert.Equal(t, http.StatusTooManyRequests, rrec.Code)
+	assert.Equal(t, "1", rrec.Header().Get("Retry-After"))
+
+	// the full buckets are removed
+	now = now.Add(time.Hour)
+	assert.Equal(t, http.StatusOK, get("10.0.0.1:1234", "").Code)
+	assert.Equal(t, 1, config.rateLimits.clients())
+}
+
+func TestServer_MaxConcurrentScans(t *testing.T) {
+
+	config := Configuration{Development: false, MaxConcurrentScans: 1}
+	config.scans = config.scanSlots()
+	config.counts = newRequestCounts()
+	measurement := countingMeasurement{mutex: &sync.Mutex{}, counts: make(map[string]int64)}
+	started := make(chan struct{})
+	release := make(chan struct{})
+	handler := config.limitScans(func(writer http.ResponseWriter, request *http.Request) {
+		if request.URL.Path == "/slow" {
+			close(started)
+			<-release
+		}
+		writer.Write([]byte("ok"))
+	}, logging.NewMockLogging())
+
+	get := func(path string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest("GET", path, nil)
+		rrec := httptest.NewRecorder()
+		handler.ServeHTTP(rrec, req)
+		return rrec
+	}
+
+	done := make(chan int)
+	go func() { done <- get("/slow").Code }()
+	<-started
+	rrec := get("/")
+	assert.Equal(t, http.StatusTooManyRequests, rrec.Code)
+	assert.Equal(t, "1", rrec.Header().Get("Retry-After"))
+	// the running scans are reported as they are, not as they were at the last request
+	config.reportCounts(measurement)
+	close(release)
+	assert.Equal(t, http.StatusOK, <-done)
+
+	assert.Equal(t, http.StatusOK, get("/").Code)
+	measurement.mutex.Lock()
+	defer measurement.mutex.Unlock()
+	assert.Equal(t, int64(1), measurement.counts["concurrency limited"])
+	assert.Equal(t, int64(1), measurement.counts["concurrent scans"])
+}
+
+func TestServer_MaxConcurrentScans_Jobs(t *testing.T) {
+
+	config := Configuration{Development: false, AllowDirectoryScans: true, MaxConcurrentScans: 1}
+	config.scans = config.scanSlots()
+	jobs := config.startJobs(logging.NewMockLogging(), NewBlankMeasurementTool())
+	defer jobs.stop()
+	handler := config.limitScans(func(writer http.ResponseWriter, request *http.Request) {
+		writer.Write([]byte("ok"))
+	}, logging.NewMockLogging())
+	get := func() int {
+		req, _ := http.NewRequest("GET", "/", nil)
+		rrec := httptest.NewRecorder()
+		handler.ServeHTTP(rrec, req)
+		return rrec.Code
+	}
+
+	// a running job takes a slot from the requests
+	config.scans <- struct{}{}
+	assert.Equal(t, http.StatusTooManyRequests, get())
+
+	// a job waits for a slot, and can be cancelled meanwhile
+	status, err := jobs.submit(models.ScanJobRequest{
+		Request: models.CommentParsingRequest{Directory: "../services/testdata/sample", Tokens: []string{"TODO"}},
+	}, "")
+	assert.Nil(t, err)
+	job, _ := jobs.job(status.ID, "")
+	time.Sleep(10 * time.Millisecond)
+	assert.Equal(t, models.JobState_QUEUED, jobs.status(job).State)
+	assert.Equal(t, models.JobState_CANCELLED, jobs.cancelJob(job).State)
+
+	<-config.scans
+	assert.Equal(t, http.StatusOK, get())
+}
+
+func TestServer_Jobs(t *testing.T) {
+
+	config := Configuration{Development: false, AllowDirectoryScans: true}
+	// a queue without workers, so the jobs only run when the test runs them
+	jobs := &jobQueue{
+		scanner:     func() services.Scanner { return config.scanner(logging.NewMockLogging()) },
+		timeout:     time.Minute,
+		retention:   time.Hour,
+		maxRetained: 3,
+		now:         time.Now,
+		logging:     logging.NewMockLogging(),
+		measure:     NewBlankMeasurementTool(),
+		pending:     make(chan *scanJob, 3),
+		jobs:        make(map[string]*scanJob),
+	}
+	config.jobs = jobs
+	router := mux.NewRouter()
+	router.HandleFunc("/jobs", baseJobHandler(SubmitJobAction, config, logging.NewMockLogging(), NewBlankMeasurementTool())).
+		Methods("POST")
+	router.HandleFunc("/jobs/{id}", baseJobHandler(JobStatusAction, config, logging.NewMockLogging(), NewBlankMeasurementTool())).
+		Methods("GET")
+	router.HandleFunc("/jobs/{id}/result", baseJobHandler(JobResultAction, config, logging.NewMockLogging(), NewBlankMeasurementTool())).
+		Methods("GET")
+	router.HandleFunc("/jobs/{id}", baseJobHandler(CancelJobAction, config, logging.NewMockLogging(), NewBlankMeasurementTool())).
+		Methods("DELETE")
+	send := func(method string, path string, body string) (*httptest.ResponseRecorder, models.ScanJob) {
+		req, _ := http.NewRequest(method, path, strings.NewReader(body))
+		rrec := httptest.NewRecorder()
+		router.ServeHTTP(rrec, req)
+		var job models.ScanJob
+		json.Unmarshal(rrec.Body.Bytes(), &job)
+		return rrec, job
+	}
+
+	rrec, _ := send("POST", "/jobs", `{"Request": {"Directory": "../services/testdata/sample"}}`)
+	assert.Equal(t, http.StatusBadRequest, rrec.Code)
+	assert.Equal(t, "The parameter `Tokens` cannot be empty\n", rrec.Body.String())
+
+	// the tokens and options are validated before the job is queued
+	rrec, _ = send("POST", "/jobs", `{"Request": {"Directory": "../services/testdata/sample", "MatchMode": "regex", "Tokens": ["("]}}`)
+	assert.Equal(t, http.StatusBadRequest, rrec.Code)
+	assert.Contains(t, rrec.Body.String(), "missing closing )")
+	assert.Empty(t, rrec.Header().Get("Location"))
+	rrec, _ = send("POST", "/jobs", `{"Request": {"Directory": "../services/testdata/sample", "Tokens": ["TODO"], "SortBy": ["size"]}}`)
+	assert.Equal(t, http.StatusBadRequest, rrec.Code)
+
+	rrec, cancelled := send("POST", "/jobs", `{"Request": {"Directory": "../services/testdata/sample", "Tokens": ["TODO"]}}`)
+	assert.Equal(t, http.StatusAccepted, rrec.Code)
+	assert.Equal(t, "/jobs/"+cancelled.ID, rrec.Header().Get("Location"))
+	assert.Equal(t, models.JobState_QUEUED, cancelled.State)
+	_, succeeded := send("POST", "/jobs", `{"Request": {"Directory": "../services/testdata/sample", "Tokens": ["TODO"]}, "Packages": true}`)
+	_, failed := send("POST", "/jobs", `{"Request": {"Directory": "../services/testdata/missing", "Tokens": ["TODO"]}}`)
+	rrec, _ = send("POST", "/jobs", `{"Request": {"Directory": "../services/testdata/sample", "Tokens": ["TODO"]}}`)
+	assert.Equal(t, http.StatusServiceUnavailable, rrec.Code)
+	assert.Equal(t, "The job queue is full, try again later\n", rrec.Body.String())
+
+	rrec, _ = send("GET", "/jobs/unknown", "")
+	assert.Equal(t, http.StatusNotFound, rrec.Code)
+	rrec, _ = send("GET", "/jobs/"+succeeded.ID+"/result", "")
+	assert.Equal(t, http.StatusConflict, rrec.Code)
+	assert.Equal(t, "The job "+succeeded.ID+" is queued\n", rrec.Body.String())
+
+	rrec, job := send("DELETE", "/jobs/"+cancelled.ID, "")
+	assert.Equal(t, http.StatusOK, rrec.Code)
+	assert.Equal(t, models.JobState_CANCELLED, job.State)
+	assert.False(t, job.FinishedAt.IsZero())
+	rrec, _ = send("GET", "/jobs/"+cancelled.ID+"/result", "")
+	assert.Equal(t, http.StatusConflict, rrec.Code)
+
+	for idx := 0; idx < 3; idx++ {
+		jobs.run(<-jobs.pending)
+	}
+
+	_, job = send("GET", "/jobs/"+cancelled.ID, "")
+	assert.Equal(t, models.JobState_CANCELLED, job.State)
+	assert.True(t, job.StartedAt.IsZero())
+
+	_, job = send("GET", "/jobs/"+succeeded.ID, "")
+	assert.Equal(t, models.JobState_SUCCEEDED, job.State)
+	assert.True(t, job.Packages)
+	assert.True(t, job.Progress.FilesScanned > 0)
+	assert.Equal(t, job.Progress.FilesTotal, job.Progress.FilesScanned)
+	rrec, _ = send("GET", "/jobs/"+succeeded.ID+"/result", "")
+	assert.Equal(t, http.StatusOK, rrec.Code)
+	expected, err := config.scanner(logging.NewMockLogging()).ExtractRelevantCommentsForPattern(
+		context.Background(), models.CommentParsingRequest{Directory: "../services/testdata/sample", Tokens: []string{"TODO"}})
+	assert.Nil(t, err)
+	var result models.MultiPackageParsingResult
+	assert.Nil(t, json.Unmarshal(rrec.Body.Bytes(), &result))
+	assert.Equal(t, expected, result)
+
+	_, job = send("GET", "/jobs/"+failed.ID, "")
+	assert.Equal(t, models.JobState_FAILED, job.State)
+	assert.Contains(t, job.Error, "does not exist")
+	rrec, _ = send("GET", "/jobs/"+failed.ID+"/result", "")
+	assert.Equal(t, http.StatusBadRequest, rrec.Code)
+
+	// the jobs are only found for the caller that submitted them
+	_, found := jobs.job(succeeded.ID, "ci")
+	assert.False(t, found)
+
+	// the oldest finished jobs over maxRetained are removed
+	jobs.mutex.Lock()
+	jobs.maxRetained = 2
+	jobs.removeExpired()
+	jobs.mutex.Unlock()
+	rrec, _ = send("GET", "/jobs/"+cancelled.ID, "")
+	assert.Equal(t, http.StatusNotFound, rrec.Code)
+	rrec, _ = send("GET", "/jobs/"+succeeded.ID, "")
+	assert.Equal(t, http.StatusOK, rrec.Code)
+
+	// the finished jobs are removed after their retention
+	jobs.now = func() time.Time { return time.Now().Add(2 * time.Hour) }
+	rrec, _ = send("POST", "/jobs", `{"Request": {"Directory": "../services/testdata/sample", "Tokens": ["TODO"]}}`)
+	assert.Equal(t, http.StatusAccepted, rrec.Code)
+	rrec, _ = send("GET", "/jobs/"+succeeded.ID, "")
+	assert.Equal(t, http.StatusNotFound, rrec.Code)
+}
+
+func TestServer_Jobs_Sweep(t *testing.T) {
+
+	config := Configuration{Development: false, AllowDirectoryScans: true, JobRetentionSeconds: 1}
+	jobs := config.startJobs(logging.NewMockLogging(), NewBlankMeasurementTool())
+	defer jobs.stop()
+	status, err := jobs.submit(models.ScanJobRequest{
+		Request: models.CommentParsingRequest{Directory: "../services/testdata/sample", Tokens: []string{"TODO"}},
+	}, "")
+	assert.Nil(t, err)
+
+	// the finished job is removed once expired, without another job being submitted
+	assert.Eventually(t, func() bool {
+		_, found := jobs.job(status.ID, "")
+		return !found
+	}, 5*time.Second, 100*time.Millisecond)
+}
+
+func TestServer_Jobs_Stop(t *testing.T) {
+
+	config := Configuration{Development: false, AllowDirectoryScans: true, MaxConcurrentScans: 1}
+	config.scans = config.scanSlots()
+	jobs := config.startJobs(logging.NewMockLogging(), NewBlankMeasurementTool())
+	// the job waits for the slot taken by the test
+	config.scans <- struct{}{}
+	status, err := jobs.submit(models.ScanJobRequest{
+		Request: models.CommentParsingRequest{Directory: "../services/testdata/sample", Tokens: []string{"TODO"}},
+	}, "")
+	assert.Nil(t, err)
+	job, _ := jobs.job(status.ID, "")
+
+	jobs.stop()
+	jobs.stop()
+	assert.Equal(t, models.JobState_CANCELLED, jobs.status(job).State)
+	select {
+	case <-jobs.done:
+	default:
+		t.Error("the queue is not stopped")
+	}
+}
+
+func TestServer_Client_Jobs(t *testing.T) {
+
+	config := Configuration{Development: false, AllowDirectoryScans: true, JobWorkers: 2}
+	config.jobs = config.startJobs(logging.NewMockLogging(), NewBlankMeasurementTool())
+	defer config.jobs.stop()
+	router := mux.NewRouter()
+	router.HandleFunc("/jobs", baseJobHandler(SubmitJobAction, config, logging.NewMockLogging(), NewBlankMeasurementTool()))
+	router.HandleFunc("/jobs/{id}", baseJobHandler(JobStatusAction, config, logging.NewMockLogging(), NewBlankMeasurementTool()))
+	router.HandleFunc("/jobs/{id}/result", baseJobHandler(JobResultAction, config, logging.NewMockLogging(), NewBlankMeasurementTool()))
+	server := httptest.NewServer(router)
+	defer server.Close()
+	apiClient := client.NewClient(server.URL)
+
+	request := models.CommentParsingRequest{Directory: "../services/testdata/sample", Tokens: []string{"TODO"}}
+	job, err := apiClient.SubmitJob(context.Background(), models.ScanJobRequest{Request: request})
+	assert.Nil(t, err)
+	for job.State == models.JobState_QUEUED || job.State == models.JobState_RUNNING {
+		time.Sleep(10 * time.Millisecond)
+		job, err = apiClient.Job(context.Background(), job.ID)
+		assert.Nil(t, err)
+	}
+	assert.Equal(t, models.JobState_SUCCEEDED, job.State)
+
+	expected, err := services.NewScanner("", logging.NewMockLogging()).ExtractRelevantComments(context.Background(), request)
+	assert.Nil(t, err)
+	var result models.CommentParsingResult
+	assert.Nil(t, apiClient.JobResult(context.Background(), job.ID, &result))
+	assert.Equal(t, expected, result)
+}
+
+func TestServer_GetIndex_QueryTokens(t *testing.T) {
+
+	request, errPkg := requestFromQuery(url.Values{
+		"package": {"fmt"},
+		"mode":    {models.MatchMode_QUERY},
+		"tokens":  {`deprecated AND "x,y"`},
+	})
+	assert.False(t, errPkg.Error())
+	assert.Equal(t, []string{`deprecated AND "x,y"`}, request.Tokens)
+
+	request, errPkg = requestFromQuery(url.Values{"package": {"fmt"}, "tokens": {"a,b", "c"}})
+	assert.False(t, errPkg.Error())
+	assert.Equal(t, []string{"a,b", "c"}, request.Tokens)
+
+	request, errPkg = requestFromQuery(url.Values{"package": {"fmt"}, "tokens": {"TODO,FIXME"}})
+	assert.False(t, errPkg.Error())
+	assert.Equal(t, []string{"TODO", "FIXME"}, request.Tokens)
+
+	request, errPkg = requestFromQuery(url.Values{"package": {"fmt"}, "mode": {models.MatchMode_REGEX}, "tokens": {"a{1,3}"}})
+	assert.False(t, errPkg.Error())
+	assert.Equal(t, []string{"a{1,3}"}, request.Tokens)
+
+	request, errPkg = requestFromQuery(url.Values{"package": {"fmt"}, "tokens": {"x, y"}, "repeated": {"true"}})
+	assert.False(t, errPkg.Error())
+	assert.Equal(t, []string{"x, y"}, request.Tokens)
+
+	request, errPkg = requestFromQuery(url.Values{"package": {"fmt"}, "tokens": {"x, y"}, "repeated": {"1"}})
+	assert.False(t, errPkg.Error())
+	assert.Equal(t, []string{"x, y"}, request.Tokens)
+
+	request, errPkg = requestFromQuery(url.Values{"package": {"fmt"}, "tokens": {"x, y"}, "repeated": {"True"}})
+	assert.False(t, errPkg.Error())
+	assert.Equal(t, []string{"x, y"}, request.Tokens)
+
+	_, errPkg = requestFromQuery(url.Values{"package": {"fmt"}, "tokens": {"x, y"}, "repeated": {"yes"}})
+	assert.True(t, errPkg.Error())
+	assert.Equal(t, 400, errPkg.httpStatus)
+	assert.Equal(t, "the query parameter `repeated` must be a boolean", errPkg.innerError.Error())
+}
+
+func TestServer_Client_CommaTokens(t *testing.T) {
+
+	config := Configuration{Development: false, AllowDirectoryScans: true}
+	router := mux.NewRouter()
+	router.HandleFunc("/", baseGetHandler(IndexAction, config, logging.NewMockLogging(), NewBlankMeasurementTool()))
+	server := httptest.NewServer(router)
+	defer server.Close()
+	apiClient := client.NewClient(server.URL)
+
+	for _, request := range []models.CommentParsingRequest{
+		{Directory: "../services/testdata/sample", Tokens: []string{"T{1,2}ODO"}, MatchMode: models.MatchMode_REGEX},
+		{Directory: "../services/testdata/sample", Tokens: []string{"things, XXX"}},
+	} {
+		expected, err := services.NewScanner("", logging.NewMockLogging()).ExtractRelevantComments(context.Background(), request)
+		assert.Nil(t, err)
+		res, err := apiClient.Index(context.Background(), request)
+		assert.Nil(t, err)
+		assert.Equal(t, expected, res)
+		assert.Len(t, res.Matches[request.Tokens[0]], 1)
+	}
+}
@@ -0,0 +1,140 @@
+package server
+
+import (
+	"bytes"
+	"commentparser/logging"
+	"commentparser/signing"
+	"crypto/hmac"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// the default maximum age of the signature of a request
+const defaultSignatureMaxAge = 5 * time.Minute
+
+// verifies the signatures of requests and remembers their nonces until the signatures expire,
+// so a signed request cannot be replayed. A signatureVerifier is safe for concurrent use
+type signatureVerifier struct {
+	keys   map[string][]byte // the secrets by key ID
+	maxAge time.Duration     // how far the timestamp of a request can be from the time of the server
+	now    func() time.Time  // the time of the server
+
+	mutex     sync.Mutex           // guards the fields below
+	nonces    map[string]time.Time // the nonces used by key ID and nonce, with when their signature expires
+	nextSweep time.Time            // when the expired nonces are removed next
+}
+
+// create a new signatureVerifier with the secrets of the keys by key ID
+func newSignatureVerifier(keys map[string]string, maxAge time.Duration) *signatureVerifier {
+	verifier := &signatureVerifier{
+		keys:   make(map[string][]byte),
+		maxAge: maxAge,
+		now:    time.Now,
+		nonces: make(map[string]time.Time),
+	}
+	for keyID, secret := range keys {
+		verifier.keys[keyID] = []byte(secret)
+	}
+	return verifier
+}
+
+// verify the signature of a request with its body. The error describes why the signature is
+// rejected, and can be returned to the client
+func (verifier *signatureVerifier) verify(request *http.Request, body []byte) error {
+
+	keyID := request.Header.Get(signing.Header_KEY_ID)
+	nonce := request.Header.Get(signing.Header_NONCE)
+	signature := request.Header.Get(signing.Header_SIGNATURE)
+	if len(keyID) < 1 || len(nonce) < 1 || len(signature) < 1 {
+		return errors.New("The request is not signed")
+	}
+	secret, found := verifier.keys[keyID]
+	if !found {
+		return errors.New("The signing key of the request is unknown")
+	}
+	timestamp, err := strconv.ParseInt(request.Header.Get(signing.Header_TIMESTAMP), 10, 64)
+	if err != nil {
+		return errors.New("The signature timestamp of the request is not valid")
+	}
+	now := verifier.now()
+	signedAt := time.Unix(timestamp, 0)
+	if signedAt.Before(now.Add(-verifier.maxAge)) || signedAt.After(now.Add(verifier.maxAge)) {
+		return errors.New("The signature of the request has expired")
+	}
+	expected := signing.Signature(
+		secret, request.Method, request.URL.Path, request.URL.RawQuery, body, timestamp, nonce)
+	if !hmac.Equal([]byte(expected), []byte(signature)) {
+		return errors.New("The signature of the request is not valid")
+	}
+
+	// the nonce is only remembered once the signature is verified, so unsigned requests
+	// cannot fill the memory of the server
+	verifier.mutex.Lock()
+	defer verifier.mutex.Unlock()
+	if now.After(verifier.nextSweep) {
+		for key, expires := range verifier.nonces {
+			if now.After(expires) {
+				delete(verifier.nonces, key)
+			}
+		}
+		verifier.nextSweep = now.Add(verifier.maxAge)
+	}
+	key := keyID + "\x00" + nonce
+	if _, used := verifier.nonces[key]; used {
+		return errors.New("The signature of the request was already used")
+	}
+	verifier.nonces[key] = signedAt.Add(verifier.maxAge)
+	return nil
+}
+
+// the maximum age of the signature of a request
+func (config *Configuration) signatureMaxAge() time.Duration {
+	maxAge := time.Duration(config.SignatureMaxAgeSeconds) * time.Second
+	if maxAge <= 0 {
+		return defaultSignatureMaxAge
+	}
+	return maxAge
+}
+
+// Reject the requests without a valid signature with a 401 (Unauthorized) before calling the
+// handler, when the configuration has signing keys. The body of the request is read to be
+// verified, and is given to the handler as it was
+func (config *Configuration) verifySignatures(
+	next http.HandlerFunc,
+	logging logging.Logging) http.HandlerFunc {
+
+	if len(config.SigningKeys) < 1 {
+		return next
+	}
+	verifier := config.signatures
+	if verifier == nil {
+		// the handler is not part of a running server, its nonces are its own
+		verifier = newSignatureVerifier(config.SigningKeys, config.signatureMaxAge())
+	}
+	maxBytes := config.archiveMaxBytes()
+	return func(writer http.ResponseWriter, request *http.Request) {
+
+		body, err := ioutil.ReadAll(io.LimitReader(request.Body, maxBytes+1))
+		request.Body.Close()
+		if config.errorHandle(err, writer, logging) {
+			return
+		}
+		if int64(len(body)) > maxBytes {
+			http.Error(writer, "The body of the request is too large", http.StatusRequestEntityTooLarge)
+			return
+		}
+		if err := verifier.verify(request, body); err != nil {
+			logging.Warning("Rejected the request %s %s: %v", request.Method, request.URL.Path, err)
+			http.Error(writer, err.Error(), http.StatusUnauthorized)
+			return
+		}
+
+		request.Body = ioutil.NopCloser(bytes.NewReader(body))
+		next(writer, request)
+	}
+}
@@ -0,0 +1,103 @@
+package services
+
+import (
+	"commentparser/models"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// Conventional annotations, used by MatchMode_ANNOTATION, are a kind such as TODO, FIXME or
+// BUG followed by optional details in parentheses or brackets, an optional colon and the
+// message up to the end of the line, for example
+//
+//	TODO(alice): support more languages
+//	FIXME(#123) the cache is never invalidated
+//	TODO[2026-12-01]: remove once every client is migrated
+//	BUG(bob, JIRA-42, 2026-12-01): the result is truncated
+//
+// The details are seperated by commas and are either an issue reference (starting with "#",
+// a tracker key such as "JIRA-42" or a URL), a due date (YYYY-MM-DD) or the owner.
+
+// the characters an annotation kind can be made of
+var annotationKindExpression = regexp.MustCompile(`^\w+$`)
+
+// a tracker key such as JIRA-42
+var issueKeyExpression = regexp.MustCompile(`^[A-Z][A-Z0-9]+-\d+$`)
+
+// matches the annotations of a single kind
+type annotationMatcher struct {
+	kind       string         // the annotation kind, as given in the request
+	expression *regexp.Regexp // matches the kind, its details and its message
+}
+
+// compile the matcher of an annotation kind, honoring the IgnoreCase option of the request
+func compileAnnotation(kind string, request models.CommentParsingRequest) (tokenMatcher, error) {
+	if !annotationKindExpression.MatchString(kind) {
+		return nil, invalidRequest("Invalid annotation kind `%s`", kind)
+	}
+	pattern := `(?m)\b(` + regexp.QuoteMeta(kind) + `)\b((?:[ \t]*(?:\([^)\n]*\)|\[[^\]\n]*\]))*)[ \t]*:?[ \t]*(.*)$`
+	if request.IgnoreCase {
+		pattern = "(?i)" + pattern
+	}
+	return annotationMatcher{kind: kind, expression: regexp.MustCompile(pattern)}, nil
+}
+
+func (m annotationMatcher) match(text string) (string, int, bool) {
+	loc := m.expression.FindStringSubmatchIndex(text)
+	if loc == nil {
+		return "", 0, false
+	}
+	// the matched text is the annotation without its message
+	return strings.TrimRight(text[loc[0]:loc[5]], " \t"), loc[0], true
+}
+
+// parse all the annotations of the kind in the text of a comment
+func (m annotationMatcher) annotations(text string) []models.Annotation {
+	var annotations []models.Annotation
+	for _, loc := range m.expression.FindAllStringSubmatchIndex(text, -1) {
+		annotation := models.Annotation{
+			Kind:    text[loc[2]:loc[3]],
+			Message: strings.TrimSpace(text[loc[6]:loc[7]]),
+			Offset:  loc[0],
+		}
+		parseAnnotationDetails(text[loc[4]:loc[5]], &annotation)
+		annotations = append(annotations, annotation)
+	}
+	return annotations
+}
+
+// the annotations parsed by the matcher of a token, nil unless it is an annotation matcher
+func annotations(matcher tokenMatcher, text string) []models.Annotation {
+	if annotationMatcher, ok := matcher.(annotationMatcher); ok {
+		return annotationMatcher.annotations(text)
+	}
+	return nil
+}
+
+// fill the owner, issues and due date of an annotation from its details, such as "(alice, #12)".
+// Every issue reference is kept, in the order of the details
+func parseAnnotationDetails(details string, annotation *models.Annotation) {
+	details = strings.NewReplacer("(", ",", ")", ",", "[", ",", "]", ",").Replace(details)
+	for _, detail := range strings.Split(details, ",") {
+		detail = strings.TrimSpace(detail)
+		switch {
+		case len(detail) < 1:
+		case strings.HasPrefix(detail, "#") || issueKeyExpression.MatchString(detail) ||
+			strings.HasPrefix(detail, "http://") || strings.HasPrefix(detail, "https://"):
+			annotation.Issues = append(annotation.Issues, detail)
+		case isDate(detail):
+			if len(annotation.DueDate) < 1 {
+				annotation.DueDate = detail
+			}
+		case len(annotation.Owner) < 1:
+			annotation.Owner = strings.TrimPrefix(detail, "@")
+		}
+	}
+}
+
+// true if the text is a date formatted as YYYY-MM-DD
+func isDate(text string) bool {
+	_, err := time.Parse("2006-01-02", text)
+	return err == nil
+}
@@ -0,0 +1,62 @@
+package services
+
+import (
+	"commentparser/logging"
+	"commentparser/models"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestAnnotations_Parse(t *testing.T) {
+
+	matcher, err := compileAnnotation("TODO", models.CommentParsingRequest{})
+	assert.Nil(t, err)
+
+	text := "TODO(alice): support more languages\n" +
+		"TODO[2026-12-01]: remove once migrated\n" +
+		"see the TODO(@bob, JIRA-42, #7, 2026-01-31) the cache is never invalidated\n" +
+		"TODOS are not annotations, neither is todo\n" +
+		"TODO\n"
+
+	assert.Equal(t, []models.Annotation{
+		{Kind: "TODO", Owner: "alice", Message: "support more languages", Offset: 0},
+		{Kind: "TODO", DueDate: "2026-12-01", Message: "remove once migrated", Offset: 36},
+		{Kind: "TODO", Owner: "bob", Issues: []string{"JIRA-42", "#7"}, DueDate: "2026-01-31",
+			Message: "the cache is never invalidated", Offset: 83},
+		{Kind: "TODO", Offset: 193},
+	}, annotations(matcher, text))
+
+	matched, offset, ok := matcher.match("note: FIXME and TODO(alice): x")
+	assert.True(t, ok)
+	assert.Equal(t, "TODO(alice)", matched)
+	assert.Equal(t, 16, offset)
+
+	_, err = compileAnnotation("TODO(", models.CommentParsingRequest{})
+	assert.Equal(t, InvalidRequestError{"Invalid annotation kind `TODO(`"}, err)
+}
+
+func TestAnnotations_ExtractComments(t *testing.T) {
+
+	req := models.CommentParsingRequest{
+		Tokens:     []string{"TODO", "FIXME"},
+		MatchMode:  models.MatchMode_ANNOTATION,
+		IgnoreCase: true,
+	}
+	compiled, err := compileRequest(req)
+	assert.Nil(t, err)
+	res, _, _ := NewScanner("", logging.NewMockLogging()).extractCommentsWithTerms(
+		compiled, packageFile{path: "testdata/sample/sample.go"})
+
+	annotationsByLine := make(map[int][]models.Annotation)
+	for _, match := range res["TODO"] {
+		annotationsByLine[match.LineNumber] = match.Annotations
+	}
+	assert.Equal(t, map[int][]models.Annotation{
+		4:  {{Kind: "TODO", Owner: "alice", Message: "replace the greeting with a configurable one"}},
+		12: {{Kind: "todo", Message: "make this unexported"}},
+	}, annotationsByLine)
+
+	assert.Equal(t, 1, len(res["FIXME"]))
+	assert.Equal(t, []models.Annotation{{Kind: "FIXME", Message: "the purposes of this greeting are unclear"}},
+		res["FIXME"][0].Annotations)
+}
@@ -0,0 +1,310 @@
+package services
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"commentparser/models"
+	"compress/gzip"
+	"context"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// the limits applied when extracting an uploaded archive
+type ArchiveLimits struct {
+	MaxFiles          int   // the maximum number of entries in the archive
+	MaxExtractedBytes int64 // the maximum total size of the extracted files
+}
+
+// the default limits for archives, used for the limits that are not set
+var DefaultArchiveLimits = ArchiveLimits{
+	MaxFiles:          10000,
+	MaxExtractedBytes: 256 << 20,
+}
+
+// the limits with the default value for any limit that is not set
+func (limits ArchiveLimits) withDefaults() ArchiveLimits {
+	if limits.MaxFiles < 1 {
+		limits.MaxFiles = DefaultArchiveLimits.MaxFiles
+	}
+	if limits.MaxExtractedBytes < 1 {
+		limits.MaxExtractedBytes = DefaultArchiveLimits.MaxExtractedBytes
+	}
+	return limits
+}
+
+// true if the file of the archive is required to scan the packages it contains, which are
+// the Go sources and the files that locate the packages of a module
+func isArchiveSource(name string) bool {
+	base := path.Base(name)
+	return strings.HasSuffix(base, ".go") || base == "go.mod" || name == "vendor/modules.txt" ||
+		strings.HasSuffix(name, "/vendor/modules.txt")
+}
+
+// writes the files of an archive below a root directory, enforcing the limits
+type archiveWriter struct {
+	ctx       context.Context // stops the extraction once done
+	root      string          // the directory the archive is extracted to
+	limits    ArchiveLimits   // the limits to enforce
+	files     int             // the number of entries seen so far
+	extracted int64           // the number of bytes extracted so far, with the size of the skipped entries
+}
+
+// the error of an extraction stopped by its context
+func (w *archiveWriter) stopped() error {
+	if err := w.ctx.Err(); err != nil {
+		return incompleteScan(err, models.IncompleteScanResult{})
+	}
+	return nil
+}
+
+// reads the decompressed content of an archive, failing once more bytes than the limit of
+// extracted bytes were read or the extraction is stopped. Entries that are skipped are still
+// decompressed, so every byte read counts, not only those written
+type archiveReader struct {
+	writer *archiveWriter // the writer whose limits and context apply
+	reader io.Reader      // the decompressed content
+	read   int64          // the number of bytes read so far
+}
+
+// read the decompressed content
+func (r *archiveReader) Read(buffer []byte) (int, error) {
+	if err := r.writer.stopped(); err != nil {
+		return 0, err
+	}
+	n, err := r.reader.Read(buffer)
+	r.read += int64(n)
+	if r.read > r.writer.limits.MaxExtractedBytes {
+		return n, invalidRequest("The extracted archive is larger than %v bytes", r.writer.limits.MaxExtractedBytes)
+	}
+	return n, err
+}
+
+// count the declared size of an entry that is not extracted against the limit of extracted
+// bytes, so archives cannot hide large entries among the skipped ones
+func (w *archiveWriter) countSkipped(size int64) error {
+	w.extracted += size
+	if size < 0 || w.extracted > w.limits.MaxExtractedBytes {
+		return invalidRequest("The extracted archive is larger than %v bytes", w.limits.MaxExtractedBytes)
+	}
+	return nil
+}
+
+// count an entry of the archive against the file limit
+func (w *archiveWriter) countEntry() error {
+	if err := w.stopped(); err != nil {
+		return err
+	}
+	w.files++
+	if w.files > w.limits.MaxFiles {
+		return invalidRequest("The archive contains more than %v files", w.limits.MaxFiles)
+	}
+	return nil
+}
+
+// write a regular file of the archive, skipping files that are not needed to scan the
+// sources whose declared size is counted instead. Names that are absolute or escape the root
+// directory are rejected
+func (w *archiveWriter) writeFile(name string, size int64, content io.Reader) error {
+
+	name = strings.TrimPrefix(path.Clean("/"+strings.Replace(name, `\`, "/", -1)), "/")
+	if len(name) < 1 || name == "." || !isArchiveSource(name) {
+		return w.countSkipped(size)
+	}
+
+	target := filepath.Join(w.root, filepath.FromSlash(name))
+	if !strings.HasPrefix(target, w.root+string(filepath.Separator)) {
+		return invalidRequest("The archive contains the invalid file name `%s`", name)
+	}
+	if err := os.MkdirAll(filepath.Dir(target), 0700); err != nil {
+		return err
+	}
+	file, err := os.OpenFile(target, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	// read one byte more than allowed to detect archives exceeding the limit
+	remaining := w.limits.MaxExtractedBytes - w.extracted
+	written, err := io.Copy(file, io.LimitReader(content, remaining+1))
+	w.extracted += written
+	if err != nil {
+		return err
+	}
+	if w.extracted > w.limits.MaxExtractedBytes {
+		return invalidRequest("The extracted archive is larger than %v bytes", w.limits.MaxExtractedBytes)
+	}
+	return nil
+}
+
+// extract a gzip compressed tar archive
+func (w *archiveWriter) extractTarGz(archive []byte) error {
+	gzipReader, err := gzip.NewReader(bytes.NewReader(archive))
+	if err != nil {
+		return invalidRequest("The archive is not a valid tar.gz file: %v", err)
+	}
+	tarReader := tar.NewReader(&archiveReader{writer: w, reader: gzipReader})
+	for {
+		header, err := tarReader.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if _, limited := err.(InvalidRequestError); limited {
+			return err
+		}
+		if _, stopped := err.(IncompleteScanError); stopped {
+			return err
+		}
+		if err != nil {
+			return invalidRequest("The archive is not a valid tar.gz file: %v", err)
+		}
+		if err := w.countEntry(); err != nil {
+			return err
+		}
+		// links, devices and other special files are never extracted
+		if header.Typeflag == tar.TypeReg {
+			err = w.writeFile(header.Name, header.Size, tarReader)
+		} else {
+			err = w.countSkipped(header.Size)
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// extract a zip archive
+func (w *archiveWriter) extractZip(archive []byte) error {
+	zipReader, err := zip.NewReader(bytes.NewReader(archive), int64(len(archive)))
+	if err != nil {
+		return invalidRequest("The archive is not a valid zip file: %v", err)
+	}
+	for _, entry := range zipReader.File {
+		if err := w.countEntry(); err != nil {
+			return err
+		}
+		if !entry.Mode().IsRegular() {
+			if err := w.countSkipped(int64(entry.UncompressedSize64)); err != nil {
+				return err
+			}
+			continue
+		}
+		content, err := entry.Open()
+		if err != nil {
+			return invalidRequest("The archive is not a valid zip file: %v", err)
+		}
+		err = w.writeFile(entry.Name, int64(entry.UncompressedSize64), &archiveReader{writer: w, reader: content})
+		content.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Extract an archive of Go sources to a new temporary directory, which the caller must remove.
+// The archive is either a gzip compressed tar or a zip file, recognized by its content. Only
+// regular files needed to scan the sources are extracted, but every entry counts against the
+// limit of extracted bytes. When the context is done the extraction stops with an
+// IncompleteScanError
+func ExtractArchive(ctx context.Context, archive []byte, limits ArchiveLimits) (string, error) {
+
+	dir, err := ioutil.TempDir("", "commentparser-archive-")
+	if err != nil {
+		return "", err
+	}
+	writer := &archiveWriter{
+		ctx:    ctx,
+		root:   dir,
+		limits: limits.withDefaults(),
+	}
+
+	switch {
+	case bytes.HasPrefix(archive, []byte{0x1f, 0x8b}):
+		err = writer.extractTarGz(archive)
+	case bytes.HasPrefix(archive, []byte("PK\x03\x04")), bytes.HasPrefix(archive, []byte("PK\x05\x06")):
+		err = writer.extractZip(archive)
+	default:
+		err = invalidRequest("The archive must be a tar.gz or a zip file")
+	}
+
+	if err != nil {
+		os.RemoveAll(dir)
+		return "", err
+	}
+	return dir, nil
+}
+
+// the directory to scan in an extracted archive, archives often wrap the sources in a
+// single top level directory which is skipped so its go.mod file can be found
+func archiveRoot(dir string) string {
+	for {
+		entries, err := ioutil.ReadDir(dir)
+		if err != nil || len(entries) != 1 || !entries[0].IsDir() {
+			return dir
+		}
+		dir = filepath.Join(dir, entries[0].Name())
+	}
+}
+
+// Extract an archive of Go sources to a temporary directory and go through all the sources of
+// every package it contains, like ExtractRelevantCommentsForPattern does for "./...". The file
+// names of the result are relative to the root of the archive
+func (scanner Scanner) ExtractRelevantCommentsFromArchive(
+	ctx context.Context,
+	request models.CommentParsingRequest,
+	archive []byte,
+	limits ArchiveLimits) (models.MultiPackageParsingResult, error) {
+
+	// validate the request before extracting the archive
+	if _, err := compileRequest(request); err != nil {
+		return models.MultiPackageParsingResult{}, err
+	}
+
+	dir, err := ExtractArchive(ctx, archive, limits)
+	if err != nil {
+		return models.MultiPackageParsingResult{}, err
+	}
+	defer os.RemoveAll(dir)
+
+	// the archive is only scanned with the sandboxed scanner, whatever the request contains
+	archiveScanner := scanner
+	archiveScanner.WorkingDirectory = archiveRoot(dir)
+	archiveScanner.AllowDirectories = true
+	// the files of a temporary directory are never scanned again
+	archiveScanner.Cache = nil
+	request.PackageName = ""
+	request.Directory = "."
+
+	result, err := archiveScanner.ExtractRelevantCommentsForPattern(ctx, request)
+	result.Pattern = "./..."
+	relative := func(fileName string) string {
+		if rel, relErr := filepath.Rel(dir, fileName); relErr == nil {
+			return filepath.ToSlash(rel)
+		}
+		return fileName
+	}
+	for _, packageResult := range result.Packages {
+		for _, matches := range packageResult.Matches {
+			for idx := range matches {
+				matches[idx].FileName = relative(matches[idx].FileName)
+			}
+		}
+		for idx := range packageResult.OrderedMatches {
+			packageResult.OrderedMatches[idx].FileName = relative(packageResult.OrderedMatches[idx].FileName)
+		}
+		for idx := range packageResult.FileErrors {
+			fileError := &packageResult.FileErrors[idx]
+			fileError.FileName = relative(fileError.FileName)
+			// the errors of the build package name the temporary directory in their message
+			fileError.Message = strings.ReplaceAll(fileError.Message, dir+string(filepath.Separator), "")
+		}
+	}
+	return result, err
+}
@@ -0,0 +1,211 @@
+package services
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"commentparser/logging"
+	"commentparser/models"
+	"compress/gzip"
+	"context"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// create an in memory tar.gz archive with the given files
+func createTarGz(files map[string]string) []byte {
+	var buf bytes.Buffer
+	gzipWriter := gzip.NewWriter(&buf)
+	tarWriter := tar.NewWriter(gzipWriter)
+	for name, content := range files {
+		tarWriter.WriteHeader(&tar.Header{
+			Name:     name,
+			Mode:     0644,
+			Size:     int64(len(content)),
+			Typeflag: tar.TypeReg,
+		})
+		tarWriter.Write([]byte(content))
+	}
+	tarWriter.Close()
+	gzipWriter.Close()
+	return buf.Bytes()
+}
+
+// create an in memory zip archive with the given files
+func createZip(files map[string]string) []byte {
+	var buf bytes.Buffer
+	zipWriter := zip.NewWriter(&buf)
+	for name, content := range files {
+		fileWriter, _ := zipWriter.Create(name)
+		fileWriter.Write([]byte(content))
+	}
+	zipWriter.Close()
+	return buf.Bytes()
+}
+
+var archiveFiles = map[string]string{
+	"project/go.mod":            "module example.com/project\n\ngo 1.22\n",
+	"project/api/api.go":        "package api\n\n// TODO: version the api\nfunc Api() {}\n",
+	"project/store/store.go":    "package store\n\n// TODO(carol): add a cache\nfunc Get() {}\n",
+	"project/store/README.md":   "TODO: not a go file",
+	"project/store/testdata/x":  "skipped",
+	"project/cmd/server/run.go": "package main\n\n// TODO: commands are skipped\nfunc main() {}\n",
+}
+
+func TestArchives_ExtractTarGzAndZip(t *testing.T) {
+
+	for name, archive := range map[string][]byte{
+		"tar.gz": createTarGz(archiveFiles),
+		"zip":    createZip(archiveFiles),
+	} {
+		scanner := NewScanner("", logging.NewMockLogging())
+		scanner.AllowDirectories = false
+
+		res, err := scanner.ExtractRelevantCommentsFromArchive(
+			context.Background(),
+			models.CommentParsingRequest{Tokens: []string{"TODO"}},
+			archive,
+			ArchiveLimits{})
+
+		assert.Nil(t, err, name)
+		assert.Equal(t, "./...", res.Pattern)
+		assert.Equal(t, 2, len(res.Packages), name)
+		assert.Equal(t, "example.com/project/api", res.Packages[0].ImportPath)
+		assert.Equal(t, "project/api/api.go", res.Packages[0].Matches["TODO"][0].FileName)
+		assert.Equal(t, "example.com/project/store", res.Packages[1].ImportPath)
+	}
+}
+
+func TestArchives_RelativeFileNames(t *testing.T) {
+
+	archive := createTarGz(map[string]string{
+		"project/go.mod":         "module example.com/project\n\ngo 1.22\n",
+		"project/api/api.go":     "package api\n\n// TODO: version the api\nfunc Api() {}\n",
+		"project/api/broken.go":  "package api\n\n// TODO: fix the syntax\nfunc Broken( {}\n",
+		"project/store/store.go": "package store\n\n// FIXME: add a cache\nfunc Get() {}\n",
+		"project/store/other.go": "package other\n",
+	})
+	scanner := NewScanner("", logging.NewMockLogging())
+
+	res, err := scanner.ExtractRelevantCommentsFromArchive(
+		context.Background(),
+		models.CommentParsingRequest{Tokens: []string{"TODO", "FIXME"}, SortBy: []string{"token"}},
+		archive,
+		ArchiveLimits{})
+
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(res.Packages))
+	var fileNames []string
+	for _, packageResult := range res.Packages {
+		for _, match := range packageResult.OrderedMatches {
+			fileNames = append(fileNames, match.FileName)
+		}
+		for _, fileError := range packageResult.FileErrors {
+			fileNames = append(fileNames, fileError.FileName)
+			assert.False(t, strings.Contains(fileError.Message, os.TempDir()), fileError.Message)
+		}
+	}
+	assert.Contains(t, fileNames, "project/api/api.go")
+	assert.Contains(t, fileNames, "project/api/broken.go")
+	assert.Contains(t, fileNames, "project/store/store.go")
+	for _, fileName := range fileNames {
+		assert.False(t, filepath.IsAbs(fileName), fileName)
+	}
+}
+
+func TestArchives_OnlySourcesAreExtracted(t *testing.T) {
+
+	dir, err := ExtractArchive(context.Background(), createTarGz(archiveFiles), ArchiveLimits{})
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	_, err = os.Stat(filepath.Join(dir, "project", "store", "store.go"))
+	assert.Nil(t, err)
+	_, err = os.Stat(filepath.Join(dir, "project", "store", "README.md"))
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestArchives_PathTraversal(t *testing.T) {
+
+	parent, _ := ioutil.TempDir("", "commentparser-test-")
+	defer os.RemoveAll(parent)
+
+	dir, err := ExtractArchive(context.Background(), createZip(map[string]string{
+		"../../" + filepath.Base(parent) + "/escaped.go": "package escaped\n",
+		"/absolute.go": "package absolute\n",
+	}), ArchiveLimits{})
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	// the names are rooted in the extraction directory
+	_, err = os.Stat(filepath.Join(dir, filepath.Base(parent), "escaped.go"))
+	assert.Nil(t, err)
+	_, err = os.Stat(filepath.Join(dir, "absolute.go"))
+	assert.Nil(t, err)
+	_, err = os.Stat(filepath.Join(parent, "escaped.go"))
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestArchives_Limits(t *testing.T) {
+
+	_, err := ExtractArchive(context.Background(), createTarGz(archiveFiles), ArchiveLimits{MaxFiles: 3})
+	assert.Equal(t, InvalidRequestError{"The archive contains more than 3 files"}, err)
+
+	_, err = ExtractArchive(context.Background(), createZip(archiveFiles), ArchiveLimits{MaxExtractedBytes: 64})
+	assert.Equal(t, InvalidRequestError{"The extracted archive is larger than 64 bytes"}, err)
+
+	_, err = ExtractArchive(context.Background(), []byte("not an archive"), ArchiveLimits{})
+	assert.Equal(t, InvalidRequestError{"The archive must be a tar.gz or a zip file"}, err)
+}
+
+func TestArchives_SkippedEntriesAreCounted(t *testing.T) {
+
+	files := map[string]string{
+		"project/main.go":  "package main\n",
+		"project/blob.bin": strings.Repeat("0", 1024),
+	}
+	for name, archive := range map[string][]byte{
+		"tar.gz": createTarGz(files),
+		"zip":    createZip(files),
+	} {
+		_, err := ExtractArchive(context.Background(), archive, ArchiveLimits{MaxExtractedBytes: 512})
+		assert.Equal(t, InvalidRequestError{"The extracted archive is larger than 512 bytes"}, err, name)
+	}
+}
+
+func TestArchives_Cancelled(t *testing.T) {
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	for name, archive := range map[string][]byte{
+		"tar.gz": createTarGz(archiveFiles),
+		"zip":    createZip(archiveFiles),
+	} {
+		_, err := ExtractArchive(ctx, archive, ArchiveLimits{})
+		_, incomplete := err.(IncompleteScanError)
+		assert.True(t, incomplete, name)
+	}
+}
+
+func TestScanner_Directory(t *testing.T) {
+
+	scanner := NewScanner("", logging.NewMockLogging())
+	res, err := scanner.ExtractRelevantComments(context.Background(), models.CommentParsingRequest{
+		Directory: "testdata/sample",
+		Tokens:    []string{"TODO"},
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, "sample", res.PackageName)
+	assert.Equal(t, 1, len(res.Matches["TODO"]))
+
+	scanner.AllowDirectories = false
+	_, err = scanner.ExtractRelevantComments(context.Background(), models.CommentParsingRequest{
+		Directory: "testdata/sample",
+		Tokens:    []string{"TODO"},
+	})
+	assert.Equal(t, InvalidRequestError{"Scanning directories is not enabled"}, err)
+}
@@ -0,0 +1,100 @@
+package services
+
+import (
+	"commentparser/models"
+	"context"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// true if the text is the hash of a commit, either SHA-1 or SHA-256
+func isCommitHash(text string) bool {
+	if len(text) != 40 && len(text) != 64 {
+		return false
+	}
+	for _, r := range text {
+		if !strings.ContainsRune("0123456789abcdef", r) {
+			return false
+		}
+	}
+	return true
+}
+
+// parse the output of git blame --porcelain into the commit of every line, by line number
+// starting at 1. The lines that are not committed yet have no commit
+func parseBlame(output string, now time.Time) map[int]*models.Blame {
+
+	lines := make(map[int]*models.Blame)
+	commits := make(map[string]*models.Blame)
+	var current *models.Blame
+	lineNumber := 0
+	for _, line := range strings.Split(output, "\n") {
+		if strings.HasPrefix(line, "\t") {
+			// the content of the line ends the entry of the line
+			if current != nil && strings.Trim(current.Commit, "0") != "" {
+				lines[lineNumber] = current
+			}
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) >= 3 && isCommitHash(fields[0]) {
+			// the header of a line: the commit, the line in the original file and the line in this file
+			lineNumber, _ = strconv.Atoi(fields[2])
+			if current = commits[fields[0]]; current == nil {
+				current = &models.Blame{Commit: fields[0]}
+				commits[fields[0]] = current
+			}
+			continue
+		}
+		if current == nil || len(fields) < 2 {
+			continue
+		}
+		value := strings.TrimPrefix(line, fields[0]+" ")
+		switch fields[0] {
+		case "author":
+			current.Author = value
+		case "author-mail":
+			current.AuthorEmail = strings.TrimSuffix(strings.TrimPrefix(value, "<"), ">")
+		case "author-time":
+			seconds, _ := strconv.ParseInt(value, 10, 64)
+			current.Date = time.Unix(seconds, 0).UTC()
+			current.AgeDays = int(now.Sub(current.Date).Hours() / 24)
+		}
+	}
+	return lines
+}
+
+// the commit of every line of a file, see parseBlame. Files outside of a git repository result
+// in an error
+func blameFile(ctx context.Context, fileName string) (map[int]*models.Blame, error) {
+	output, err := runGit(ctx, filepath.Dir(fileName), "blame", "--porcelain", "--", filepath.Base(fileName))
+	if err != nil {
+		return nil, err
+	}
+	return parseBlame(string(output), time.Now()), nil
+}
+
+// annotate the matches of a file with the commit of the first line of their comment. The
+// matches of a file that cannot be blamed, such as a file outside of a git repository, are
+// left as they are
+func (scanner Scanner) blameMatches(
+	ctx context.Context,
+	fileName string,
+	matches map[string][]models.MatchedComment) {
+
+	if len(matches) < 1 {
+		return
+	}
+	lines, err := blameFile(ctx, fileName)
+	if err != nil {
+		scanner.Logging.Debug("Could not blame %s: %v", fileName, err)
+		return
+	}
+	for _, tokenMatches := range matches {
+		for idx := range tokenMatches {
+			tokenMatches[idx].Blame = lines[tokenMatches[idx].LineNumber]
+		}
+	}
+}
@@ -0,0 +1,110 @@
+package services
+
+import (
+	"commentparser/logging"
+	"commentparser/models"
+	"context"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"testing"
+	"time"
+)
+
+func TestBlame_Parse(t *testing.T) {
+
+	output := "" +
+		"1a2b3c4d5e6f7a8b9c0d1a2b3c4d5e6f7a8b9c0d 1 1 2\n" +
+		"author Alice\n" +
+		"author-mail <alice@example.com>\n" +
+		"author-time 1700000000\n" +
+		"author-tz +0100\n" +
+		"summary first\n" +
+		"filename a.go\n" +
+		"\tpackage a\n" +
+		"1a2b3c4d5e6f7a8b9c0d1a2b3c4d5e6f7a8b9c0d 2 2\n" +
+		"\t\n" +
+		"0000000000000000000000000000000000000000 3 3 1\n" +
+		"author Not Committed Yet\n" +
+		"author-time 1700100000\n" +
+		"filename a.go\n" +
+		"\t// TODO: not committed\n"
+
+	now := time.Unix(1700000000, 0).Add(50 * time.Hour)
+	lines := parseBlame(output, now)
+	assert.Equal(t, 2, len(lines))
+	assert.Equal(t, &models.Blame{
+		Commit:      "1a2b3c4d5e6f7a8b9c0d1a2b3c4d5e6f7a8b9c0d",
+		Author:      "Alice",
+		AuthorEmail: "alice@example.com",
+		Date:        time.Unix(1700000000, 0).UTC(),
+		AgeDays:     2,
+	}, lines[1])
+	assert.True(t, lines[1] == lines[2], "the lines of a commit share its blame")
+	assert.Nil(t, lines[3])
+}
+
+func TestBlame_Matches(t *testing.T) {
+
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not installed")
+	}
+	repository, _ := ioutil.TempDir("", "blamerepo")
+	defer os.RemoveAll(repository)
+	git := func(author string, date string, args ...string) {
+		cmd := exec.Command("git", append([]string{"-c", "user.name=" + author, "-c", "user.email=" + author + "@example.com"}, args...)...)
+		cmd.Dir = repository
+		cmd.Env = append(os.Environ(), "GIT_AUTHOR_DATE="+date, "GIT_COMMITTER_DATE="+date)
+		output, err := cmd.CombinedOutput()
+		assert.Nil(t, err, string(output))
+	}
+	git("alice", "2020-01-01T00:00:00Z", "init", "-q")
+	writeModule(repository, map[string]string{
+		"go.mod": "module example.com/blamed\n",
+		"a.go":   "package a\n\n// TODO: recent\nfunc A() {}\n",
+	})
+	git("alice", "2022-01-01T00:00:00Z", "add", "-A")
+	git("alice", "2022-01-01T00:00:00Z", "commit", "-q", "-m", "first")
+	writeModule(repository, map[string]string{
+		"a.go": "package a\n\n// TODO: recent\nfunc A() {}\n\n// TODO: old\nfunc B() {}\n",
+	})
+	git("bob", "2021-01-01T00:00:00Z", "commit", "-q", "-a", "-m", "second")
+	writeModule(repository, map[string]string{
+		"a.go": "package a\n\n// TODO: recent\nfunc A() {}\n\n// TODO: old\nfunc B() {}\n\n// TODO: not committed\nfunc C() {}\n",
+	})
+
+	scanner := NewScanner(repository, logging.NewMockLogging())
+	res, err := scanner.ExtractRelevantComments(context.Background(), models.CommentParsingRequest{
+		PackageName: "example.com/blamed",
+		Tokens:      []string{"TODO"},
+		Blame:       true,
+		SortBy:      []string{"age"},
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, 3, len(res.OrderedMatches))
+	assert.Equal(t, "TODO: old\n", res.OrderedMatches[0].LineContent)
+	assert.Equal(t, "bob", res.OrderedMatches[0].Blame.Author)
+	assert.Equal(t, "alice", res.OrderedMatches[1].Blame.Author)
+	assert.Equal(t, 2022, res.OrderedMatches[1].Blame.Date.Year())
+	assert.Nil(t, res.OrderedMatches[2].Blame, "lines not committed yet sort last")
+
+	// the files outside of a repository have no blame
+	outside, _ := ioutil.TempDir("", "blame")
+	defer os.RemoveAll(outside)
+	writeModule(outside, map[string]string{"a.go": "package a\n\n// TODO: outside\nfunc A() {}\n"})
+	res, err = NewScanner("", logging.NewMockLogging()).ExtractRelevantComments(context.Background(), models.CommentParsingRequest{
+		Directory: outside,
+		Tokens:    []string{"TODO"},
+		Blame:     true,
+	})
+	assert.Nil(t, err)
+	assert.Nil(t, res.Matches["TODO"][0].Blame)
+
+	_, err = scanner.ExtractRelevantComments(context.Background(), models.CommentParsingRequest{
+		PackageName: "example.com/blamed",
+		Tokens:      []string{"TODO"},
+		SortBy:      []string{"author"},
+	})
+	assert.Equal(t, "The sort key `author` requires `Blame`", err.Error())
+}
@@ -0,0 +1,253 @@
+package services
+
+import (
+	"commentparser/models"
+	"container/list"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// the version of the parsedFile format, part of every cache key so the entries written by
+// another version are never read
+const parseCacheVersion = 1
+
+// the default number of files a ParseCache keeps in memory
+const DefaultParseCacheEntries = 10000
+
+// how old the temporary file of an entry must be to be removed when a cache is created, so the
+// entries being written by another process sharing the directory are left alone
+const parseCacheTempFileAge = 10 * time.Minute
+
+// ParseCache keeps the comments extracted from parsed files, so the files that did not change
+// are not parsed again by the next scans. Entries are keyed by file name and content hash, the
+// most recently used ones are kept in memory and, when the cache has a directory, every entry
+// is also written to that directory so it survives restarts. The file of an entry is removed
+// when the entry is evicted from memory, and the directory is trimmed to its maxEntries most
+// recent files, along with the stale temporary files of interrupted writes, when the cache is
+// created, so it holds at most twice maxEntries files. A ParseCache is safe for concurrent use
+// by several scans
+type ParseCache struct {
+	maxEntries int                      // the maximum number of entries kept in memory
+	dir        string                   // the directory entries are persisted to, empty to only keep them in memory
+	mutex      sync.Mutex               // guards the fields below
+	recent     *list.List               // the entries in memory, the most recently used first
+	entries    map[string]*list.Element // the elements of recent by key
+}
+
+// an entry of the in memory cache
+type parseCacheEntry struct {
+	key    string     // the key of the file
+	parsed parsedFile // the comments of the file
+}
+
+// create a new ParseCache keeping at most maxEntries files in memory, DefaultParseCacheEntries
+// if not set, and persisting them to dir unless it is empty. The directory is created if needed
+func NewParseCache(maxEntries int, dir string) (*ParseCache, error) {
+	if maxEntries < 1 {
+		maxEntries = DefaultParseCacheEntries
+	}
+	if len(dir) > 0 {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return nil, err
+		}
+		if err := trimParseCacheDirectory(dir, maxEntries); err != nil {
+			return nil, err
+		}
+	}
+	return &ParseCache{
+		maxEntries: maxEntries,
+		dir:        dir,
+		recent:     list.New(),
+		entries:    make(map[string]*list.Element),
+	}, nil
+}
+
+// remove the entries of a cache directory but the maxEntries most recently written ones. The
+// entries kept by a previous run are not in memory, so they would never be evicted otherwise.
+// The temporary files older than parseCacheTempFileAge, left by a run that stopped before
+// renaming them, are removed as well
+func trimParseCacheDirectory(dir string, maxEntries int) error {
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	var entries []os.FileInfo
+	stale := time.Now().Add(-parseCacheTempFileAge)
+	for _, file := range files {
+		if !file.Mode().IsRegular() {
+			continue
+		}
+		if strings.HasSuffix(file.Name(), ".json") {
+			entries = append(entries, file)
+		} else if strings.HasSuffix(file.Name(), ".tmp") && file.ModTime().Before(stale) {
+			if err := os.Remove(filepath.Join(dir, file.Name())); err != nil && !os.IsNotExist(err) {
+				return err
+			}
+		}
+	}
+	if len(entries) <= maxEntries {
+		return nil
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].ModTime().After(entries[j].ModTime()) })
+	for _, entry := range entries[maxEntries:] {
+		if err := os.Remove(filepath.Join(dir, entry.Name())); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+// the key of a file with the given content
+func parseCacheKey(fileName string, src []byte) string {
+	hash := sha256.New()
+	fmt.Fprintf(hash, "%v\x00%s\x00", parseCacheVersion, fileName)
+	hash.Write(src)
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+// the comments of a file, from memory or from the directory of the cache
+func (cache *ParseCache) get(key string) (parsedFile, bool) {
+
+	cache.mutex.Lock()
+	if element, found := cache.entries[key]; found {
+		cache.recent.MoveToFront(element)
+		cache.mutex.Unlock()
+		return element.Value.(parseCacheEntry).parsed, true
+	}
+	cache.mutex.Unlock()
+
+	if len(cache.dir) < 1 {
+		return parsedFile{}, false
+	}
+	data, err := ioutil.ReadFile(filepath.Join(cache.dir, key+".json"))
+	if err != nil {
+		return parsedFile{}, false
+	}
+	var parsed parsedFile
+	if err := json.Unmarshal(data, &parsed); err != nil {
+		return parsedFile{}, false // a corrupted entry is parsed again and overwritten
+	}
+	cache.remember(key, parsed)
+	return parsed, true
+}
+
+// store the comments of a file in memory and in the directory of the cache. Failing to write
+// the entry to the directory only means the file is parsed again after a restart
+func (cache *ParseCache) put(key string, parsed parsedFile) error {
+	cache.remember(key, parsed)
+	if len(cache.dir) < 1 {
+		return nil
+	}
+	data, err := json.Marshal(parsed)
+	if err != nil {
+		return err
+	}
+	// the entry is renamed once written, so concurrent readers never see a partial entry
+	tmp, err := ioutil.TempFile(cache.dir, key+".*.tmp")
+	if err != nil {
+		return err
+	}
+	_, err = tmp.Write(data)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), filepath.Join(cache.dir, key+".json"))
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+	}
+	return err
+}
+
+// keep an entry in memory, evicting the least recently used entries over maxEntries along with
+// their files
+func (cache *ParseCache) remember(key string, parsed parsedFile) {
+	var evicted []string
+	cache.mutex.Lock()
+	if element, found := cache.entries[key]; found {
+		cache.recent.MoveToFront(element)
+	} else {
+		cache.entries[key] = cache.recent.PushFront(parseCacheEntry{key: key, parsed: parsed})
+	}
+	for cache.recent.Len() > cache.maxEntries {
+		oldest := cache.recent.Back()
+		cache.recent.Remove(oldest)
+		delete(cache.entries, oldest.Value.(parseCacheEntry).key)
+		evicted = append(evicted, oldest.Value.(parseCacheEntry).key)
+	}
+	cache.mutex.Unlock()
+
+	// an evicted entry is only parsed again, so failing to remove its file is not an error
+	if len(cache.dir) > 0 {
+		for _, key := range evicted {
+			os.Remove(filepath.Join(cache.dir, key+".json"))
+		}
+	}
+}
+
+// the number of entries kept in memory
+func (cache *ParseCache) Len() int {
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+	return cache.recent.Len()
+}
+
+// CacheStats counts the files found in the ParseCache of a scanner, and those that had to be
+// parsed. The counters are updated atomically by the workers of the scans
+type CacheStats struct {
+	Hits   int64 // the number of files whose comments were found in the cache
+	Misses int64 // the number of files that were parsed
+}
+
+// count a file found in the cache, stats can be nil
+func (stats *CacheStats) hit() {
+	if stats != nil {
+		atomic.AddInt64(&stats.Hits, 1)
+	}
+}
+
+// count a file that was parsed, stats can be nil
+func (stats *CacheStats) miss() {
+	if stats != nil {
+		atomic.AddInt64(&stats.Misses, 1)
+	}
+}
+
+// a snapshot of the counters that is safe to read while scans are running
+func (stats *CacheStats) Snapshot() CacheStats {
+	return CacheStats{
+		Hits:   atomic.LoadInt64(&stats.Hits),
+		Misses: atomic.LoadInt64(&stats.Misses),
+	}
+}
+
+// the comments of a file that was parsed, which do not depend on the request so they can be
+// cached. The fields are exported to be persisted as json by the ParseCache
+type parsedFile struct {
+	Comments        []parsedComment    // the comment groups of the file
+	BuildConstraint string             // the build constraint of the file from its name and //go:build line
+	BinaryOnly      bool               // true if the file marks its package as binary only
+	Errors          []models.FileError // the syntax errors of the file
+}
+
+// a comment group of a parsed file
+type parsedComment struct {
+	Text          string              // the text of the comment group, see ast.CommentGroup.Text
+	LineNumber    int                 // the line where the comment starts
+	EndLineNumber int                 // the line where the comment ends
+	EndColumn     int                 // the column just after the end of the comment
+	NodeKind      string              // the kind of AST node the comment is attached to
+	IsDocComment  bool                // true if the comment is the doc comment of that node
+	Declaration   *models.Declaration // the declaration enclosing the comment
+}
@@ -0,0 +1,136 @@
+package services
+
+import (
+	"commentparser/logging"
+	"commentparser/models"
+	"context"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestCache_HitsAndMisses(t *testing.T) {
+
+	cache, err := NewParseCache(0, "")
+	assert.Nil(t, err)
+	req := models.CommentParsingRequest{
+		Directory:   "testdata/sample",
+		Tokens:      []string{"TODO"},
+		FileClasses: []string{models.FileClass_GO, models.FileClass_TEST},
+	}
+
+	scan := func() (models.CommentParsingResult, CacheStats) {
+		scanner := NewScanner("", logging.NewMockLogging())
+		scanner.Cache = cache
+		scanner.CacheStats = &CacheStats{}
+		res, err := scanner.ExtractRelevantComments(context.Background(), req)
+		assert.Nil(t, err)
+		return res, scanner.CacheStats.Snapshot()
+	}
+
+	first, stats := scan()
+	assert.Equal(t, CacheStats{Hits: 0, Misses: 2}, stats)
+	second, stats := scan()
+	assert.Equal(t, CacheStats{Hits: 2, Misses: 0}, stats)
+	assert.Equal(t, first, second)
+	assert.Equal(t, 2, cache.Len())
+}
+
+func TestCache_Directory(t *testing.T) {
+
+	dir, _ := ioutil.TempDir("", "parsecache")
+	defer os.RemoveAll(dir)
+	src, _ := ioutil.ReadFile("testdata/sample/sample.go")
+	fileName := filepath.Join(dir, "sample.go")
+	ioutil.WriteFile(fileName, src, 0644)
+	compiled, _ := compileRequest(models.CommentParsingRequest{Tokens: []string{"TODO"}})
+
+	scan := func(cache *ParseCache) (map[string][]models.MatchedComment, CacheStats) {
+		scanner := NewScanner("", logging.NewMockLogging())
+		scanner.Cache = cache
+		scanner.CacheStats = &CacheStats{}
+		res, _, _ := scanner.extractCommentsWithTerms(compiled, packageFile{path: fileName})
+		return res, scanner.CacheStats.Snapshot()
+	}
+
+	cache, err := NewParseCache(0, filepath.Join(dir, "cache"))
+	assert.Nil(t, err)
+	expected, stats := scan(cache)
+	assert.Equal(t, int64(1), stats.Misses)
+
+	// a new cache with the same directory finds the file parsed by the first one
+	restarted, _ := NewParseCache(0, filepath.Join(dir, "cache"))
+	res, stats := scan(restarted)
+	assert.Equal(t, int64(1), stats.Hits)
+	assert.Equal(t, expected, res)
+
+	// a changed file is parsed again
+	ioutil.WriteFile(fileName, append(src, []byte("\n// TODO: a new comment\n")...), 0644)
+	res, stats = scan(restarted)
+	assert.Equal(t, int64(1), stats.Misses)
+	assert.Equal(t, len(expected["TODO"])+1, len(res["TODO"]))
+}
+
+func TestCache_Eviction(t *testing.T) {
+
+	cache, _ := NewParseCache(2, "")
+	cache.put("a", parsedFile{})
+	cache.put("b", parsedFile{})
+	cache.get("a")
+	cache.put("c", parsedFile{})
+
+	assert.Equal(t, 2, cache.Len())
+	_, found := cache.get("b")
+	assert.False(t, found, "the least recently used entry is evicted")
+	_, found = cache.get("a")
+	assert.True(t, found)
+}
+
+func TestCache_DirectoryIsBounded(t *testing.T) {
+
+	dir, _ := ioutil.TempDir("", "parsecache")
+	defer os.RemoveAll(dir)
+	entries := func() int {
+		files, _ := filepath.Glob(filepath.Join(dir, "*.json"))
+		return len(files)
+	}
+
+	// the files of the evicted entries are removed
+	cache, _ := NewParseCache(2, dir)
+	cache.put("a", parsedFile{})
+	cache.put("b", parsedFile{})
+	cache.put("c", parsedFile{})
+	assert.Equal(t, 2, entries())
+	_, err := os.Stat(filepath.Join(dir, "a.json"))
+	assert.True(t, os.IsNotExist(err))
+
+	// a new cache keeps the most recently written files of the directory
+	old := time.Now().Add(-time.Hour)
+	os.Chtimes(filepath.Join(dir, "b.json"), old, old)
+	_, err = NewParseCache(1, dir)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, entries())
+	_, err = os.Stat(filepath.Join(dir, "c.json"))
+	assert.Nil(t, err)
+}
+
+func TestCache_StaleTempFilesAreRemoved(t *testing.T) {
+
+	dir, _ := ioutil.TempDir("", "parsecache")
+	defer os.RemoveAll(dir)
+	ioutil.WriteFile(filepath.Join(dir, "a.123.tmp"), []byte("{"), 0644)
+	ioutil.WriteFile(filepath.Join(dir, "b.456.tmp"), []byte("{"), 0644)
+	old := time.Now().Add(-time.Hour)
+	os.Chtimes(filepath.Join(dir, "a.123.tmp"), old, old)
+
+	// only the temporary files older than the grace period are removed
+	_, err := NewParseCache(1, dir)
+	assert.Nil(t, err)
+	_, err = os.Stat(filepath.Join(dir, "a.123.tmp"))
+	assert.True(t, os.IsNotExist(err))
+	_, err = os.Stat(filepath.Join(dir, "b.456.tmp"))
+	assert.Nil(t, err)
+}
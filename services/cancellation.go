@@ -0,0 +1,35 @@
+package services
+
+import (
+	"commentparser/models"
+	"fmt"
+)
+
+// an error returned when the context of a scan is done before the scan completes, such as when
+// the client is gone or the deadline of the scan expired. The results of the packages scanned
+// so far are returned alongside it
+type IncompleteScanError struct {
+	Cause  error                       // the error of the context, context.Canceled or context.DeadlineExceeded
+	Result models.IncompleteScanResult // how far the scan went
+}
+
+// create a new IncompleteScanError, describing the progress of the scan in its message
+func incompleteScan(cause error, result models.IncompleteScanResult) IncompleteScanError {
+	result.Message = fmt.Sprintf(
+		"The scan was stopped after %v of %v packages and %v of %v files: %v",
+		result.PackagesScanned, result.PackagesTotal, result.FilesScanned, result.FilesTotal, cause)
+	return IncompleteScanError{
+		Cause:  cause,
+		Result: result,
+	}
+}
+
+// the message describing how far the scan went
+func (err IncompleteScanError) Error() string {
+	return err.Result.Message
+}
+
+// the error of the context, so errors.Is(err, context.DeadlineExceeded) reports expired deadlines
+func (err IncompleteScanError) Unwrap() error {
+	return err.Cause
+}
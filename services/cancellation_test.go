@@ -0,0 +1,45 @@
+package services
+
+import (
+	"commentparser/logging"
+	"commentparser/models"
+	"context"
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestCancellation_Cancelled(t *testing.T) {
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	scanner := NewScanner("", logging.NewMockLogging())
+	_, err := scanner.ExtractRelevantComments(ctx, models.CommentParsingRequest{
+		Directory: "testdata/sample",
+		Tokens:    []string{"TODO"},
+	})
+
+	assert.IsType(t, IncompleteScanError{}, err)
+	assert.True(t, errors.Is(err, context.Canceled))
+	assert.Equal(t, 1, err.(IncompleteScanError).Result.PackagesTotal)
+	assert.Equal(t, 0, err.(IncompleteScanError).Result.PackagesScanned)
+	assert.Equal(t, 0, err.(IncompleteScanError).Result.FilesScanned)
+}
+
+func TestCancellation_DeadlineExpired(t *testing.T) {
+
+	ctx, cancel := context.WithDeadline(context.Background(), time.Now().Add(-time.Second))
+	defer cancel()
+
+	scanner := NewScanner("", logging.NewMockLogging())
+	res, err := scanner.ExtractRelevantCommentsForPattern(ctx, models.CommentParsingRequest{
+		Directory: "testdata/sample",
+		Tokens:    []string{"TODO"},
+	})
+
+	assert.True(t, errors.Is(err, context.DeadlineExceeded))
+	assert.Empty(t, res.Packages)
+	assert.Contains(t, err.Error(), "The scan was stopped")
+}
@@ -0,0 +1,278 @@
+package services
+
+import (
+	"commentparser/models"
+	"fmt"
+	"go/ast"
+	"go/token"
+	"go/types"
+	"sort"
+	"strings"
+)
+
+// validate the DeclarationKinds of the request, returning the set of kinds to report. An
+// InvalidRequestError is returned for unknown kinds
+func selectDeclarationKinds(request models.CommentParsingRequest) (map[string]bool, error) {
+	known := map[string]bool{
+		models.DeclarationKind_FUNC:   true,
+		models.DeclarationKind_METHOD: true,
+		models.DeclarationKind_TYPE:   true,
+		models.DeclarationKind_FIELD:  true,
+		models.DeclarationKind_CONST:  true,
+		models.DeclarationKind_VAR:    true,
+		models.DeclarationKind_IMPORT: true,
+	}
+	selected := make(map[string]bool)
+	for _, kind := range request.DeclarationKinds {
+		if !known[kind] {
+			return nil, invalidRequest("Unknown declaration kind `%s`", kind)
+		}
+		selected[kind] = true
+	}
+	return selected, nil
+}
+
+// the kind of an AST node as reported in MatchedComment.NodeKind, such as "FuncDecl" or "Field"
+func nodeKind(node ast.Node) string {
+	return strings.TrimPrefix(fmt.Sprintf("%T", node), "*ast.")
+}
+
+// true if the comment group is the doc comment of the node it is attached to
+func isDocComment(node ast.Node, group *ast.CommentGroup) bool {
+	var doc *ast.CommentGroup
+	switch n := node.(type) {
+	case *ast.File:
+		doc = n.Doc
+	case *ast.FuncDecl:
+		doc = n.Doc
+	case *ast.GenDecl:
+		doc = n.Doc
+	case *ast.TypeSpec:
+		doc = n.Doc
+	case *ast.ValueSpec:
+		doc = n.Doc
+	case *ast.ImportSpec:
+		doc = n.Doc
+	case *ast.Field:
+		doc = n.Doc
+	}
+	return doc != nil && doc == group
+}
+
+// the extent of a node including its doc comment and its line comment
+func nodeExtent(node ast.Node) (token.Pos, token.Pos) {
+	var doc, comment *ast.CommentGroup
+	switch n := node.(type) {
+	case *ast.FuncDecl:
+		doc = n.Doc
+	case *ast.GenDecl:
+		doc = n.Doc
+		// the line comment of a declaration without parentheses follows its only spec
+		if !n.Lparen.IsValid() && len(n.Specs) == 1 {
+			_, comment = nodeExtentComments(n.Specs[0])
+		}
+	default:
+		doc, comment = nodeExtentComments(node)
+	}
+	start, end := node.Pos(), node.End()
+	if doc != nil && doc.Pos() < start {
+		start = doc.Pos()
+	}
+	if comment != nil && comment.End() > end {
+		end = comment.End()
+	}
+	return start, end
+}
+
+// the doc comment and line comment of specs and fields
+func nodeExtentComments(node ast.Node) (*ast.CommentGroup, *ast.CommentGroup) {
+	switch n := node.(type) {
+	case *ast.TypeSpec:
+		return n.Doc, n.Comment
+	case *ast.ValueSpec:
+		return n.Doc, n.Comment
+	case *ast.ImportSpec:
+		return n.Doc, n.Comment
+	case *ast.Field:
+		return n.Doc, n.Comment
+	}
+	return nil, nil
+}
+
+// the paths of nodes from the file down to the innermost node whose extent contains each comment
+// group of a file, found in a single walk of the file. The walk only descends into the nodes
+// containing comments, and as the comments of the file are sorted those of a node are a slice of
+// the comments of its parent
+func commentPaths(f *ast.File) map[*ast.CommentGroup][]ast.Node {
+
+	// the nodes of the current path, with the comments contained in each of them
+	var path []ast.Node
+	var contained [][]*ast.CommentGroup
+	paths := make(map[*ast.CommentGroup][]ast.Node, len(f.Comments))
+	ast.Inspect(f, func(n ast.Node) bool {
+		if n == nil {
+			// the innermost node is left first, the comments without a path yet end there
+			for _, group := range contained[len(contained)-1] {
+				if _, found := paths[group]; !found {
+					paths[group] = append([]ast.Node(nil), path...)
+				}
+			}
+			path = path[:len(path)-1]
+			contained = contained[:len(contained)-1]
+			return false
+		}
+		comments := f.Comments
+		if len(contained) > 0 {
+			comments = contained[len(contained)-1]
+		}
+		start, end := nodeExtent(n)
+		from := sort.Search(len(comments), func(idx int) bool { return comments[idx].Pos() >= start })
+		to := sort.Search(len(comments), func(idx int) bool { return comments[idx].End() > end })
+		if from >= to {
+			return false
+		}
+		path = append(path, n)
+		contained = append(contained, comments[from:to])
+		return true
+	})
+	return paths
+}
+
+// describe a single spec of a general declaration
+func specDeclaration(tok token.Token, spec ast.Spec) *models.Declaration {
+	switch s := spec.(type) {
+	case *ast.TypeSpec:
+		return &models.Declaration{
+			Kind:     models.DeclarationKind_TYPE,
+			Name:     s.Name.Name,
+			Exported: s.Name.IsExported(),
+		}
+	case *ast.ValueSpec:
+		kind := models.DeclarationKind_VAR
+		if tok == token.CONST {
+			kind = models.DeclarationKind_CONST
+		}
+		return &models.Declaration{
+			Kind:     kind,
+			Name:     s.Names[0].Name,
+			Exported: s.Names[0].IsExported(),
+		}
+	case *ast.ImportSpec:
+		return &models.Declaration{
+			Kind: models.DeclarationKind_IMPORT,
+			Name: strings.Trim(s.Path.Value, "`\""),
+		}
+	}
+	return nil
+}
+
+// describe a general declaration, a block of several specs has no name
+func genDeclaration(decl *ast.GenDecl) *models.Declaration {
+	if len(decl.Specs) == 1 {
+		return specDeclaration(decl.Tok, decl.Specs[0])
+	}
+	kind := map[token.Token]string{
+		token.CONST:  models.DeclarationKind_CONST,
+		token.VAR:    models.DeclarationKind_VAR,
+		token.TYPE:   models.DeclarationKind_TYPE,
+		token.IMPORT: models.DeclarationKind_IMPORT,
+	}[decl.Tok]
+	return &models.Declaration{Kind: kind}
+}
+
+// the name of the type declared by the innermost type spec of the path, empty if there is none
+func enclosingTypeName(path []ast.Node) string {
+	for idx := len(path) - 1; idx >= 0; idx-- {
+		if spec, ok := path[idx].(*ast.TypeSpec); ok {
+			return spec.Name.Name
+		}
+	}
+	return ""
+}
+
+// the innermost declaration enclosing each comment group of a file, see enclosingDeclaration.
+// The comments of the file itself have no declaration
+func commentDeclarations(f *ast.File) map[*ast.CommentGroup]*models.Declaration {
+	declarations := make(map[*ast.CommentGroup]*models.Declaration, len(f.Comments))
+	for group, path := range commentPaths(f) {
+		if declaration := enclosingDeclaration(path); declaration != nil {
+			declarations[group] = declaration
+		}
+	}
+	return declarations
+}
+
+// the innermost declaration enclosing a comment given the path of nodes down to it, including
+// its doc and line comments, which is a
+// function, a method, a type, a field or method of a struct or interface type, or a const, var or
+// import declaration. nil is returned for the comments of the file itself, such as the package doc.
+// The declarations of a function body are local to the function, so the comments of its body are
+// reported as the function's. Likewise the comments of the body of a function literal declared
+// outside of any function are reported as the declaration the literal belongs to, such as a var
+func enclosingDeclaration(path []ast.Node) *models.Declaration {
+
+	for idx, node := range path {
+		var body *ast.BlockStmt
+		switch n := node.(type) {
+		case *ast.FuncDecl:
+			body = n.Body
+		case *ast.FuncLit:
+			body = n.Body
+		}
+		if body != nil && idx+1 < len(path) && path[idx+1] == ast.Node(body) {
+			path = path[:idx+1]
+			break
+		}
+	}
+	for idx := len(path) - 1; idx >= 0; idx-- {
+		switch n := path[idx].(type) {
+		case *ast.Field:
+			// parameters and results are part of the function that declares them
+			if idx < 2 {
+				continue
+			}
+			owner := path[idx-2]
+			_, inStruct := owner.(*ast.StructType)
+			_, inInterface := owner.(*ast.InterfaceType)
+			if !inStruct && !inInterface {
+				continue
+			}
+			declaration := &models.Declaration{
+				Kind: models.DeclarationKind_FIELD,
+				Type: enclosingTypeName(path[:idx]),
+			}
+			if len(n.Names) > 0 {
+				declaration.Name = n.Names[0].Name
+			} else {
+				// an embedded field is named after its type
+				declaration.Name = strings.TrimPrefix(types.ExprString(n.Type), "*")
+				if dot := strings.LastIndex(declaration.Name, "."); dot >= 0 {
+					declaration.Name = declaration.Name[dot+1:]
+				}
+			}
+			if _, isFunc := n.Type.(*ast.FuncType); inInterface && isFunc {
+				declaration.Kind = models.DeclarationKind_METHOD
+				declaration.Receiver = declaration.Type
+				declaration.Type = ""
+			}
+			declaration.Exported = ast.IsExported(declaration.Name)
+			return declaration
+		case *ast.FuncDecl:
+			declaration := &models.Declaration{
+				Kind:     models.DeclarationKind_FUNC,
+				Name:     n.Name.Name,
+				Exported: n.Name.IsExported(),
+			}
+			if n.Recv != nil && len(n.Recv.List) > 0 {
+				declaration.Kind = models.DeclarationKind_METHOD
+				declaration.Receiver = types.ExprString(n.Recv.List[0].Type)
+			}
+			return declaration
+		case *ast.TypeSpec, *ast.ValueSpec, *ast.ImportSpec:
+			return specDeclaration(path[idx-1].(*ast.GenDecl).Tok, n.(ast.Spec))
+		case *ast.GenDecl:
+			return genDeclaration(n)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,125 @@
+package services
+
+import (
+	"commentparser/logging"
+	"commentparser/models"
+	"context"
+	"github.com/stretchr/testify/assert"
+	"go/parser"
+	"go/token"
+	"testing"
+)
+
+func TestDeclarations_Enclosing(t *testing.T) {
+
+	compiled, err := compileRequest(models.CommentParsingRequest{Tokens: []string{"NOTE"}})
+	assert.Nil(t, err)
+	res, _, _ := NewScanner("", logging.NewMockLogging()).extractCommentsWithTerms(
+		compiled, packageFile{path: "testdata/declarations/declarations.go"})
+
+	type expectation struct {
+		nodeKind     string
+		declaration  *models.Declaration
+		isDocComment bool
+	}
+	byLine := make(map[int]expectation)
+	for _, match := range res["NOTE"] {
+		byLine[match.LineNumber] = expectation{match.NodeKind, match.Declaration, match.IsDocComment}
+	}
+
+	assert.Equal(t, map[int]expectation{
+		1: {"File", nil, true},
+		5: {"ImportSpec", &models.Declaration{Kind: models.DeclarationKind_IMPORT, Name: "fmt"}, true},
+		9: {"GenDecl", &models.Declaration{Kind: models.DeclarationKind_CONST}, true},
+		15: {"GenDecl", &models.Declaration{
+			Kind: models.DeclarationKind_VAR, Name: "defaultBuffer"}, true},
+		18: {"GenDecl", &models.Declaration{
+			Kind: models.DeclarationKind_TYPE, Name: "Buffer", Exported: true}, true},
+		20: {"Field", &models.Declaration{
+			Kind: models.DeclarationKind_FIELD, Name: "Values", Type: "Buffer", Exported: true}, true},
+		22: {"Field", &models.Declaration{
+			Kind: models.DeclarationKind_FIELD, Name: "Stringer", Type: "Buffer", Exported: true}, false},
+		24: {"Field", &models.Declaration{
+			Kind: models.DeclarationKind_FIELD, Name: "size", Type: "Buffer"}, false},
+		29: {"Field", &models.Declaration{
+			Kind: models.DeclarationKind_METHOD, Name: "Write", Receiver: "Writer", Exported: true}, true},
+		33: {"FuncDecl", &models.Declaration{
+			Kind: models.DeclarationKind_METHOD, Name: "Add", Receiver: "*Buffer", Exported: true}, true},
+		36: {"AssignStmt", &models.Declaration{
+			Kind: models.DeclarationKind_METHOD, Name: "Add", Receiver: "*Buffer", Exported: true}, false},
+		40: {"AssignStmt", &models.Declaration{Kind: models.DeclarationKind_FUNC, Name: "reset"}, false},
+		// the comment map attaches a trailing comment to the last node before it
+		44: {"BasicLit", nil, false},
+	}, byLine)
+}
+
+func TestDeclarations_Filter(t *testing.T) {
+
+	scanner := NewScanner("", logging.NewMockLogging())
+	req := models.CommentParsingRequest{
+		Directory:        "testdata/declarations",
+		Tokens:           []string{"NOTE"},
+		DeclarationKinds: []string{models.DeclarationKind_FUNC, models.DeclarationKind_METHOD},
+		ExportedOnly:     true,
+	}
+
+	res, err := scanner.ExtractRelevantComments(context.Background(), req)
+	assert.Nil(t, err)
+	var names []string
+	for _, match := range res.Matches["NOTE"] {
+		names = append(names, match.Declaration.Name)
+	}
+	assert.ElementsMatch(t, []string{"Write", "Add", "Add"}, names)
+
+	req.DeclarationKinds = []string{"package"}
+	_, err = scanner.ExtractRelevantComments(context.Background(), req)
+	assert.Equal(t, InvalidRequestError{"Unknown declaration kind `package`"}, err)
+}
+
+func TestDeclarations_LocalTypes(t *testing.T) {
+
+	src := `package local
+
+func Pairs() {
+	// NOTE: a type local to the function
+	type pair struct {
+		key string // NOTE: the key of the pair
+	}
+	_ = pair{}
+}
+`
+	fileSet := token.NewFileSet()
+	f, err := parser.ParseFile(fileSet, "local.go", src, parser.ParseComments)
+	assert.Nil(t, err)
+
+	// the comments of a local type and of its fields are reported as the function's
+	for _, group := range f.Comments {
+		assert.Equal(t, &models.Declaration{Kind: models.DeclarationKind_FUNC, Name: "Pairs", Exported: true},
+			commentDeclarations(f)[group], group.Text())
+	}
+	assert.Equal(t, 2, len(f.Comments))
+}
+
+func TestDeclarations_FunctionLiterals(t *testing.T) {
+
+	src := `package local
+
+var Handler = func() {
+	// NOTE: a type local to the function literal
+	type pair struct {
+		key string // NOTE: the key of the pair
+	}
+	_ = pair{}
+}
+`
+	fileSet := token.NewFileSet()
+	f, err := parser.ParseFile(fileSet, "literal.go", src, parser.ParseComments)
+	assert.Nil(t, err)
+
+	// the comments of the body of a function literal are reported as the var it is assigned to
+	for _, group := range f.Comments {
+		assert.Equal(t, &models.Declaration{Kind: models.DeclarationKind_VAR, Name: "Handler", Exported: true},
+			commentDeclarations(f)[group], group.Text())
+	}
+	assert.Equal(t, 2, len(f.Comments))
+}
@@ -0,0 +1,320 @@
+package services
+
+import (
+	"commentparser/models"
+	"context"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// the minimum similarity of the contents of two comments for a comment that changed to be
+// reported as moved, rather than removed from the base and added to the head
+const diffSimilarityThreshold = 0.6
+
+// the distinct trigrams of a text regardless of case
+func trigramSet(text string) map[uint32]bool {
+	set := make(map[uint32]bool)
+	for _, trigram := range trigrams(text) {
+		set[trigram] = true
+	}
+	return set
+}
+
+// the similarity of two sets of trigrams, their Dice coefficient: 1 for the same trigrams and 0
+// for sets without any trigram in common
+func diceCoefficient(setA, setB map[uint32]bool) float64 {
+	if len(setA)+len(setB) < 1 {
+		return 0
+	}
+	common := 0
+	for trigram := range setB {
+		if setA[trigram] {
+			common++
+		}
+	}
+	return 2 * float64(common) / float64(len(setA)+len(setB))
+}
+
+// a pair of matches of the base and head revisions that can be the same comment
+type diffCandidate struct {
+	base       int     // the index of the match in the base revision
+	head       int     // the index of the match in the head revision
+	similarity float64 // the similarity of their contents
+	sameFile   bool    // true if both matches are in the same file
+	distance   int     // how many lines apart the matches are, when in the same file
+}
+
+// compare the matches of two revisions. The matches with the same file, token and content are
+// paired first, in order, and are reported as moved if their line changed. The remaining
+// matches of the same token are then paired by decreasing similarity of their contents,
+// preferring the matches of the same file and the closest lines, and reported as moved when
+// their similarity is at least diffSimilarityThreshold. Only the matches of the same token
+// sharing a trigram, or with the same content, are compared, and the comparison stops with
+// an IncompleteScanError once the context is done. The added and removed matches are in the
+// order of their revision, and the moved matches in the order of the head revision
+func diffComments(
+	ctx context.Context,
+	base, head []models.MatchedComment) ([]models.MatchedComment, []models.MatchedComment, []models.MovedComment, error) {
+
+	pairedBase := make([]bool, len(base))
+	pairedHead := make([]bool, len(head))
+	var moved []models.MovedComment
+	movedHead := make(map[int]models.MovedComment)
+
+	unpaired := make(map[string][]int)
+	for idx, match := range base {
+		key := watchKey(match)
+		unpaired[key] = append(unpaired[key], idx)
+	}
+	for idx, match := range head {
+		key := watchKey(match)
+		if len(unpaired[key]) < 1 {
+			continue
+		}
+		baseIdx := unpaired[key][0]
+		unpaired[key] = unpaired[key][1:]
+		pairedBase[baseIdx], pairedHead[idx] = true, true
+		if base[baseIdx].LineNumber != match.LineNumber {
+			movedHead[idx] = models.MovedComment{Base: base[baseIdx], Head: match, Similarity: 1}
+		}
+	}
+
+	// the unpaired matches of the head revision by token, then by trigram and by content
+	headTrigrams := make(map[int]map[uint32]bool)
+	byTrigram := make(map[string]map[uint32][]int)
+	byContent := make(map[string]map[string][]int)
+	for headIdx, match := range head {
+		if pairedHead[headIdx] {
+			continue
+		}
+		headTrigrams[headIdx] = trigramSet(match.LineContent)
+		if byTrigram[match.Token] == nil {
+			byTrigram[match.Token] = make(map[uint32][]int)
+			byContent[match.Token] = make(map[string][]int)
+		}
+		for trigram := range headTrigrams[headIdx] {
+			byTrigram[match.Token][trigram] = append(byTrigram[match.Token][trigram], headIdx)
+		}
+		byContent[match.Token][match.LineContent] = append(byContent[match.Token][match.LineContent], headIdx)
+	}
+
+	var candidates []diffCandidate
+	for baseIdx := range base {
+		if pairedBase[baseIdx] {
+			continue
+		}
+		if err := ctx.Err(); err != nil {
+			return nil, nil, nil, incompleteScan(err, diffProgress(base, head))
+		}
+		baseTrigrams := trigramSet(base[baseIdx].LineContent)
+		compared := make(map[int]bool)
+		var headIdxs []int
+		for trigram := range baseTrigrams {
+			for _, headIdx := range byTrigram[base[baseIdx].Token][trigram] {
+				if !compared[headIdx] {
+					compared[headIdx] = true
+					headIdxs = append(headIdxs, headIdx)
+				}
+			}
+		}
+		for _, headIdx := range byContent[base[baseIdx].Token][base[baseIdx].LineContent] {
+			if !compared[headIdx] {
+				compared[headIdx] = true
+				headIdxs = append(headIdxs, headIdx)
+			}
+		}
+		// in the order of the head revision, so that ties are broken the same way every time
+		sort.Ints(headIdxs)
+		for _, headIdx := range headIdxs {
+			candidate := diffCandidate{
+				base:       baseIdx,
+				head:       headIdx,
+				similarity: 1,
+				sameFile:   base[baseIdx].FileName == head[headIdx].FileName,
+			}
+			if base[baseIdx].LineContent != head[headIdx].LineContent {
+				candidate.similarity = diceCoefficient(baseTrigrams, headTrigrams[headIdx])
+			}
+			if candidate.similarity < diffSimilarityThreshold {
+				continue
+			}
+			if candidate.sameFile {
+				candidate.distance = base[baseIdx].LineNumber - head[headIdx].LineNumber
+				if candidate.distance < 0 {
+					candidate.distance = -candidate.distance
+				}
+			}
+			candidates = append(candidates, candidate)
+		}
+	}
+	sort.SliceStable(candidates, func(i, j int) bool {
+		a, b := candidates[i], candidates[j]
+		if a.similarity != b.similarity {
+			return a.similarity > b.similarity
+		}
+		if a.sameFile != b.sameFile {
+			return a.sameFile
+		}
+		return a.distance < b.distance
+	})
+	for _, candidate := range candidates {
+		if pairedBase[candidate.base] || pairedHead[candidate.head] {
+			continue
+		}
+		pairedBase[candidate.base], pairedHead[candidate.head] = true, true
+		movedHead[candidate.head] = models.MovedComment{
+			Base:       base[candidate.base],
+			Head:       head[candidate.head],
+			Similarity: candidate.similarity,
+		}
+	}
+
+	var added, removed []models.MatchedComment
+	for idx, match := range head {
+		if change, found := movedHead[idx]; found {
+			moved = append(moved, change)
+		} else if !pairedHead[idx] {
+			added = append(added, match)
+		}
+	}
+	for idx, match := range base {
+		if !pairedBase[idx] {
+			removed = append(removed, match)
+		}
+	}
+	return added, removed, moved, nil
+}
+
+// the progress of a diff stopped while comparing the matches, once every file was scanned
+func diffProgress(base, head []models.MatchedComment) models.IncompleteScanResult {
+	files := make(map[string]bool)
+	for _, matches := range [][]models.MatchedComment{base, head} {
+		for _, match := range matches {
+			files[match.FileName] = true
+		}
+	}
+	return models.IncompleteScanResult{FilesTotal: len(files), FilesScanned: len(files)}
+}
+
+// the directory of a revision of a diff, relative to the working directory of the scanner
+func (scanner Scanner) diffDirectory(name string) (string, error) {
+	dir := name
+	if !filepath.IsAbs(dir) {
+		base := scanner.WorkingDirectory
+		if len(base) < 1 {
+			var err error
+			if base, err = os.Getwd(); err != nil {
+				return "", err
+			}
+		}
+		dir = filepath.Join(base, dir)
+	}
+	if info, err := os.Stat(dir); err != nil || !info.IsDir() {
+		return "", invalidRequest("The directory `%s` does not exist", name)
+	}
+	return dir, nil
+}
+
+// scan the packages of a request in the directory of a revision, and return their matches with
+// file names relative to that directory
+func (scanner Scanner) scanRevision(
+	ctx context.Context,
+	dir string,
+	request models.CommentParsingRequest) ([]models.MatchedComment, error) {
+
+	revisionScanner := scanner
+	revisionScanner.WorkingDirectory = dir
+	// the index only contains the packages of the working directory of the server
+	revisionScanner.Index = nil
+	results, err := revisionScanner.scanRequest(ctx, request)
+	if err != nil {
+		return nil, err
+	}
+	matches := resultMatches(results)
+	for idx := range matches {
+		if rel, relErr := filepath.Rel(dir, matches[idx].FileName); relErr == nil {
+			matches[idx].FileName = filepath.ToSlash(rel)
+		}
+	}
+	return matches, nil
+}
+
+// Scan the same packages in two revisions and report the matches that were added, removed and
+// moved from the base to the head revision, see diffComments. The revisions are either two
+// directories, or two git revisions of a repository checked out in temporary worktrees that
+// are removed once scanned. The PackageName of the request is resolved in both revisions, so
+// it must be relative such as "./..." (the default) or "./server". Directories and
+// repositories are relative to the working directory of the scanner, and can only be scanned
+// if the scanner allows directories
+func (scanner Scanner) DiffComments(
+	ctx context.Context,
+	request models.CommentDiffRequest) (models.CommentDiffResult, error) {
+
+	result := models.CommentDiffResult{Base: request.Base, Head: request.Head}
+	if len(request.Base) < 1 || len(request.Head) < 1 {
+		return result, invalidRequest("The parameters `Base` and `Head` cannot be empty")
+	}
+	parsingRequest := request.Request
+	if len(parsingRequest.PackageName) < 1 {
+		parsingRequest.PackageName = "./..."
+	}
+	if !isLocalPattern(parsingRequest.PackageName) {
+		return result, invalidRequest(
+			"The package name `%s` must be relative to the revisions, such as `./...`", parsingRequest.PackageName)
+	}
+	if len(parsingRequest.Directory) > 0 {
+		return result, invalidRequest("The `Directory` of a diff is given by `Base` and `Head`")
+	}
+	if len(parsingRequest.SortBy) < 1 {
+		parsingRequest.SortBy = []string{sortKeyFile, sortKeyLine}
+	}
+	// validate the tokens before doing any work
+	if _, err := compileRequest(parsingRequest); err != nil {
+		return result, err
+	}
+	if !scanner.AllowDirectories {
+		return result, invalidRequest("Scanning directories is not enabled")
+	}
+
+	var dirs [2]string
+	if len(request.Repository) > 0 {
+		repository, err := scanner.diffDirectory(request.Repository)
+		if err != nil {
+			return result, err
+		}
+		// the files of a temporary worktree are never scanned again
+		scanner.Cache = nil
+		for idx, revision := range []string{request.Base, request.Head} {
+			dir, remove, err := checkoutWorktree(ctx, repository, revision)
+			if err != nil {
+				return result, err
+			}
+			defer remove()
+			dirs[idx] = dir
+		}
+	} else {
+		for idx, dir := range []string{request.Base, request.Head} {
+			var err error
+			if dirs[idx], err = scanner.diffDirectory(dir); err != nil {
+				return result, err
+			}
+		}
+	}
+
+	base, err := scanner.scanRevision(ctx, dirs[0], parsingRequest)
+	if err != nil {
+		return result, err
+	}
+	head, err := scanner.scanRevision(ctx, dirs[1], parsingRequest)
+	if err != nil {
+		return result, err
+	}
+	result.Added, result.Removed, result.Moved, err = diffComments(ctx, base, head)
+	if err != nil {
+		return result, err
+	}
+	scanner.Logging.Debug("The diff found %v added, %v removed and %v moved matches",
+		len(result.Added), len(result.Removed), len(result.Moved))
+	return result, nil
+}
@@ -0,0 +1,176 @@
+package services
+
+import (
+	"commentparser/logging"
+	"commentparser/models"
+	"context"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestDiff_Comments(t *testing.T) {
+
+	match := func(file string, line int, content string) models.MatchedComment {
+		return models.MatchedComment{FileName: file, Token: "TODO", LineNumber: line, LineContent: content}
+	}
+	base := []models.MatchedComment{
+		match("a.go", 3, "TODO: unchanged\n"),
+		match("a.go", 8, "TODO: moved down\n"),
+		match("a.go", 12, "TODO: handle the error of the request\n"),
+		match("a.go", 20, "TODO: resolved\n"),
+		match("b.go", 4, "TODO: moved to another file\n"),
+	}
+	head := []models.MatchedComment{
+		match("a.go", 3, "TODO: unchanged\n"),
+		match("a.go", 10, "TODO: moved down\n"),
+		match("a.go", 14, "TODO: handle the errors of the request\n"),
+		match("a.go", 18, "TODO: introduced\n"),
+		match("c.go", 4, "TODO: moved to another file\n"),
+	}
+
+	added, removed, moved, err := diffComments(context.Background(), base, head)
+	assert.Nil(t, err)
+	assert.Equal(t, []models.MatchedComment{head[3]}, added)
+	assert.Equal(t, []models.MatchedComment{base[3]}, removed)
+	assert.Equal(t, 3, len(moved))
+	assert.Equal(t, models.MovedComment{Base: base[1], Head: head[1], Similarity: 1}, moved[0])
+	assert.Equal(t, base[2], moved[1].Base)
+	assert.Equal(t, head[2], moved[1].Head)
+	assert.True(t, moved[1].Similarity > diffSimilarityThreshold && moved[1].Similarity < 1)
+	assert.Equal(t, models.MovedComment{Base: base[4], Head: head[4], Similarity: 1}, moved[2])
+}
+
+func TestDiff_Comments_Cancelled(t *testing.T) {
+
+	var base, head []models.MatchedComment
+	for idx := 0; idx < 100; idx++ {
+		base = append(base, models.MatchedComment{FileName: "a.go", Token: "TODO", LineNumber: idx, LineContent: "TODO: old"})
+		head = append(head, models.MatchedComment{FileName: "b.go", Token: "TODO", LineNumber: idx, LineContent: "TODO: new"})
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, _, _, err := diffComments(ctx, base, head)
+	assert.IsType(t, IncompleteScanError{}, err)
+	assert.Equal(t, models.IncompleteScanResult{
+		Message:      "The scan was stopped after 0 of 0 packages and 2 of 2 files: context canceled",
+		FilesTotal:   2,
+		FilesScanned: 2,
+	}, err.(IncompleteScanError).Result)
+}
+
+// write the files of a module, the keys are slash seperated paths relative to root
+func writeModule(root string, files map[string]string) {
+	for name, content := range files {
+		fileName := filepath.Join(root, filepath.FromSlash(name))
+		os.MkdirAll(filepath.Dir(fileName), 0755)
+		ioutil.WriteFile(fileName, []byte(content), 0644)
+	}
+}
+
+func TestDiff_Directories(t *testing.T) {
+
+	root, _ := ioutil.TempDir("", "diff")
+	defer os.RemoveAll(root)
+	writeModule(filepath.Join(root, "old"), map[string]string{
+		"go.mod": "module example.com/diffed\n",
+		"a/a.go": "package a\n\n// TODO: first\nfunc A() {}\n\n// TODO: resolved\nfunc B() {}\n",
+	})
+	writeModule(filepath.Join(root, "new"), map[string]string{
+		"go.mod": "module example.com/diffed\n",
+		"a/a.go": "package a\n\nimport \"fmt\"\n\n// TODO: first\nfunc A() { fmt.Println() }\n",
+		"b/b.go": "package b\n\n// TODO: introduced\nfunc B() {}\n",
+	})
+
+	scanner := NewScanner(root, logging.NewMockLogging())
+	res, err := scanner.DiffComments(context.Background(), models.CommentDiffRequest{
+		Base:    "old",
+		Head:    "new",
+		Request: models.CommentParsingRequest{Tokens: []string{"TODO"}},
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(res.Added))
+	assert.Equal(t, "b/b.go", res.Added[0].FileName)
+	assert.Equal(t, 1, len(res.Removed))
+	assert.Equal(t, "TODO: resolved\n", res.Removed[0].LineContent)
+	assert.Equal(t, 1, len(res.Moved))
+	assert.Equal(t, 3, res.Moved[0].Base.LineNumber)
+	assert.Equal(t, 5, res.Moved[0].Head.LineNumber)
+
+	_, err = scanner.DiffComments(context.Background(), models.CommentDiffRequest{
+		Base:    "old",
+		Head:    "new",
+		Request: models.CommentParsingRequest{PackageName: "fmt", Tokens: []string{"TODO"}},
+	})
+	assert.IsType(t, InvalidRequestError{}, err)
+
+	scanner.AllowDirectories = false
+	_, err = scanner.DiffComments(context.Background(), models.CommentDiffRequest{
+		Base:    "old",
+		Head:    "new",
+		Request: models.CommentParsingRequest{Tokens: []string{"TODO"}},
+	})
+	assert.Equal(t, "Scanning directories is not enabled", err.Error())
+}
+
+func TestDiff_GitRevisions(t *testing.T) {
+
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not installed")
+	}
+	repository, _ := ioutil.TempDir("", "diffrepo")
+	defer os.RemoveAll(repository)
+	git := func(args ...string) {
+		cmd := exec.Command("git", append([]string{"-c", "user.name=test", "-c", "user.email=test@example.com"}, args...)...)
+		cmd.Dir = repository
+		output, err := cmd.CombinedOutput()
+		assert.Nil(t, err, string(output))
+	}
+	git("init", "-q")
+	writeModule(repository, map[string]string{
+		"go.mod": "module example.com/diffed\n",
+		"a.go":   "package a\n\n// TODO: first\nfunc A() {}\n",
+	})
+	git("add", "-A")
+	git("commit", "-q", "-m", "first")
+	writeModule(repository, map[string]string{
+		"a.go": "package a\n\n// TODO: first\nfunc A() {}\n\n// TODO: second\nfunc B() {}\n",
+	})
+	git("commit", "-q", "-a", "-m", "second")
+
+	scanner := NewScanner("", logging.NewMockLogging())
+	res, err := scanner.DiffComments(context.Background(), models.CommentDiffRequest{
+		Base:       "HEAD~1",
+		Head:       "HEAD",
+		Repository: repository,
+		Request:    models.CommentParsingRequest{Tokens: []string{"TODO"}},
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(res.Added))
+	assert.Equal(t, "a.go", res.Added[0].FileName)
+	assert.Equal(t, 0, len(res.Removed))
+	assert.Equal(t, 0, len(res.Moved))
+
+	// the worktrees are removed once scanned
+	output, _ := exec.Command("git", "-C", repository, "worktree", "list").Output()
+	assert.Equal(t, 1, strings.Count(string(output), "\n"))
+
+	_, err = scanner.DiffComments(context.Background(), models.CommentDiffRequest{
+		Base:       "--output=/tmp/x",
+		Head:       "HEAD",
+		Repository: repository,
+		Request:    models.CommentParsingRequest{Tokens: []string{"TODO"}},
+	})
+	assert.IsType(t, InvalidRequestError{}, err)
+	_, err = scanner.DiffComments(context.Background(), models.CommentDiffRequest{
+		Base:       "unknown",
+		Head:       "HEAD",
+		Repository: repository,
+		Request:    models.CommentParsingRequest{Tokens: []string{"TODO"}},
+	})
+	assert.Equal(t, "The revision `unknown` does not exist in the repository", err.Error())
+}
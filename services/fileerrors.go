@@ -0,0 +1,60 @@
+package services
+
+import (
+	"commentparser/models"
+	"go/build"
+	"go/scanner"
+	"path/filepath"
+	"sort"
+)
+
+// the errors of a file that could not be read or parsed. The syntax errors reported by the
+// parser are listed with their position, any other error is listed for the whole file
+func fileErrors(fileName string, err error) []models.FileError {
+	list, ok := err.(scanner.ErrorList)
+	if !ok {
+		return []models.FileError{{FileName: fileName, Message: err.Error()}}
+	}
+	var errors []models.FileError
+	for _, e := range list {
+		errors = append(errors, models.FileError{
+			FileName:   fileName,
+			LineNumber: e.Pos.Line,
+			Column:     e.Pos.Column,
+			Message:    e.Msg,
+		})
+	}
+	return errors
+}
+
+// true if the package could be imported despite the error, because the error is only about
+// some of its files, such as a syntax error in the imports or a different package clause
+func hasInvalidFiles(p *build.Package, err error) bool {
+	if _, noGo := err.(*build.NoGoError); noGo {
+		return false
+	}
+	return err != nil && p != nil && len(p.InvalidGoFiles) > 0
+}
+
+// the errors of the files of a package that could still be imported. Syntax errors are left
+// out as they are reported when the file is parsed
+func importFileErrors(p *build.Package, err error) []models.FileError {
+	if _, syntax := err.(scanner.ErrorList); syntax {
+		return nil
+	}
+	return fileErrors(filepath.Join(p.Dir, p.InvalidGoFiles[0]), err)
+}
+
+// order the errors of a package by file, line and column
+func sortFileErrors(errors []models.FileError) {
+	sort.SliceStable(errors, func(i, j int) bool {
+		a, b := errors[i], errors[j]
+		if a.FileName != b.FileName {
+			return a.FileName < b.FileName
+		}
+		if a.LineNumber != b.LineNumber {
+			return a.LineNumber < b.LineNumber
+		}
+		return a.Column < b.Column
+	})
+}
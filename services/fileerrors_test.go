@@ -0,0 +1,57 @@
+package services
+
+import (
+	"commentparser/logging"
+	"commentparser/models"
+	"context"
+	"github.com/stretchr/testify/assert"
+	"path/filepath"
+	"testing"
+)
+
+func TestFileErrors_SyntaxError(t *testing.T) {
+
+	compiled, _ := compileRequest(models.CommentParsingRequest{Tokens: []string{"TODO"}})
+	res, binaryOnly, errors := NewScanner("", logging.NewMockLogging()).extractCommentsWithTerms(
+		compiled, packageFile{path: "testdata/broken/broken.go"})
+
+	assert.False(t, binaryOnly)
+	// the comments on both sides of the syntax error are still matched
+	assert.Equal(t, 2, len(res["TODO"]))
+	// the parser reports the error and the ones it causes
+	assert.NotEmpty(t, errors)
+	assert.Equal(t, "testdata/broken/broken.go", errors[0].FileName)
+	assert.Equal(t, 6, errors[0].LineNumber)
+	assert.NotEmpty(t, errors[0].Message)
+}
+
+func TestFileErrors_MissingFile(t *testing.T) {
+
+	compiled, _ := compileRequest(models.CommentParsingRequest{Tokens: []string{"TODO"}})
+	res, _, errors := NewScanner("", logging.NewMockLogging()).extractCommentsWithTerms(
+		compiled, packageFile{path: "testdata/broken/missing.go"})
+
+	assert.Empty(t, res)
+	assert.Equal(t, 1, len(errors))
+	assert.Equal(t, 0, errors[0].LineNumber)
+}
+
+func TestFileErrors_Package(t *testing.T) {
+
+	scanner := NewScanner("", logging.NewMockLogging())
+	res, err := scanner.ExtractRelevantComments(context.Background(), models.CommentParsingRequest{
+		Directory: "testdata/broken",
+		Tokens:    []string{"TODO"},
+	})
+
+	assert.Nil(t, err)
+	assert.Equal(t, "broken", res.PackageName)
+	assert.Equal(t, 4, len(res.Matches["TODO"]))
+	// sorted by file, the syntax errors of broken.go come before the package clause of other.go
+	last := len(res.FileErrors) - 1
+	assert.True(t, last > 0)
+	assert.Equal(t, "broken.go", filepath.Base(res.FileErrors[0].FileName))
+	assert.Equal(t, 6, res.FileErrors[0].LineNumber)
+	assert.Equal(t, "other.go", filepath.Base(res.FileErrors[last].FileName))
+	assert.Contains(t, res.FileErrors[last].Message, "found packages broken (broken.go) and other (other.go)")
+}
@@ -0,0 +1,71 @@
+package services
+
+import (
+	"commentparser/models"
+	"go/build"
+	"path/filepath"
+)
+
+// the file classes in the order their files are scanned
+var fileClassOrder = []string{
+	models.FileClass_GO,
+	models.FileClass_CGO,
+	models.FileClass_TEST,
+	models.FileClass_XTEST,
+	models.FileClass_IGNORED,
+}
+
+// a source file of a package to scan
+type packageFile struct {
+	path  string // the path of the file
+	class string // the class of the file (FileClass_*)
+}
+
+// validate the FileClasses of the request, returning the set of classes to scan. An
+// InvalidRequestError is returned for unknown classes
+func selectFileClasses(request models.CommentParsingRequest) (map[string]bool, error) {
+
+	selected := make(map[string]bool)
+	if len(request.FileClasses) < 1 {
+		selected[models.FileClass_GO] = true
+		return selected, nil
+	}
+
+	known := make(map[string]bool)
+	for _, class := range fileClassOrder {
+		known[class] = true
+	}
+	for _, class := range request.FileClasses {
+		if !known[class] {
+			return nil, invalidRequest("Unknown file class `%s`", class)
+		}
+		selected[class] = true
+	}
+	return selected, nil
+}
+
+// the files of the package belonging to the selected classes, ordered by class then by name
+func packageFiles(p *build.Package, classes map[string]bool) []packageFile {
+
+	filesByClass := map[string][]string{
+		models.FileClass_GO:      p.GoFiles,
+		models.FileClass_CGO:     p.CgoFiles,
+		models.FileClass_TEST:    p.TestGoFiles,
+		models.FileClass_XTEST:   p.XTestGoFiles,
+		models.FileClass_IGNORED: p.IgnoredGoFiles,
+	}
+
+	var files []packageFile
+	for _, class := range fileClassOrder {
+		if !classes[class] {
+			continue
+		}
+		for _, name := range filesByClass[class] {
+			files = append(files, packageFile{
+				path:  filepath.Join(p.Dir, name),
+				class: class,
+			})
+		}
+	}
+	return files
+}
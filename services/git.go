@@ -0,0 +1,59 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// run git in a directory and return its standard output. The standard error of git is the
+// message of the error when it fails
+func runGit(ctx context.Context, dir string, args ...string) ([]byte, error) {
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, "git", args...)
+	cmd.Dir = dir
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		if message := strings.TrimSpace(stderr.String()); len(message) > 0 {
+			return nil, fmt.Errorf("git %s: %s", args[0], message)
+		}
+		return nil, fmt.Errorf("git %s: %v", args[0], err)
+	}
+	return stdout.Bytes(), nil
+}
+
+// check out a revision of a git repository in a new temporary worktree, and return its
+// directory with a function removing the worktree. Revisions that do not name a commit of the
+// repository are invalid requests
+func checkoutWorktree(ctx context.Context, repository string, revision string) (string, func(), error) {
+
+	// a revision starting with "-" would be an option of git
+	if len(revision) < 1 || strings.HasPrefix(revision, "-") {
+		return "", nil, invalidRequest("The revision `%s` is not valid", revision)
+	}
+	if _, err := runGit(ctx, repository, "rev-parse", "--verify", "--quiet", revision+"^{commit}"); err != nil {
+		return "", nil, invalidRequest("The revision `%s` does not exist in the repository", revision)
+	}
+
+	parent, err := ioutil.TempDir("", "commentparser-worktree")
+	if err != nil {
+		return "", nil, err
+	}
+	dir := filepath.Join(parent, "worktree")
+	if _, err := runGit(ctx, repository, "worktree", "add", "--detach", dir, revision); err != nil {
+		os.RemoveAll(parent)
+		return "", nil, err
+	}
+	remove := func() {
+		// the worktree is removed even when the context of the request is done
+		runGit(context.Background(), repository, "worktree", "remove", "--force", dir)
+		os.RemoveAll(parent)
+	}
+	return dir, remove, nil
+}
@@ -0,0 +1,574 @@
+package services
+
+import (
+	"bytes"
+	"commentparser/models"
+	"context"
+	"encoding/gob"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+	"unicode"
+	"unicode/utf8"
+)
+
+// the version of the format of the persisted index, an index persisted by another version is
+// built again
+const indexVersion = 1
+
+// the name of the file the index is persisted to in its directory
+const indexFileName = "index.gob"
+
+// Index keeps the comments of every package matched by a set of patterns, with an inverted index
+// from the trigrams of their text to the comments containing them, so the requests for these
+// packages are answered without walking and parsing their files. The packages are indexed with
+// the default build context and every file class, and the index is updated incrementally: only
+// the files whose modification time or size changed are parsed again. An Index is safe for
+// concurrent use
+type Index struct {
+	Patterns  []string        // the packages indexed, such as "./..." or "net/..."
+	Directory string          // the directory the index is persisted to, empty to only keep it in memory
+	scanner   Scanner         // resolves the patterns and parses the files
+	compiled  compiledRequest // the build context and file classes the packages are indexed with
+
+	updating       sync.Mutex                 // held while the index is updated, so updates do not overlap
+	rebuildPending int32                      // 1 when a rebuild was requested and has not started yet
+	mutex          sync.RWMutex               // guards the fields below
+	packages       map[string]*indexedPackage // the packages indexed by import path
+	status         models.IndexStatus         // the state of the index
+}
+
+// a package of the index, which is never modified once indexed. The fields are exported to be
+// persisted with encoding/gob
+type indexedPackage struct {
+	ImportPath string             // the import path of the package
+	Name       string             // the name of the package
+	Errors     []models.FileError // the errors of the invalid files of the package
+	Files      []indexedFile      // the files of the package, ordered by class then by name
+	Trigrams   map[uint32][]int32 // the comments containing each trigram, numbered across the files in order
+}
+
+// a file of an indexed package
+type indexedFile struct {
+	Path    string     // the path of the file
+	Class   string     // the class of the file (FileClass_*)
+	ModTime time.Time  // the modification time of the file when it was parsed
+	Size    int64      // the size of the file when it was parsed
+	Parsed  parsedFile // the comments of the file
+}
+
+// the content of the file an index is persisted to
+type indexSnapshot struct {
+	Version      int               // indexVersion
+	ParseVersion int               // parseCacheVersion, the format of the parsed files
+	Patterns     []string          // the patterns of the index
+	BuiltAt      time.Time         // when the index was last built from scratch
+	UpdatedAt    time.Time         // when the index was last updated
+	Packages     []*indexedPackage // the packages, ordered by import path
+}
+
+// create a new empty Index of the packages matched by the patterns, which are resolved and
+// parsed by the scanner. See Update and Run to index the packages
+func NewIndex(scanner Scanner, patterns []string, directory string) *Index {
+	// the index cannot answer requests while it is updated
+	scanner.Index = nil
+	compiled, _ := compileRequest(models.CommentParsingRequest{FileClasses: fileClassOrder})
+	return &Index{
+		Patterns:  patterns,
+		Directory: directory,
+		scanner:   scanner,
+		compiled:  compiled,
+		packages:  make(map[string]*indexedPackage),
+		status:    models.IndexStatus{Patterns: patterns},
+	}
+}
+
+// fold the case of a text so a trigram matches regardless of case, like the (?i) flag of
+// regular expressions. The only runes whose case folds to an ASCII letter without being ASCII
+// are the long s and the Kelvin sign
+func foldCase(text string) string {
+	return strings.Map(func(r rune) rune {
+		switch r {
+		case '\u017f': // the long s
+			return 's'
+		case 'K':
+			return 'k'
+		}
+		return unicode.ToLower(r)
+	}, text)
+}
+
+// the trigrams of a text once its case is folded, each packed in an integer
+func trigrams(text string) []uint32 {
+	folded := foldCase(text)
+	var result []uint32
+	for idx := 0; idx+3 <= len(folded); idx++ {
+		result = append(result, uint32(folded[idx])<<16|uint32(folded[idx+1])<<8|uint32(folded[idx+2]))
+	}
+	return result
+}
+
+// the text every comment matched by the token contains, which can be looked up in the index.
+// ok is false for the tokens of the regex and query modes, and for non ASCII tokens whose case
+// may fold differently
+func indexedText(token string, request models.CommentParsingRequest) (string, bool) {
+	switch request.MatchMode {
+	case "", models.MatchMode_LITERAL, models.MatchMode_ANNOTATION:
+	default:
+		return "", false
+	}
+	for idx := 0; idx < len(token); idx++ {
+		if token[idx] >= utf8.RuneSelf {
+			return "", false
+		}
+	}
+	return token, len(token) >= 3
+}
+
+// index the trigrams of the comments of a package
+func (p *indexedPackage) indexTrigrams() {
+	p.Trigrams = make(map[uint32][]int32)
+	number := int32(0)
+	for _, file := range p.Files {
+		for _, comment := range file.Parsed.Comments {
+			seen := make(map[uint32]bool)
+			for _, trigram := range trigrams(comment.Text) {
+				if !seen[trigram] {
+					seen[trigram] = true
+					p.Trigrams[trigram] = append(p.Trigrams[trigram], number)
+				}
+			}
+			number++
+		}
+	}
+}
+
+// the intersection of two sorted lists of comment numbers
+func intersectComments(a, b []int32) []int32 {
+	var result []int32
+	for i, j := 0, 0; i < len(a) && j < len(b); {
+		switch {
+		case a[i] < b[j]:
+			i++
+		case a[i] > b[j]:
+			j++
+		default:
+			result = append(result, a[i])
+			i++
+			j++
+		}
+	}
+	return result
+}
+
+// the numbers of the comments of the package that can be matched by one of the tokens of the
+// request, ok is false when every comment has to be matched
+func (p *indexedPackage) candidates(request models.CommentParsingRequest) (map[int32]bool, bool) {
+	candidates := make(map[int32]bool)
+	for _, token := range request.Tokens {
+		text, ok := indexedText(token, request)
+		if !ok {
+			return nil, false
+		}
+		var comments []int32
+		for idx, trigram := range trigrams(text) {
+			if idx == 0 {
+				comments = p.Trigrams[trigram]
+			} else {
+				comments = intersectComments(comments, p.Trigrams[trigram])
+			}
+		}
+		for _, comment := range comments {
+			candidates[comment] = true
+		}
+	}
+	return candidates, true
+}
+
+// true if the index can answer the request, which is a single package given by import path
+// and selecting files with the build context the packages were indexed with. The index has no
+// history, so it cannot blame the matches
+func (index *Index) answers(compiled compiledRequest) bool {
+	request := compiled.request
+	if len(request.Directory) > 0 || request.AllPlatforms || request.Blame {
+		return false
+	}
+	ctxt, indexed := compiled.ctxt, index.compiled.ctxt
+	return ctxt.GOOS == indexed.GOOS &&
+		ctxt.GOARCH == indexed.GOARCH &&
+		ctxt.CgoEnabled == indexed.CgoEnabled &&
+		strings.Join(ctxt.BuildTags, ",") == strings.Join(indexed.BuildTags, ",")
+}
+
+// the result of the request from the index, like Scanner.ExtractRelevantComments would return
+// with the files of the package as they were when the index was last updated. The files whose
+// modification time or size changed since then are parsed again. found is false when the index
+// cannot answer the request or does not contain the package, index can be nil
+func (index *Index) answer(compiled compiledRequest) (models.CommentParsingResult, bool) {
+
+	if index == nil || !index.answers(compiled) {
+		return models.CommentParsingResult{}, false
+	}
+	index.mutex.RLock()
+	p, found := index.packages[compiled.request.PackageName]
+	index.mutex.RUnlock()
+	if !found {
+		return models.CommentParsingResult{}, false
+	}
+
+	result := models.CommentParsingResult{
+		PackageName: p.Name,
+		ImportPath:  compiled.request.PackageName,
+		Matches:     make(map[string][]models.MatchedComment),
+		FileErrors:  append([]models.FileError(nil), p.Errors...),
+	}
+	candidates, filtered := p.candidates(compiled.request)
+	number := int32(0)
+	for _, file := range p.Files {
+		first := number
+		number += int32(len(file.Parsed.Comments))
+		if !compiled.classes[file.Class] {
+			continue
+		}
+
+		// a file that changed since the last update is parsed again, so its comments match the
+		// lines of its context. The trigrams of the index do not apply to its comments
+		parsed, src, live := file.Parsed, []byte(nil), false
+		if info, err := os.Stat(file.Path); err != nil || !info.ModTime().Equal(file.ModTime) || info.Size() != file.Size {
+			live = true
+			if src, err = ioutil.ReadFile(file.Path); err != nil {
+				parsed = parsedFile{Errors: fileErrors(file.Path, err)}
+			} else {
+				parsed = index.scanner.parseFile(file.Path, src)
+			}
+		}
+		result.FileErrors = append(result.FileErrors, parsed.Errors...)
+		if result.BinaryOnly {
+			continue
+		}
+		if parsed.BinaryOnly {
+			result.Matches = nil
+			result.BinaryOnly = true
+			continue
+		}
+
+		if filtered && !live {
+			parsed.Comments = nil
+			for idx, comment := range file.Parsed.Comments {
+				if candidates[first+int32(idx)] {
+					parsed.Comments = append(parsed.Comments, comment)
+				}
+			}
+		}
+		var lines []string
+		if compiled.request.ContextLines > 0 && len(parsed.Comments) > 0 {
+			if !live {
+				src, _ = ioutil.ReadFile(file.Path)
+			}
+			if src != nil {
+				lines = sourceLines(src)
+			}
+		}
+		for key, val := range matchComments(compiled, packageFile{path: file.Path, class: file.Class}, parsed, lines) {
+			result.Matches[key] = append(result.Matches[key], val...)
+		}
+	}
+	sortMatches(&result, compiled)
+	sortFileErrors(result.FileErrors)
+	return result, true
+}
+
+// the state of the index
+func (index *Index) Status() models.IndexStatus {
+	index.mutex.RLock()
+	defer index.mutex.RUnlock()
+	status := index.status
+	status.Updating = status.Updating || atomic.LoadInt32(&index.rebuildPending) == 1
+	return status
+}
+
+// update the index, parsing the files that changed since the last update and the files of new
+// packages, and persist it to its directory. When rebuild is true every file is parsed again.
+// If the update fails, such as when the context is done, the index is left as it was
+func (index *Index) Update(ctx context.Context, rebuild bool) error {
+	index.updating.Lock()
+	defer index.updating.Unlock()
+	return index.update(ctx, rebuild)
+}
+
+// request a rebuild of the index in the background, which starts once the update in progress,
+// if any, is done. false is returned if a rebuild is already pending
+func (index *Index) Rebuild(ctx context.Context) bool {
+	if !atomic.CompareAndSwapInt32(&index.rebuildPending, 0, 1) {
+		return false
+	}
+	go func() {
+		index.updating.Lock()
+		defer index.updating.Unlock()
+		atomic.StoreInt32(&index.rebuildPending, 0)
+		if err := index.update(ctx, true); err != nil {
+			index.scanner.Logging.Error("Could not rebuild the index: %v", err)
+		}
+	}()
+	return true
+}
+
+// update the index every interval until the context is done, starting right away
+func (index *Index) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if err := index.Update(ctx, false); err != nil {
+			index.scanner.Logging.Error("Could not update the index: %v", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// update the index, the caller must hold index.updating
+func (index *Index) update(ctx context.Context, rebuild bool) error {
+
+	index.mutex.Lock()
+	index.status.Updating = true
+	previous := index.packages
+	index.mutex.Unlock()
+
+	packages, changed, err := index.indexPackages(ctx, previous, rebuild)
+
+	index.mutex.Lock()
+	index.status.Updating = false
+	if err != nil {
+		index.status.LastError = err.Error()
+		index.mutex.Unlock()
+		return err
+	}
+	now := time.Now()
+	index.packages = packages
+	index.status.LastError = ""
+	index.status.UpdatedAt = now
+	if rebuild || len(previous) < 1 {
+		index.status.BuiltAt = now
+	}
+	index.countPackages()
+	snapshot := index.snapshot()
+	index.mutex.Unlock()
+
+	index.scanner.Logging.Info("Updated the index of %v packages in %v", len(packages), time.Since(now))
+	if changed && len(index.Directory) > 0 {
+		return persistIndex(index.Directory, snapshot)
+	}
+	return nil
+}
+
+// index every package matched by the patterns, the packages whose files did not change since
+// the previous index are kept as they are. changed is false if no package changed
+func (index *Index) indexPackages(
+	ctx context.Context,
+	previous map[string]*indexedPackage,
+	rebuild bool) (map[string]*indexedPackage, bool, error) {
+
+	logging := index.scanner.Logging
+	resolver, _, err := index.scanner.resolveRequest(index.compiled, true)
+	if err != nil {
+		return nil, false, err
+	}
+	var importPaths []string
+	found := make(map[string]bool)
+	for _, pattern := range index.Patterns {
+		matched := []string{pattern}
+		if IsPackagePattern(pattern) {
+			if matched, err = resolver.expandPattern(ctx, pattern); err != nil {
+				return nil, false, err
+			}
+		}
+		for _, importPath := range matched {
+			if !found[importPath] {
+				found[importPath] = true
+				importPaths = append(importPaths, importPath)
+			}
+		}
+	}
+
+	indexed := make([]*indexedPackage, len(importPaths))
+	packageChanged := make([]bool, len(importPaths))
+	err = runWorkers(ctx, index.scanner.workers(), len(importPaths), func(idx int) {
+		old := previous[importPaths[idx]]
+		if rebuild {
+			old = nil
+		}
+		indexed[idx], packageChanged[idx] = index.indexPackage(resolver, importPaths[idx], old)
+	})
+	if err != nil {
+		return nil, false, err
+	}
+
+	packages := make(map[string]*indexedPackage)
+	changed := len(previous) != len(importPaths)
+	for idx, p := range indexed {
+		if p == nil {
+			logging.Debug("The package %s is not indexed", importPaths[idx])
+			continue
+		}
+		packages[p.ImportPath] = p
+		changed = changed || packageChanged[idx]
+	}
+	return packages, changed, nil
+}
+
+// index a single package, keeping the comments of the files of the previous index of the
+// package that did not change. nil is returned for commands and packages that cannot be imported
+func (index *Index) indexPackage(
+	resolver packageResolver,
+	importPath string,
+	previous *indexedPackage) (*indexedPackage, bool) {
+
+	logging := index.scanner.Logging
+	p, err := resolver.importPkg(importPath, logging)
+	if p == nil {
+		if err != nil {
+			logging.Warning("Could not index the package %s: %v", importPath, err)
+		}
+		return nil, false
+	}
+	indexed := &indexedPackage{ImportPath: importPath, Name: p.Name}
+	if err != nil {
+		indexed.Errors = importFileErrors(p, err)
+	}
+
+	previousFiles := make(map[string]indexedFile)
+	if previous != nil {
+		for _, file := range previous.Files {
+			previousFiles[file.Path] = file
+		}
+	}
+	files := packageFiles(p, index.compiled.classes)
+	changed := previous == nil || len(files) != len(previous.Files) || p.Name != previous.Name
+	for idx, file := range files {
+		info, statErr := os.Stat(file.path)
+		if old, found := previousFiles[file.path]; found && statErr == nil &&
+			old.ModTime.Equal(info.ModTime()) && old.Size == info.Size() {
+			indexed.Files = append(indexed.Files, old)
+			changed = changed || previous.Files[idx].Path != file.path
+			continue
+		}
+
+		changed = true
+		indexedFile := indexedFile{Path: file.path, Class: file.class}
+		if statErr == nil {
+			indexedFile.ModTime, indexedFile.Size = info.ModTime(), info.Size()
+		}
+		src, err := ioutil.ReadFile(file.path)
+		if err != nil {
+			indexedFile.Parsed = parsedFile{Errors: fileErrors(file.path, err)}
+		} else {
+			indexedFile.Parsed = index.scanner.parseFile(file.path, src)
+		}
+		indexed.Files = append(indexed.Files, indexedFile)
+	}
+	if !changed {
+		return previous, false
+	}
+	indexed.indexTrigrams()
+	return indexed, true
+}
+
+// count the packages, files and comments of the index in its status, the caller must hold
+// index.mutex
+func (index *Index) countPackages() {
+	index.status.Packages, index.status.Files, index.status.Comments = len(index.packages), 0, 0
+	for _, p := range index.packages {
+		index.status.Files += len(p.Files)
+		for _, file := range p.Files {
+			index.status.Comments += len(file.Parsed.Comments)
+		}
+	}
+}
+
+// the content of the file the index is persisted to, the caller must hold index.mutex
+func (index *Index) snapshot() indexSnapshot {
+	snapshot := indexSnapshot{
+		Version:      indexVersion,
+		ParseVersion: parseCacheVersion,
+		Patterns:     index.Patterns,
+		BuiltAt:      index.status.BuiltAt,
+		UpdatedAt:    index.status.UpdatedAt,
+	}
+	for _, p := range index.packages {
+		snapshot.Packages = append(snapshot.Packages, p)
+	}
+	sort.Slice(snapshot.Packages, func(i, j int) bool {
+		return snapshot.Packages[i].ImportPath < snapshot.Packages[j].ImportPath
+	})
+	return snapshot
+}
+
+// write the index to its directory, the file is renamed once written so a crash never leaves
+// a partial index
+func persistIndex(dir string, snapshot indexSnapshot) error {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	var data bytes.Buffer
+	if err := gob.NewEncoder(&data).Encode(snapshot); err != nil {
+		return err
+	}
+	tmp, err := ioutil.TempFile(dir, indexFileName+".*.tmp")
+	if err != nil {
+		return err
+	}
+	_, err = tmp.Write(data.Bytes())
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), filepath.Join(dir, indexFileName))
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+	}
+	return err
+}
+
+// load the index persisted to its directory, so requests are answered before the first update
+// is done. An index persisted for other patterns or by another version is ignored
+func (index *Index) Load() error {
+
+	if len(index.Directory) < 1 {
+		return nil
+	}
+	data, err := ioutil.ReadFile(filepath.Join(index.Directory, indexFileName))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var snapshot indexSnapshot
+	if err := gob.NewDecoder(bytes.NewReader(data)).Decode(&snapshot); err != nil {
+		return err
+	}
+	if snapshot.Version != indexVersion || snapshot.ParseVersion != parseCacheVersion ||
+		strings.Join(snapshot.Patterns, "\x00") != strings.Join(index.Patterns, "\x00") {
+		index.scanner.Logging.Info("Ignoring the index persisted in %s for other patterns or versions", index.Directory)
+		return nil
+	}
+
+	index.mutex.Lock()
+	defer index.mutex.Unlock()
+	index.packages = make(map[string]*indexedPackage)
+	for _, p := range snapshot.Packages {
+		index.packages[p.ImportPath] = p
+	}
+	index.countPackages()
+	index.status.BuiltAt, index.status.UpdatedAt = snapshot.BuiltAt, snapshot.UpdatedAt
+	return nil
+}
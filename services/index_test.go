@@ -0,0 +1,154 @@
+package services
+
+import (
+	"commentparser/logging"
+	"commentparser/models"
+	"context"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// copy the sample package into a new module, returning the root of the module
+func indexFixture(t *testing.T) string {
+	root, _ := ioutil.TempDir("", "index")
+	t.Cleanup(func() { os.RemoveAll(root) })
+	ioutil.WriteFile(filepath.Join(root, "go.mod"), []byte("module example.com/indexed\n\ngo 1.22\n"), 0644)
+	os.Mkdir(filepath.Join(root, "sample"), 0755)
+	names, _ := filepath.Glob("testdata/sample/*.go")
+	for _, name := range names {
+		src, _ := ioutil.ReadFile(name)
+		ioutil.WriteFile(filepath.Join(root, "sample", filepath.Base(name)), src, 0644)
+	}
+	return root
+}
+
+func TestIndex_SameResultsAsScanning(t *testing.T) {
+
+	root := indexFixture(t)
+	scanner := NewScanner(root, logging.NewMockLogging())
+	index := NewIndex(scanner, []string{"./..."}, "")
+	assert.Nil(t, index.Update(context.Background(), false))
+	assert.Equal(t, 1, index.Status().Packages)
+	indexed := scanner
+	indexed.Index = index
+
+	for _, req := range []models.CommentParsingRequest{
+		{Tokens: []string{"TODO", "FIXME"}},
+		{Tokens: []string{"todo"}, IgnoreCase: true, FileClasses: []string{models.FileClass_GO, models.FileClass_TEST}},
+		{Tokens: []string{`TODO\(\w+\)`}, MatchMode: models.MatchMode_REGEX, ContextLines: 2},
+		{Tokens: []string{"TODO"}, MatchMode: models.MatchMode_ANNOTATION, SortBy: []string{"line"}},
+		{Tokens: []string{"to"}},
+		{Tokens: []string{"missing"}},
+	} {
+		req.PackageName = "example.com/indexed/sample"
+		compiled, _ := compileRequest(req)
+		expected, err := scanner.ExtractRelevantComments(context.Background(), req)
+		assert.Nil(t, err)
+		res, found := index.answer(compiled)
+		assert.True(t, found)
+		assert.Equal(t, expected, res, req.Tokens[0])
+		res, err = indexed.ExtractRelevantComments(context.Background(), req)
+		assert.Nil(t, err)
+		assert.Equal(t, expected, res, req.Tokens[0])
+	}
+
+	// requests the index cannot answer are scanned
+	compiled, _ := compileRequest(models.CommentParsingRequest{
+		PackageName: "example.com/indexed/sample",
+		Tokens:      []string{"TODO"},
+		BuildTags:   []string{"integration"},
+	})
+	_, found := index.answer(compiled)
+	assert.False(t, found)
+}
+
+func TestIndex_IncrementalUpdate(t *testing.T) {
+
+	root := indexFixture(t)
+	dir := filepath.Join(root, "index")
+	scanner := NewScanner(root, logging.NewMockLogging())
+	scanner.CacheStats = &CacheStats{}
+	scanner.Cache, _ = NewParseCache(0, "")
+	index := NewIndex(scanner, []string{"./..."}, dir)
+	assert.Nil(t, index.Update(context.Background(), false))
+	parsed := scanner.CacheStats.Snapshot().Misses
+
+	// only the changed file is parsed again
+	fileName := filepath.Join(root, "sample", "sample.go")
+	src, _ := ioutil.ReadFile(fileName)
+	ioutil.WriteFile(fileName, append(src, []byte("\n// TODO: added after indexing\n")...), 0644)
+	os.Chtimes(fileName, time.Now(), time.Now().Add(time.Second))
+	assert.Nil(t, index.Update(context.Background(), false))
+	assert.Equal(t, parsed+1, scanner.CacheStats.Snapshot().Misses)
+
+	req := models.CommentParsingRequest{PackageName: "example.com/indexed/sample", Tokens: []string{"added after"}}
+	compiled, _ := compileRequest(req)
+	res, found := index.answer(compiled)
+	assert.True(t, found)
+	assert.Equal(t, 1, len(res.Matches["added after"]))
+
+	// the persisted index answers before its first update
+	loaded := NewIndex(scanner, []string{"./..."}, dir)
+	assert.Nil(t, loaded.Load())
+	assert.Equal(t, index.Status().Comments, loaded.Status().Comments)
+	loadedRes, found := loaded.answer(compiled)
+	assert.True(t, found)
+	assert.Equal(t, res, loadedRes)
+
+	// an index persisted for other patterns is ignored
+	other := NewIndex(scanner, []string{"./sample"}, dir)
+	assert.Nil(t, other.Load())
+	assert.Equal(t, 0, other.Status().Packages)
+}
+
+func TestIndex_ChangedFiles(t *testing.T) {
+
+	root := indexFixture(t)
+	scanner := NewScanner(root, logging.NewMockLogging())
+	index := NewIndex(scanner, []string{"./..."}, "")
+	assert.Nil(t, index.Update(context.Background(), false))
+
+	// a file changed since the last update is scanned again instead of answered from the index
+	fileName := filepath.Join(root, "sample", "sample.go")
+	src, _ := ioutil.ReadFile(fileName)
+	ioutil.WriteFile(fileName, append([]byte("// TODO: added before the package clause\n"), src...), 0644)
+	os.Chtimes(fileName, time.Now(), time.Now().Add(time.Second))
+
+	req := models.CommentParsingRequest{
+		PackageName:  "example.com/indexed/sample",
+		Tokens:       []string{"TODO"},
+		ContextLines: 1,
+	}
+	compiled, _ := compileRequest(req)
+	expected, err := scanner.ExtractRelevantComments(context.Background(), req)
+	assert.Nil(t, err)
+	res, found := index.answer(compiled)
+	assert.True(t, found)
+	assert.Equal(t, expected, res)
+	assert.Equal(t, 1, res.Matches["TODO"][0].LineNumber)
+}
+
+func TestIndex_Trigrams(t *testing.T) {
+
+	p := &indexedPackage{Files: []indexedFile{{Parsed: parsedFile{Comments: []parsedComment{
+		{Text: "TODO: the first comment"},
+		{Text: "no match here"},
+		{Text: "a todo in lower case, and the Kelvin sign K"},
+	}}}}}
+	p.indexTrigrams()
+
+	candidates, ok := p.candidates(models.CommentParsingRequest{Tokens: []string{"TODO"}})
+	assert.True(t, ok)
+	assert.Equal(t, map[int32]bool{0: true, 2: true}, candidates)
+	candidates, _ = p.candidates(models.CommentParsingRequest{Tokens: []string{"sign k"}})
+	assert.Equal(t, map[int32]bool{2: true}, candidates)
+
+	_, ok = p.candidates(models.CommentParsingRequest{Tokens: []string{"TODO"}, MatchMode: models.MatchMode_REGEX})
+	assert.False(t, ok)
+	_, ok = p.candidates(models.CommentParsingRequest{Tokens: []string{"TODO", "to"}})
+	assert.False(t, ok, "tokens shorter than a trigram match every comment")
+}
@@ -0,0 +1,146 @@
+package services
+
+import (
+	"commentparser/models"
+	"fmt"
+	"regexp"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// an error caused by the content of a request, such as a token that is not a valid
+// regular expression. The message does not contain sensitive information and can be
+// displayed to the caller
+type InvalidRequestError struct {
+	message string // the message describing what is wrong with the request
+}
+
+// create a new InvalidRequestError with a formatted message
+func invalidRequest(message string, vars ...interface{}) InvalidRequestError {
+	return InvalidRequestError{
+		message: fmt.Sprintf(message, vars...),
+	}
+}
+
+// the message describing what is wrong with the request
+func (err InvalidRequestError) Error() string {
+	return err.message
+}
+
+// finds a single token within the text of a comment
+type tokenMatcher interface {
+	// find the first match of the token in text, returning the matched text and its
+	// byte offset within text. ok is false when there is no match
+	match(text string) (matched string, offset int, ok bool)
+}
+
+// matches a token as a literal substring
+type literalMatcher struct {
+	token string // the substring to look for
+}
+
+// find the first occurrence of the token in text
+func (m literalMatcher) match(text string) (string, int, bool) {
+	offset := strings.Index(text, m.token)
+	if offset < 0 {
+		return "", 0, false
+	}
+	return m.token, offset, true
+}
+
+// matches a token compiled as a regular expression
+type regexMatcher struct {
+	expression *regexp.Regexp // the compiled token
+	wholeWord  bool           // if true, only matches that are not part of a larger word are accepted
+}
+
+// find the leftmost match of the expression in text
+func (m regexMatcher) match(text string) (string, int, bool) {
+	if !m.wholeWord {
+		loc := m.expression.FindStringIndex(text)
+		if loc == nil {
+			return "", 0, false
+		}
+		return text[loc[0]:loc[1]], loc[0], true
+	}
+
+	for _, loc := range m.expression.FindAllStringIndex(text, -1) {
+		if isWordBoundary(text, loc[0], loc[1]) {
+			return text[loc[0]:loc[1]], loc[0], true
+		}
+	}
+	return "", 0, false
+}
+
+// true if r is part of a word, which is any Unicode letter, digit, combining mark or underscore
+func isWordRune(r rune) bool {
+	return r == '_' || unicode.IsLetter(r) || unicode.IsDigit(r) || unicode.IsMark(r)
+}
+
+// true if the text between start and end is neither preceded nor followed by a word rune,
+// which are the same semantics as `grep -w`
+func isWordBoundary(text string, start, end int) bool {
+	if start > 0 {
+		if r, _ := utf8.DecodeLastRuneInString(text[:start]); isWordRune(r) {
+			return false
+		}
+	}
+	if end < len(text) {
+		if r, _ := utf8.DecodeRuneInString(text[end:]); isWordRune(r) {
+			return false
+		}
+	}
+	return true
+}
+
+// compile every token of the request according to its MatchMode, IgnoreCase and WholeWord
+// options, the matchers are returned in the same order as request.Tokens. An
+// InvalidRequestError is returned if the mode is unknown or if a token cannot be compiled
+func compileMatchers(request models.CommentParsingRequest) ([]tokenMatcher, error) {
+
+	matchers := make([]tokenMatcher, len(request.Tokens))
+	for idx, token := range request.Tokens {
+		matcher, err := compileMatcher(token, request)
+		if err != nil {
+			return nil, err
+		}
+		matchers[idx] = matcher
+	}
+	return matchers, nil
+}
+
+// compile a single token according to the options of the request
+func compileMatcher(token string, request models.CommentParsingRequest) (tokenMatcher, error) {
+
+	var pattern string
+	switch request.MatchMode {
+	case "", models.MatchMode_LITERAL:
+		if !request.IgnoreCase && !request.WholeWord {
+			// the plain substring search does not need the regexp engine
+			return literalMatcher{token: token}, nil
+		}
+		pattern = regexp.QuoteMeta(token)
+	case models.MatchMode_REGEX:
+		pattern = token
+	case models.MatchMode_QUERY:
+		return compileQuery(token, request)
+	case models.MatchMode_ANNOTATION:
+		return compileAnnotation(token, request)
+	default:
+		return nil, invalidRequest("Unknown match mode `%s`", request.MatchMode)
+	}
+
+	if request.IgnoreCase {
+		// (?i) folds case with the Unicode simple case folding rules
+		pattern = "(?i)" + pattern
+	}
+	expression, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, invalidRequest("The token `%s` is not a valid regular expression: %v", token, err)
+	}
+	return regexMatcher{
+		expression: expression,
+		wholeWord:  request.WholeWord,
+	}, nil
+}
@@ -0,0 +1,64 @@
+package services
+
+import (
+	"commentparser/models"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestMatchers_IgnoreCase(t *testing.T) {
+
+	matchers, err := compileMatchers(models.CommentParsingRequest{
+		Tokens:     []string{"todo", "straße"},
+		IgnoreCase: true,
+	})
+	assert.Nil(t, err)
+
+	matched, offset, ok := matchers[0].match("see the TODO below")
+	assert.True(t, ok)
+	assert.Equal(t, "TODO", matched)
+	assert.Equal(t, 8, offset)
+
+	matched, _, ok = matchers[1].match("HAUPTSTRASSE and STRAẞE")
+	assert.True(t, ok)
+	assert.Equal(t, "STRAẞE", matched)
+}
+
+func TestMatchers_WholeWord(t *testing.T) {
+
+	matchers, _ := compileMatchers(models.CommentParsingRequest{
+		Tokens:    []string{"os", "#12"},
+		WholeWord: true,
+	})
+
+	_, _, ok := matchers[0].match("for all purposes")
+	assert.False(t, ok)
+
+	matched, offset, ok := matchers[0].match("purposes of the os package")
+	assert.True(t, ok)
+	assert.Equal(t, "os", matched)
+	assert.Equal(t, 16, offset)
+
+	_, _, ok = matchers[0].match("the éos word")
+	assert.False(t, ok)
+
+	_, _, ok = matchers[1].match("see #123")
+	assert.False(t, ok)
+	_, _, ok = matchers[1].match("see #12.")
+	assert.True(t, ok)
+}
+
+func TestMatchers_RegexWholeWordIgnoreCase(t *testing.T) {
+
+	matchers, err := compileMatchers(models.CommentParsingRequest{
+		Tokens:     []string{`fix(me)?`},
+		MatchMode:  models.MatchMode_REGEX,
+		IgnoreCase: true,
+		WholeWord:  true,
+	})
+	assert.Nil(t, err)
+
+	matched, _, ok := matchers[0].match("prefix FIXME: later")
+	assert.True(t, ok)
+	assert.Equal(t, "FIXME", matched)
+}
@@ -0,0 +1,374 @@
+package services
+
+import (
+	"commentparser/logging"
+	"fmt"
+	"go/build"
+	"golang.org/x/mod/modfile"
+	"golang.org/x/mod/module"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// the information of a go.mod file required to locate the packages of a module and of its dependencies
+type moduleInfo struct {
+	root            string                               // the directory containing the go.mod file
+	modulePath      string                               // the module path
+	requires        map[string]string                    // the version required for each module path
+	replaces        map[string]module.Version            // the replacement of each module path, Version is empty for local directories
+	versionReplaces map[string]map[string]module.Version // the replacements of a single version, by module path then version
+	vendored        bool                                 // true if the dependencies are vendored in root/vendor
+}
+
+// walk up from dir to find the directory containing a go.mod file
+func findModuleRoot(dir string) (string, bool) {
+	dir = filepath.Clean(dir)
+	for {
+		if info, err := os.Stat(filepath.Join(dir, "go.mod")); err == nil && !info.IsDir() {
+			return dir, true
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return "", false
+		}
+		dir = parent
+	}
+}
+
+// read the go.mod file in root
+func loadModule(root string) (*moduleInfo, error) {
+
+	goModPath := filepath.Join(root, "go.mod")
+	data, err := ioutil.ReadFile(goModPath)
+	if err != nil {
+		return nil, err
+	}
+	file, err := modfile.Parse(goModPath, data, nil)
+	if err != nil {
+		return nil, err
+	}
+	if file.Module == nil {
+		return nil, fmt.Errorf("%s does not declare a module path", goModPath)
+	}
+
+	info := &moduleInfo{
+		root:            root,
+		modulePath:      file.Module.Mod.Path,
+		requires:        make(map[string]string),
+		replaces:        make(map[string]module.Version),
+		versionReplaces: make(map[string]map[string]module.Version),
+	}
+	for _, require := range file.Require {
+		info.requires[require.Mod.Path] = require.Mod.Version
+	}
+	for _, replace := range file.Replace {
+		if len(replace.Old.Version) > 0 {
+			// a replacement of a single version of the module, which takes precedence
+			// over the replacement of every version
+			if info.versionReplaces[replace.Old.Path] == nil {
+				info.versionReplaces[replace.Old.Path] = make(map[string]module.Version)
+			}
+			info.versionReplaces[replace.Old.Path][replace.Old.Version] = replace.New
+			continue
+		}
+		info.replaces[replace.Old.Path] = replace.New
+	}
+
+	// like the go command, vendor/modules.txt enables vendoring
+	if _, err := os.Stat(filepath.Join(root, "vendor", "modules.txt")); err == nil {
+		info.vendored = true
+	}
+	return info, nil
+}
+
+// the directory of the module cache, $GOMODCACHE or the first GOPATH entry followed by pkg/mod
+func moduleCacheDir(ctxt build.Context) string {
+	if cache := os.Getenv("GOMODCACHE"); len(cache) > 0 {
+		return cache
+	}
+	gopaths := filepath.SplitList(ctxt.GOPATH)
+	if len(gopaths) < 1 {
+		return ""
+	}
+	return filepath.Join(gopaths[0], "pkg", "mod")
+}
+
+// true if the import path belongs to the standard library, which is the case when its first
+// element does not contain a dot
+func isStandardImportPath(importPath string) bool {
+	first := importPath
+	if idx := strings.Index(importPath, "/"); idx >= 0 {
+		first = importPath[:idx]
+	}
+	return !strings.Contains(first, ".")
+}
+
+// true if importPath is modulePath or one of its sub packages
+func hasPathPrefix(importPath, modulePath string) bool {
+	return importPath == modulePath || strings.HasPrefix(importPath, modulePath+"/")
+}
+
+// the module that provides the import path, which is the required module with the longest
+// matching path. ok is false if no required module provides it
+func (info *moduleInfo) providingModule(importPath string) (modulePath string, ok bool) {
+	for required := range info.requires {
+		if hasPathPrefix(importPath, required) && len(required) > len(modulePath) {
+			modulePath = required
+			ok = true
+		}
+	}
+	return modulePath, ok
+}
+
+// the directory containing the sources of a required module, honoring the replace
+// directives, vendoring and the module cache
+func (info *moduleInfo) moduleDir(modulePath string, ctxt build.Context) (string, error) {
+
+	if info.vendored {
+		// in vendor mode, replaced modules are vendored as well
+		return filepath.Join(info.root, "vendor", filepath.FromSlash(modulePath)), nil
+	}
+
+	version := info.requires[modulePath]
+	replacement, found := info.versionReplaces[modulePath][version]
+	if !found {
+		replacement, found = info.replaces[modulePath]
+	}
+	if found {
+		if modfile.IsDirectoryPath(replacement.Path) {
+			if filepath.IsAbs(replacement.Path) {
+				return replacement.Path, nil
+			}
+			return filepath.Join(info.root, replacement.Path), nil
+		}
+		modulePath, version = replacement.Path, replacement.Version
+	}
+
+	escapedPath, err := module.EscapePath(modulePath)
+	if err != nil {
+		return "", err
+	}
+	escapedVersion, err := module.EscapeVersion(version)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(moduleCacheDir(ctxt), filepath.FromSlash(escapedPath)+"@"+escapedVersion), nil
+}
+
+// locates the sources of packages, either in the module containing the working directory or,
+// when there is no such module, in GOROOT and GOPATH like build.Import does
+type packageResolver struct {
+	ctxt         build.Context // the build context used to import packages
+	allPlatforms bool          // if true, packages are imported for every known platform and their files merged
+	workingDir   string        // the directory relative import paths and patterns are resolved from
+	module       *moduleInfo   // the module containing workingDir, nil in GOPATH mode
+}
+
+// create a resolver for the given working directory, the process working directory is used if empty
+func newPackageResolver(workingDir string) (packageResolver, error) {
+
+	resolver := packageResolver{ctxt: build.Default}
+	if len(workingDir) < 1 {
+		dir, err := os.Getwd()
+		if err != nil {
+			return resolver, err
+		}
+		workingDir = dir
+	}
+	workingDir, err := filepath.Abs(workingDir)
+	if err != nil {
+		return resolver, err
+	}
+	resolver.workingDir = workingDir
+
+	if root, found := findModuleRoot(workingDir); found {
+		resolver.module, err = loadModule(root)
+		if err != nil {
+			return resolver, err
+		}
+	}
+	return resolver, nil
+}
+
+// the directory that contains the package with the import path in module mode
+func (r packageResolver) packageDir(importPath string) (string, error) {
+
+	if isLocalPattern(importPath) {
+		return filepath.Join(r.workingDir, filepath.FromSlash(importPath)), nil
+	}
+	if hasPathPrefix(importPath, r.module.modulePath) {
+		rel := strings.TrimPrefix(strings.TrimPrefix(importPath, r.module.modulePath), "/")
+		return filepath.Join(r.module.root, filepath.FromSlash(rel)), nil
+	}
+	if isStandardImportPath(importPath) {
+		return filepath.Join(r.ctxt.GOROOT, "src", filepath.FromSlash(importPath)), nil
+	}
+	if r.module.vendored {
+		return filepath.Join(r.module.root, "vendor", filepath.FromSlash(importPath)), nil
+	}
+
+	modulePath, found := r.module.providingModule(importPath)
+	if !found {
+		return "", fmt.Errorf(
+			"cannot find package %q: no required module in %s provides it",
+			importPath, filepath.Join(r.module.root, "go.mod"))
+	}
+	dir, err := r.module.moduleDir(modulePath, r.ctxt)
+	if err != nil {
+		return "", err
+	}
+	rel := strings.TrimPrefix(strings.TrimPrefix(importPath, modulePath), "/")
+	return filepath.Join(dir, filepath.FromSlash(rel)), nil
+}
+
+// the import path of the package in dir when in module mode, dir must be inside the main module
+func (r packageResolver) moduleImportPath(dir string) string {
+	rel, err := filepath.Rel(r.module.root, dir)
+	if err != nil || rel == "." {
+		return r.module.modulePath
+	}
+	return path.Join(r.module.modulePath, filepath.ToSlash(rel))
+}
+
+// the build contexts packages are imported with, one per known platform in all platforms mode
+func (r packageResolver) contexts() []build.Context {
+	if r.allPlatforms {
+		return platformContexts(r.ctxt)
+	}
+	return []build.Context{r.ctxt}
+}
+
+// true if dir contains a Go package for any of the build contexts of the resolver
+func (r packageResolver) isPackageDir(dir string) bool {
+	for _, ctxt := range r.contexts() {
+		if _, err := ctxt.ImportDir(dir, 0); err != nil {
+			if _, noGo := err.(*build.NoGoError); noGo {
+				continue
+			}
+		}
+		return true
+	}
+	return false
+}
+
+// import a package with a single build context
+func (r packageResolver) importWith(ctxt build.Context, importPath string) (*build.Package, error) {
+
+	if r.module == nil {
+		return ctxt.Import(importPath, r.workingDir, build.ImportComment)
+	}
+	dir, err := r.packageDir(importPath)
+	if err != nil {
+		return nil, err
+	}
+	if _, statErr := os.Stat(dir); statErr != nil {
+		// the same message as build.Import in GOPATH mode
+		return nil, fmt.Errorf("cannot find package %q in any of:\n\t%s", importPath, dir)
+	}
+	p, err := ctxt.ImportDir(dir, build.ImportComment)
+	if p != nil {
+		p.ImportPath = importPath
+	}
+	return p, err
+}
+
+// Import a package and return it if it is valid (not binary or a command). In all platforms
+// mode the files of the package are merged across the platforms it can be built for. Like
+// build.Import, a package with invalid files is returned along with the error of its first
+// invalid file, see hasInvalidFiles
+func (r packageResolver) importPkg(importPath string, logging logging.Logging) (*build.Package, error) {
+
+	var packages []*build.Package
+	var err, invalidErr error
+	var invalidFiles []string
+	for _, ctxt := range r.contexts() {
+		p, importErr := r.importWith(ctxt, importPath)
+		if _, noGo := importErr.(*build.NoGoError); noGo && r.allPlatforms {
+			err = importErr
+			continue // the package has no files for this platform
+		}
+		if hasInvalidFiles(p, importErr) {
+			if invalidErr == nil {
+				invalidErr, invalidFiles = importErr, p.InvalidGoFiles
+			}
+		} else if importErr != nil {
+			return nil, importErr
+		}
+		packages = append(packages, p)
+	}
+	if len(packages) < 1 {
+		return nil, err
+	}
+	p := packages[0]
+	if r.allPlatforms {
+		p = mergePlatformPackages(packages)
+	}
+	if invalidErr != nil {
+		// the invalid files matching the error, whichever platform it was found for
+		p.InvalidGoFiles = invalidFiles
+	}
+
+	// we can tell if the package is binary only alongside the rest of the
+	// comment parsing
+
+	if p.IsCommand() {
+		logging.Debug("The package %s is a command", p.Name)
+		return nil, nil
+	}
+
+	return p, invalidErr
+}
+
+// a directory tree to walk when expanding a pattern, with the import path of its root
+type patternRoot struct {
+	dir        string // the root directory
+	importPath string // the import path of the package in dir
+}
+
+// the directory trees that can contain packages below the import path base in module mode
+func (r packageResolver) modulePatternRoots(base string) []patternRoot {
+
+	var roots []patternRoot
+	// the main module and the modules it requires contain the packages below base if base
+	// is inside the module, or if the module is inside base
+	modulePaths := []string{r.module.modulePath}
+	for required := range r.module.requires {
+		modulePaths = append(modulePaths, required)
+	}
+	sort.Strings(modulePaths)
+
+	for _, modulePath := range modulePaths {
+		var moduleRoot string
+		if modulePath == r.module.modulePath {
+			moduleRoot = r.module.root
+		} else {
+			dir, err := r.module.moduleDir(modulePath, r.ctxt)
+			if err != nil {
+				continue
+			}
+			moduleRoot = dir
+		}
+		switch {
+		case len(base) < 1 || hasPathPrefix(modulePath, base):
+			roots = append(roots, patternRoot{dir: moduleRoot, importPath: modulePath})
+		case hasPathPrefix(base, modulePath):
+			rel := strings.TrimPrefix(strings.TrimPrefix(base, modulePath), "/")
+			roots = append(roots, patternRoot{
+				dir:        filepath.Join(moduleRoot, filepath.FromSlash(rel)),
+				importPath: base,
+			})
+		}
+	}
+
+	if len(base) < 1 || isStandardImportPath(base) {
+		roots = append(roots, patternRoot{
+			dir:        filepath.Join(r.ctxt.GOROOT, "src", filepath.FromSlash(base)),
+			importPath: base,
+		})
+	}
+	return roots
+}
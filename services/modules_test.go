@@ -0,0 +1,103 @@
+package services
+
+import (
+	"commentparser/logging"
+	"commentparser/models"
+	"context"
+	"github.com/stretchr/testify/assert"
+	"go/build"
+	"golang.org/x/mod/module"
+	"path/filepath"
+	"testing"
+)
+
+func TestModules_PackageDir(t *testing.T) {
+
+	t.Setenv("GOMODCACHE", filepath.Join("testdata", "modcache"))
+	resolver, err := newPackageResolver("testdata/module")
+	assert.Nil(t, err)
+	root, _ := filepath.Abs("testdata")
+
+	for importPath, dir := range map[string]string{
+		"example.com/fixture/greeter": filepath.Join(root, "module", "greeter"),
+		"./greeter":                   filepath.Join(root, "module", "greeter"),
+		"example.com/dep":             filepath.Join("testdata", "modcache", "example.com", "dep@v1.2.0"),
+		"example.com/Upper/sub":       filepath.Join("testdata", "modcache", "example.com", "!upper@v0.1.0", "sub"),
+		"example.com/local":           filepath.Join(root, "replaced"),
+		"net/http":                    filepath.Join(resolver.ctxt.GOROOT, "src", "net", "http"),
+	} {
+		resolved, err := resolver.packageDir(importPath)
+		assert.Nil(t, err)
+		assert.Equal(t, dir, resolved, importPath)
+	}
+
+	_, err = resolver.packageDir("example.com/missing")
+	assert.Contains(t, err.Error(), "no required module")
+}
+
+func TestModules_VersionReplaces(t *testing.T) {
+
+	t.Setenv("GOMODCACHE", filepath.Join("testdata", "modcache"))
+	root, _ := filepath.Abs(filepath.Join("testdata", "module"))
+	absolute, _ := filepath.Abs(filepath.Join("testdata", "replaced"))
+	info := &moduleInfo{
+		root: root,
+		requires: map[string]string{
+			"example.com/relative": "v1.0.0",
+			"example.com/absolute": "v1.0.0",
+			"example.com/renamed":  "v1.0.0",
+			"example.com/other":    "v2.0.0",
+		},
+		replaces: map[string]module.Version{},
+		versionReplaces: map[string]map[string]module.Version{
+			"example.com/relative": {"v1.0.0": {Path: "../replaced"}},
+			"example.com/absolute": {"v1.0.0": {Path: absolute}},
+			"example.com/renamed":  {"v1.0.0": {Path: "example.com/dep", Version: "v1.2.0"}},
+			"example.com/other":    {"v1.0.0": {Path: "../replaced"}},
+		},
+	}
+
+	for modulePath, dir := range map[string]string{
+		"example.com/relative": absolute,
+		"example.com/absolute": absolute,
+		"example.com/renamed":  filepath.Join("testdata", "modcache", "example.com", "dep@v1.2.0"),
+		"example.com/other":    filepath.Join("testdata", "modcache", "example.com", "other@v2.0.0"),
+	} {
+		resolved, err := info.moduleDir(modulePath, build.Default)
+		assert.Nil(t, err)
+		assert.Equal(t, dir, resolved, modulePath)
+	}
+}
+
+func TestModules_ExtractFromDependencies(t *testing.T) {
+
+	t.Setenv("GOMODCACHE", filepath.Join("testdata", "modcache"))
+	scanner := NewScanner("testdata/module", logging.NewMockLogging())
+
+	for importPath, content := range map[string]string{
+		"example.com/dep":             "TODO: found in the module cache\n",
+		"example.com/local":           "TODO: found through a replace directive\n",
+		"example.com/fixture/greeter": "TODO(bob): greet in more languages\n",
+	} {
+		res, err := scanner.ExtractRelevantComments(context.Background(), models.CommentParsingRequest{
+			PackageName: importPath,
+			Tokens:      []string{"TODO"},
+		})
+		assert.Nil(t, err)
+		assert.Equal(t, importPath, res.ImportPath)
+		assert.Equal(t, 1, len(res.Matches["TODO"]), importPath)
+		assert.Equal(t, content, res.Matches["TODO"][0].LineContent)
+	}
+}
+
+func TestModules_PatternSkipsCommands(t *testing.T) {
+
+	scanner := NewScanner("testdata/module", logging.NewMockLogging())
+	res, err := scanner.ExtractRelevantCommentsForPattern(context.Background(), models.CommentParsingRequest{
+		PackageName: "./...",
+		Tokens:      []string{"TODO"},
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(res.Packages))
+	assert.Equal(t, "example.com/fixture/greeter", res.Packages[0].ImportPath)
+}
@@ -0,0 +1,144 @@
+package services
+
+import (
+	"commentparser/models"
+	"sort"
+)
+
+// the keys matches can be sorted by, in the SortBy option of a request
+const (
+	sortKeyFile        = "file"
+	sortKeyLine        = "line"
+	sortKeyToken       = "token"
+	sortKeyDeclaration = "declaration"
+	sortKeyAge         = "age"
+	sortKeyAuthor      = "author"
+)
+
+// compares two matches, returning a negative number if a is before b, a positive number
+// if a is after b and 0 if they are equivalent for this key
+type matchComparator func(a, b *models.MatchedComment) int
+
+// compare two integers
+func compareInts(a, b int) int {
+	return a - b
+}
+
+// compare two strings
+func compareStrings(a, b string) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+// a string identifying a declaration for sorting, such as "method *Buffer.Add", declarations
+// are ordered by kind first. Comments outside of any declaration sort first
+func declarationSortKey(declaration *models.Declaration) string {
+	if declaration == nil {
+		return ""
+	}
+	return declaration.Kind + " " + declaration.Receiver + declaration.Type + "." + declaration.Name
+}
+
+// compare the blames of two matches, the matches without a blame sort last
+func compareBlames(a, b *models.Blame, compare func(a, b *models.Blame) int) int {
+	switch {
+	case a == nil && b == nil:
+		return 0
+	case a == nil:
+		return 1
+	case b == nil:
+		return -1
+	}
+	return compare(a, b)
+}
+
+// validate the SortBy keys of a request and create the comparator ordering matches by these keys.
+// Matches that are equivalent for every key are ordered by file, line, column and token, which
+// is also the order used when the request has no keys
+func compileOrdering(request models.CommentParsingRequest) (matchComparator, error) {
+
+	tokenIndexes := make(map[string]int)
+	for idx := len(request.Tokens) - 1; idx >= 0; idx-- {
+		tokenIndexes[request.Tokens[idx]] = idx
+	}
+	comparators := map[string]matchComparator{
+		sortKeyFile: func(a, b *models.MatchedComment) int {
+			return compareStrings(a.FileName, b.FileName)
+		},
+		sortKeyLine: func(a, b *models.MatchedComment) int {
+			return compareInts(a.LineNumber, b.LineNumber)
+		},
+		sortKeyToken: func(a, b *models.MatchedComment) int {
+			return compareInts(tokenIndexes[a.Token], tokenIndexes[b.Token])
+		},
+		sortKeyDeclaration: func(a, b *models.MatchedComment) int {
+			return compareStrings(declarationSortKey(a.Declaration), declarationSortKey(b.Declaration))
+		},
+		// the oldest first
+		sortKeyAge: func(a, b *models.MatchedComment) int {
+			return compareBlames(a.Blame, b.Blame, func(a, b *models.Blame) int {
+				return a.Date.Compare(b.Date)
+			})
+		},
+		sortKeyAuthor: func(a, b *models.MatchedComment) int {
+			return compareBlames(a.Blame, b.Blame, func(a, b *models.Blame) int {
+				return compareStrings(a.Author, b.Author)
+			})
+		},
+	}
+	column := func(a, b *models.MatchedComment) int {
+		return compareInts(a.EndColumn, b.EndColumn)
+	}
+
+	var keys []matchComparator
+	for _, key := range request.SortBy {
+		comparator, found := comparators[key]
+		if !found {
+			return nil, invalidRequest("Unknown sort key `%s`", key)
+		}
+		if (key == sortKeyAge || key == sortKeyAuthor) && !request.Blame {
+			return nil, invalidRequest("The sort key `%s` requires `Blame`", key)
+		}
+		keys = append(keys, comparator)
+	}
+	keys = append(keys, comparators[sortKeyFile], comparators[sortKeyLine], column, comparators[sortKeyToken])
+
+	return func(a, b *models.MatchedComment) int {
+		for _, key := range keys {
+			if result := key(a, b); result != 0 {
+				return result
+			}
+		}
+		return 0
+	}, nil
+}
+
+// sort the matches of every token of a package result and, when the request has SortBy keys,
+// list every match of every token in OrderedMatches
+func sortMatches(result *models.CommentParsingResult, compiled compiledRequest) {
+
+	var ordered []models.MatchedComment
+	for _, matches := range result.Matches {
+		sort.SliceStable(matches, func(i, j int) bool {
+			return compiled.ordering(&matches[i], &matches[j]) < 0
+		})
+		if len(compiled.request.SortBy) > 0 {
+			ordered = append(ordered, matches...)
+		}
+	}
+	if len(compiled.request.SortBy) < 1 {
+		return
+	}
+	sort.SliceStable(ordered, func(i, j int) bool {
+		return compiled.ordering(&ordered[i], &ordered[j]) < 0
+	})
+	result.OrderedMatches = ordered
+	if result.OrderedMatches == nil {
+		result.OrderedMatches = []models.MatchedComment{}
+	}
+}
@@ -0,0 +1,79 @@
+package services
+
+import (
+	"commentparser/logging"
+	"commentparser/models"
+	"context"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"path/filepath"
+	"testing"
+)
+
+// the base file name, token and line number of every match, in order
+func matchPositions(matches []models.MatchedComment) []string {
+	var positions []string
+	for _, match := range matches {
+		positions = append(positions, fmt.Sprintf("%s:%s:%02d", filepath.Base(match.FileName), match.Token, match.LineNumber))
+	}
+	return positions
+}
+
+func TestOrdering_Default(t *testing.T) {
+
+	scanner := NewScanner("", logging.NewMockLogging())
+	req := models.CommentParsingRequest{
+		Directory: "testdata/declarations",
+		Tokens:    []string{"NOTE"},
+	}
+
+	res, err := scanner.ExtractRelevantComments(context.Background(), req)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{
+		"declarations.go:NOTE:01", "declarations.go:NOTE:05", "declarations.go:NOTE:09",
+		"declarations.go:NOTE:15", "declarations.go:NOTE:18", "declarations.go:NOTE:20",
+		"declarations.go:NOTE:22", "declarations.go:NOTE:24", "declarations.go:NOTE:29",
+		"declarations.go:NOTE:33", "declarations.go:NOTE:36", "declarations.go:NOTE:40",
+		"declarations.go:NOTE:44",
+	}, matchPositions(res.Matches["NOTE"]))
+	assert.Nil(t, res.OrderedMatches)
+}
+
+func TestOrdering_SortBy(t *testing.T) {
+
+	scanner := NewScanner("", logging.NewMockLogging())
+	req := models.CommentParsingRequest{
+		Directory:   "testdata/sample",
+		Tokens:      []string{"TODO", "FIXME"},
+		FileClasses: []string{models.FileClass_GO, models.FileClass_TEST},
+		IgnoreCase:  true,
+		SortBy:      []string{"token"},
+	}
+
+	res, err := scanner.ExtractRelevantComments(context.Background(), req)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{
+		"sample.go:TODO:04", "sample.go:TODO:12", "sample_test.go:TODO:05", "sample.go:FIXME:06",
+	}, matchPositions(res.OrderedMatches))
+
+	req.SortBy = []string{"line", "file"}
+	res, err = scanner.ExtractRelevantComments(context.Background(), req)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{
+		"sample.go:TODO:04", "sample_test.go:TODO:05", "sample.go:FIXME:06", "sample.go:TODO:12",
+	}, matchPositions(res.OrderedMatches))
+	assert.Equal(t, []string{
+		"sample.go:TODO:04", "sample_test.go:TODO:05", "sample.go:TODO:12",
+	}, matchPositions(res.Matches["TODO"]))
+
+	req.SortBy = []string{"declaration"}
+	res, err = scanner.ExtractRelevantComments(context.Background(), req)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{
+		"sample.go:TODO:12", "sample.go:TODO:04", "sample.go:FIXME:06", "sample_test.go:TODO:05",
+	}, matchPositions(res.OrderedMatches))
+
+	req.SortBy = []string{"size"}
+	_, err = scanner.ExtractRelevantComments(context.Background(), req)
+	assert.Equal(t, InvalidRequestError{"Unknown sort key `size`"}, err)
+}
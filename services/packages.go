@@ -0,0 +1,187 @@
+package services
+
+import (
+	"context"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// true if the package name is a pattern that can match several packages, which is either
+// "std" or an import path containing the "..." wildcard, such as "net/..." or "./..."
+func IsPackagePattern(packageName string) bool {
+	return packageName == "std" || strings.Contains(packageName, "...")
+}
+
+// true if the import path or pattern is relative to the working directory
+func isLocalPattern(pattern string) bool {
+	return pattern == "." || pattern == ".." ||
+		strings.HasPrefix(pattern, "./") || strings.HasPrefix(pattern, "../")
+}
+
+// create a function that reports whether an import path is matched by the pattern, "..."
+// matches any string and "x/..." also matches "x" itself, like the go command does
+func patternMatcher(pattern string) func(importPath string) bool {
+	expression := regexp.MustCompile(
+		"^" + strings.Replace(regexp.QuoteMeta(pattern), `\.\.\.`, `.*`, -1) + "$")
+	if strings.HasSuffix(pattern, "/...") {
+		parent := strings.TrimSuffix(pattern, "/...")
+		return func(importPath string) bool {
+			return importPath == parent || expression.MatchString(importPath)
+		}
+	}
+	return expression.MatchString
+}
+
+// walk the directory tree below root and return the import paths matched by match of the
+// directories containing a Go package according to isPackage. The import path of root is
+// rootImportPath, directories starting with "." or "_", testdata and vendor directories are
+// not visited, as well as nested modules when skipModules is true. The walk stops with the error
+// of the context when it is done
+func walkPackages(
+	ctx context.Context,
+	root string,
+	rootImportPath string,
+	match func(string) bool,
+	isPackage func(dir string) bool,
+	skipModules bool) ([]string, error) {
+
+	var importPaths []string
+	err := filepath.Walk(root, func(dir string, info os.FileInfo, err error) error {
+		if err != nil {
+			if dir == root {
+				return err
+			}
+			return nil // an unreadable sub directory cannot contain matches we can scan
+		}
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return ctxErr
+		}
+		if !info.IsDir() {
+			return nil
+		}
+
+		rel, _ := filepath.Rel(root, dir)
+		importPath := joinImportPath(rootImportPath, filepath.ToSlash(rel))
+		if dir != root {
+			name := info.Name()
+			if strings.HasPrefix(name, ".") || strings.HasPrefix(name, "_") ||
+				name == "testdata" || name == "vendor" {
+				return filepath.SkipDir
+			}
+			if skipModules {
+				if _, err := os.Stat(filepath.Join(dir, "go.mod")); err == nil {
+					return filepath.SkipDir
+				}
+			}
+		}
+		// like the go command, "std" does not include the commands of the distribution
+		if rootImportPath == "std" {
+			if rel == "cmd" {
+				return filepath.SkipDir
+			}
+			importPath = filepath.ToSlash(rel)
+		}
+
+		if !match(importPath) {
+			return nil
+		}
+		if !isPackage(dir) {
+			return nil
+		}
+		importPaths = append(importPaths, importPath)
+		return nil
+	})
+	return importPaths, err
+}
+
+// join an import path with a slash seperated relative path, keeping the "./" prefix
+// of local import paths
+func joinImportPath(importPath, rel string) string {
+	joined := path.Join(importPath, rel)
+	if isLocalPattern(importPath) && !isLocalPattern(joined) {
+		joined = "./" + joined
+	}
+	return joined
+}
+
+// expand a package pattern into the import paths it matches, sorted by import path. Local
+// patterns are relative to the working directory, other patterns are looked up in GOROOT
+// and GOPATH, or in the main module and its requirements in module mode
+func (r packageResolver) expandPattern(ctx context.Context, pattern string) ([]string, error) {
+
+	goroot := filepath.Join(r.ctxt.GOROOT, "src")
+	if pattern == "std" {
+		importPaths, err := walkPackages(ctx, goroot, "std", func(string) bool { return true }, r.isPackageDir, false)
+		sort.Strings(importPaths)
+		return importPaths, err
+	}
+
+	// only the directory tree below the literal prefix of the pattern has to be walked
+	match := patternMatcher(pattern)
+	base := pattern
+	if idx := strings.Index(base, "..."); idx >= 0 {
+		base = base[:idx]
+	}
+	if idx := strings.LastIndex(base, "/"); idx >= 0 {
+		base = base[:idx]
+	} else {
+		base = ""
+	}
+
+	if isLocalPattern(pattern) {
+		if len(base) < 1 {
+			base = "."
+		}
+		baseDir := filepath.Join(r.workingDir, filepath.FromSlash(base))
+		importPaths, err := walkPackages(ctx, baseDir, base, match, r.isPackageDir, r.module != nil)
+		if err != nil || r.module == nil {
+			sort.Strings(importPaths)
+			return importPaths, err
+		}
+		// in module mode the packages of the main module are reported with their full import path
+		for idx, importPath := range importPaths {
+			dir := filepath.Join(r.workingDir, filepath.FromSlash(importPath))
+			importPaths[idx] = r.moduleImportPath(dir)
+		}
+		sort.Strings(importPaths)
+		return importPaths, nil
+	}
+
+	var roots []patternRoot
+	if r.module != nil {
+		roots = r.modulePatternRoots(base)
+	} else {
+		roots = append(roots, patternRoot{dir: filepath.Join(goroot, filepath.FromSlash(base)), importPath: base})
+		for _, gopath := range filepath.SplitList(r.ctxt.GOPATH) {
+			roots = append(roots, patternRoot{
+				dir:        filepath.Join(gopath, "src", filepath.FromSlash(base)),
+				importPath: base,
+			})
+		}
+	}
+
+	// the same import path can be found in several roots, the first one is the one imported
+	found := make(map[string]bool)
+	var importPaths []string
+	for _, root := range roots {
+		if _, err := os.Stat(root.dir); err != nil {
+			continue
+		}
+		rootImportPaths, err := walkPackages(ctx, root.dir, root.importPath, match, r.isPackageDir, r.module != nil)
+		if err != nil {
+			return nil, err
+		}
+		for _, importPath := range rootImportPaths {
+			if !found[importPath] {
+				found[importPath] = true
+				importPaths = append(importPaths, importPath)
+			}
+		}
+	}
+	sort.Strings(importPaths)
+	return importPaths, nil
+}
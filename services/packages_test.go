@@ -0,0 +1,85 @@
+package services
+
+import (
+	"commentparser/logging"
+	"commentparser/models"
+	"context"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestPackages_IsPackagePattern(t *testing.T) {
+
+	assert.True(t, IsPackagePattern("std"))
+	assert.True(t, IsPackagePattern("net/..."))
+	assert.True(t, IsPackagePattern("./..."))
+	assert.False(t, IsPackagePattern("fmt"))
+	assert.False(t, IsPackagePattern("./services"))
+}
+
+func TestPackages_PatternMatcher(t *testing.T) {
+
+	match := patternMatcher("net/...")
+	assert.True(t, match("net"))
+	assert.True(t, match("net/http/httptest"))
+	assert.False(t, match("network"))
+
+	match = patternMatcher("encoding/.../v2")
+	assert.True(t, match("encoding/json/v2"))
+	assert.False(t, match("encoding/json"))
+}
+
+func TestPackages_ExpandStdlibPattern(t *testing.T) {
+
+	resolver, _ := newPackageResolver("")
+	importPaths, err := resolver.expandPattern(context.Background(), "net/http/...")
+	assert.Nil(t, err)
+	assert.Contains(t, importPaths, "net/http")
+	assert.Contains(t, importPaths, "net/http/httptest")
+	assert.NotContains(t, importPaths, "net")
+	for _, importPath := range importPaths {
+		assert.NotContains(t, importPath, "testdata")
+	}
+}
+
+func TestPackages_ExpandModulePattern(t *testing.T) {
+
+	resolver, err := newPackageResolver("testdata/module")
+	assert.Nil(t, err)
+
+	importPaths, err := resolver.expandPattern(context.Background(), "./...")
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"example.com/fixture/cmd/tool", "example.com/fixture/greeter"}, importPaths)
+
+	importPaths, err = resolver.expandPattern(context.Background(), "example.com/fixture/...")
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"example.com/fixture/cmd/tool", "example.com/fixture/greeter"}, importPaths)
+}
+
+func TestExtractRelevantCommentsForPattern(t *testing.T) {
+
+	req := models.CommentParsingRequest{
+		Tokens:      []string{"TODO"},
+		PackageName: "net/http/...",
+	}
+	res, err := ExtractRelevantCommentsForPattern(req, logging.NewMockLogging())
+
+	assert.Nil(t, err)
+	assert.Equal(t, "net/http/...", res.Pattern)
+	assert.True(t, len(res.Packages) > 1)
+	assert.Equal(t, "net/http", res.Packages[0].ImportPath)
+	assert.Equal(t, "http", res.Packages[0].PackageName)
+	for idx := 1; idx < len(res.Packages); idx++ {
+		assert.True(t, res.Packages[idx-1].ImportPath < res.Packages[idx].ImportPath)
+	}
+}
+
+func TestExtractRelevantComments_RejectsPattern(t *testing.T) {
+
+	req := models.CommentParsingRequest{
+		Tokens:      []string{"TODO"},
+		PackageName: "net/...",
+	}
+	_, err := ExtractRelevantComments(req, logging.NewMockLogging())
+	assert.IsType(t, InvalidRequestError{}, err)
+}
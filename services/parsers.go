@@ -9,124 +9,558 @@ package services
 import (
 	"commentparser/logging"
 	"commentparser/models"
+	"context"
 	"go/ast"
 	"go/build"
 	"go/parser"
 	"go/token"
+	"io/ioutil"
 	"os"
+	"path"
 	"path/filepath"
 	"strings"
 )
 
-// Import a package from a dir and return it if it is valid (not binary or a command)
-func importPkg(path, dir string, logging logging.Logging) (*build.Package, error) {
+// the maximum number of context lines a request can ask for before and after a comment
+const MaxContextLines = 100
 
-	p, err := build.Import(path, dir, build.ImportComment)
+// the parts of a request that are validated and prepared before scanning
+type compiledRequest struct {
+	request  models.CommentParsingRequest // the request itself
+	matchers []tokenMatcher               // the matchers of request.Tokens, in the same order
+	classes  map[string]bool              // the file classes to scan
+	ctxt     build.Context                // the build context of the request
+	kinds    map[string]bool              // the declaration kinds matches are reported for, all if empty
+	ordering matchComparator              // orders the matches of a package
+}
+
+// validate and prepare a request, an InvalidRequestError is returned if the request is not valid
+func compileRequest(request models.CommentParsingRequest) (compiledRequest, error) {
+
+	compiled := compiledRequest{request: request}
+	var err error
+	if compiled.matchers, err = compileMatchers(request); err != nil {
+		return compiled, err
+	}
+	if compiled.classes, err = selectFileClasses(request); err != nil {
+		return compiled, err
+	}
+	if compiled.ctxt, err = buildContext(build.Default, request); err != nil {
+		return compiled, err
+	}
+	if request.ContextLines < 0 || request.ContextLines > MaxContextLines {
+		return compiled, invalidRequest("`ContextLines` must be between 0 and %v", MaxContextLines)
+	}
+	if compiled.kinds, err = selectDeclarationKinds(request); err != nil {
+		return compiled, err
+	}
+	if compiled.ordering, err = compileOrdering(request); err != nil {
+		return compiled, err
+	}
+	return compiled, nil
+}
+
+// Validate the tokens and options of a request without scanning it, so a request can be
+// rejected before it is queued. An InvalidRequestError is returned if the request is not valid
+func ValidateRequest(request models.CommentParsingRequest) error {
+	_, err := compileRequest(request)
+	return err
+}
+
+// true if the matches of comments in the declaration are reported, according to the
+// DeclarationKinds and ExportedOnly options of the request
+func (compiled compiledRequest) selectsDeclaration(declaration *models.Declaration) bool {
+	if len(compiled.kinds) < 1 && !compiled.request.ExportedOnly {
+		return true
+	}
+	if declaration == nil {
+		return false
+	}
+	if len(compiled.kinds) > 0 && !compiled.kinds[declaration.Kind] {
+		return false
+	}
+	return declaration.Exported || !compiled.request.ExportedOnly
+}
+
+// Go through all the sources of the file and if there are any comments matched by the
+// matchers of the search terms, return the file name, line number and the comment itself.
+// A file that cannot be read is skipped, and the comments of a file with syntax errors are
+// scanned as far as the parser could recover, both return their errors. The comments of the
+// file are taken from the cache of the scanner if the file did not change since it was parsed
+func (scanner Scanner) extractCommentsWithTerms(
+	compiled compiledRequest,
+	file packageFile) (map[string][]models.MatchedComment, bool, []models.FileError) {
+
+	fileName := file.path
+	logging := scanner.Logging
+	logging.Debug("Beginning extraction of %s", fileName)
+	src, err := ioutil.ReadFile(fileName)
 	if err != nil {
-		return nil, err
+		logging.Warning("Could not read %s: %v", fileName, err)
+		return nil, false, fileErrors(fileName, err)
+	}
+	parsed := scanner.parseFile(fileName, src)
+	if parsed.BinaryOnly {
+		logging.Info("Found binary-only flag in %s", fileName)
+		return nil, true, parsed.Errors // this is a binary only package
 	}
+	var lines []string
+	if compiled.request.ContextLines > 0 {
+		lines = sourceLines(src)
+	}
+	return matchComments(compiled, file, parsed, lines), false, parsed.Errors
+}
 
-	// we can tell if the package is binary only alongside the rest of the
-	// comment parsing
+// match the comments of a parsed file against the tokens of the request, lines are the source
+// lines of the file when the request has ContextLines
+func matchComments(
+	compiled compiledRequest,
+	file packageFile,
+	parsed parsedFile,
+	lines []string) map[string][]models.MatchedComment {
 
-	if p.IsCommand() {
-		logging.Debug("The package %s is a command", p.Name)
-		return nil, nil
+	fileName := file.path
+	searchTerms := compiled.request.Tokens
+	resultMap := make(map[string][]models.MatchedComment)
+	for _, comment := range parsed.Comments {
+		if !compiled.selectsDeclaration(comment.Declaration) {
+			continue
+		}
+		for idx, searchTerm := range searchTerms {
+			matchedText, offset, ok := compiled.matchers[idx].match(comment.Text)
+			if !ok {
+				continue
+			}
+			var declaration *models.Declaration
+			if comment.Declaration != nil {
+				// the parsed comments are shared by the scans using the cache
+				copied := *comment.Declaration
+				declaration = &copied
+			}
+			before, after := contextLines(lines, comment.LineNumber, comment.EndLineNumber, compiled.request.ContextLines)
+			resultMap[searchTerm] = append(resultMap[searchTerm], models.MatchedComment{
+				FileName:        fileName,
+				Token:           searchTerm,
+				LineNumber:      comment.LineNumber,
+				LineContent:     comment.Text,
+				MatchedText:     matchedText,
+				MatchOffset:     offset,
+				FileClass:       file.class,
+				BuildConstraint: parsed.BuildConstraint,
+				EndLineNumber:   comment.EndLineNumber,
+				EndColumn:       comment.EndColumn,
+				ContextBefore:   before,
+				ContextAfter:    after,
+				NodeKind:        comment.NodeKind,
+				Declaration:     declaration,
+				IsDocComment:    comment.IsDocComment,
+				Annotations:     annotations(compiled.matchers[idx], comment.Text),
+			})
+		}
 	}
+	return resultMap
+}
 
-	return p, nil
+// the comments of a file, from the cache of the scanner when it has one
+func (scanner Scanner) parseFile(fileName string, src []byte) parsedFile {
+
+	if scanner.Cache == nil {
+		return parseFileComments(fileName, src, scanner.Logging)
+	}
+	key := parseCacheKey(fileName, src)
+	if parsed, found := scanner.Cache.get(key); found {
+		scanner.CacheStats.hit()
+		return parsed
+	}
+	scanner.CacheStats.miss()
+	parsed := parseFileComments(fileName, src, scanner.Logging)
+	if err := scanner.Cache.put(key, parsed); err != nil {
+		scanner.Logging.Warning("Could not cache the comments of %s: %v", fileName, err)
+	}
+	return parsed
 }
 
-// Go through all the sources at filename and if there are any comments containing
-// the terms in search terms, return the file name, line number and the comment itself
-func extractCommentsWithTerms(
-	searchTerms []string,
-	fileName string,
-	logging logging.Logging) (map[string][]models.MatchedComment, bool) {
+// parse a file and extract all of its comment groups, with the declaration enclosing each of them
+func parseFileComments(fileName string, src []byte, logging logging.Logging) parsedFile {
 
-	logging.Debug("Beginning extraction of %s", fileName)
 	fileSet := token.NewFileSet()
-	f, err := parser.ParseFile(fileSet, fileName, nil, parser.ParseComments)
+	f, err := parser.ParseFile(fileSet, fileName, src, parser.ParseComments)
+	var parsed parsedFile
 	if err != nil {
-		panic(err)
+		logging.Warning("Could not parse %s: %v", fileName, err)
+		parsed.Errors = fileErrors(fileName, err)
+		if f == nil {
+			return parsed
+		}
 	}
-
-	var resultMap map[string][]models.MatchedComment
-	resultMap = make(map[string][]models.MatchedComment)
+	parsed.BuildConstraint = fileBuildConstraint(fileName, f)
 
 	commentMap := ast.NewCommentMap(fileSet, f, f.Comments)
-	for commentGroupIdx, commentGroups := range commentMap {
-		fileSet := fileSet.File(commentGroupIdx.Pos())
+	declarations := commentDeclarations(f)
+	for node, commentGroups := range commentMap {
 		for _, commentGroup := range commentGroups {
-			commentGroupText := commentGroup.Text()
-			for _, searchTerm := range searchTerms {
-				if strings.Contains(commentGroupText, "go:binary-only-package") {
-					logging.Info("Found binary-only flag in %s", fileName)
-					return nil, true // this is a binary only package
-				} else if strings.Contains(commentGroupText, searchTerm) {
-					childItems := &[]models.MatchedComment{}
-					if matchedTokens, found := resultMap[searchTerm]; found {
-						childItems = &matchedTokens
-					}
-					resultMap[searchTerm] = append(*childItems, models.MatchedComment{
-						FileName:    fileName,
-						LineNumber:  fileSet.Position(commentGroup.Pos()).Line,
-						LineContent: commentGroupText,
-					})
-				}
+			text := commentGroup.Text()
+			if strings.Contains(text, "go:binary-only-package") {
+				return parsedFile{BuildConstraint: parsed.BuildConstraint, BinaryOnly: true, Errors: parsed.Errors}
 			}
+			start := fileSet.Position(commentGroup.Pos())
+			end := fileSet.Position(commentGroup.End())
+			parsed.Comments = append(parsed.Comments, parsedComment{
+				Text:          text,
+				LineNumber:    start.Line,
+				EndLineNumber: end.Line,
+				EndColumn:     end.Column,
+				NodeKind:      nodeKind(node),
+				IsDocComment:  isDocComment(node, commentGroup),
+				Declaration:   declarations[commentGroup],
+			})
 		}
 	}
+	return parsed
+}
 
-	return resultMap, false
+// split a source file into lines, without their line terminators. The newline ending the
+// last line does not start another one
+func sourceLines(src []byte) []string {
+	lines := strings.Split(strings.TrimSuffix(string(src), "\n"), "\n")
+	for idx, line := range lines {
+		lines[idx] = strings.TrimSuffix(line, "\r")
+	}
+	return lines
 }
 
-// Go through all the sources belonging to the provided package name and if there are any comments containing
-// the terms in search terms, return the file name, line number and the comment itself
-func ExtractRelevantComments(
+// the (at most) n source lines before the line start and after the line end, line numbers
+// start at 1. Both are nil when n is 0
+func contextLines(lines []string, start int, end int, n int) ([]string, []string) {
+	if n < 1 {
+		return nil, nil
+	}
+	from := start - 1 - n
+	if from < 0 {
+		from = 0
+	}
+	to := end + n
+	if to > len(lines) {
+		to = len(lines)
+	}
+	before := append([]string{}, lines[from:start-1]...)
+	after := []string{}
+	if end < to {
+		after = append(after, lines[end:to]...)
+	}
+	return before, after
+}
+
+// Scanner extracts the comments of packages, which are resolved from its working directory
+// either in GOPATH mode or, if the working directory is inside a module, in module mode
+type Scanner struct {
+	WorkingDirectory string          // the directory packages are resolved from, the process working directory if empty
+	AllowDirectories bool            // if true, requests can scan any directory with their Directory
+	Workers          int             // the maximum number of files or packages processed concurrently, the number of CPUs if not set
+	Cache            *ParseCache     // the comments of the files parsed by previous scans, nil to parse every file
+	Index            *Index          // answers the requests for the packages it contains, nil to scan every request
+	CacheStats       *CacheStats     // if set, counts the files found in Cache and the files parsed
+	Progress         *ScanProgress   // if set, counts the packages and files scanned as the scans run
+	Logging          logging.Logging // the logging used while scanning
+}
+
+// create a new Scanner resolving packages from the working directory, which allows requests
+// to scan directories
+func NewScanner(workingDirectory string, logging logging.Logging) Scanner {
+	return Scanner{
+		WorkingDirectory: workingDirectory,
+		AllowDirectories: true,
+		Logging:          logging,
+	}
+}
+
+// create the resolver of a request, importing packages with the build context of the request,
+// and the import path to scan. See resolveImportPath
+func (scanner Scanner) resolveRequest(
+	compiled compiledRequest,
+	pattern bool) (packageResolver, string, error) {
+
+	resolver, importPath, err := scanner.resolveImportPath(compiled.request, pattern)
+	resolver.ctxt = compiled.ctxt
+	resolver.allPlatforms = compiled.request.AllPlatforms
+	return resolver, importPath, err
+}
+
+// create the resolver of a request and the import path to scan. A request with a Directory
+// is resolved from that directory, which is scanned as a single package or, when pattern
+// is true, as the local pattern "./..."
+func (scanner Scanner) resolveImportPath(
 	request models.CommentParsingRequest,
-	logging logging.Logging) (models.CommentParsingResult, error) {
+	pattern bool) (packageResolver, string, error) {
 
-	dir, err := os.Getwd()
-	if err != nil {
-		panic(err)
+	if len(request.Directory) < 1 {
+		resolver, err := newPackageResolver(scanner.WorkingDirectory)
+		return resolver, request.PackageName, err
 	}
 
-	logging.Debug("Beginning extraction of package %s", request.PackageName)
-	p, err := importPkg(request.PackageName, dir, logging)
+	if len(request.PackageName) > 0 {
+		return packageResolver{}, "", invalidRequest("Only one of `PackageName` and `Directory` can be given")
+	}
+	if !scanner.AllowDirectories {
+		return packageResolver{}, "", invalidRequest("Scanning directories is not enabled")
+	}
 
+	dir := request.Directory
+	if !filepath.IsAbs(dir) {
+		base := scanner.WorkingDirectory
+		if len(base) < 1 {
+			var err error
+			if base, err = os.Getwd(); err != nil {
+				return packageResolver{}, "", err
+			}
+		}
+		dir = filepath.Join(base, dir)
+	}
+	if info, err := os.Stat(dir); err != nil || !info.IsDir() {
+		return packageResolver{}, "", invalidRequest("The directory `%s` does not exist", request.Directory)
+	}
+
+	resolver, err := newPackageResolver(dir)
 	if err != nil {
-		return models.CommentParsingResult{}, err
+		return resolver, "", err
+	}
+	if pattern {
+		return resolver, "./...", nil
 	}
+	if resolver.module != nil {
+		return resolver, resolver.moduleImportPath(resolver.workingDir), nil
+	}
+	return resolver, ".", nil
+}
 
-	resultMap := make(map[string][]models.MatchedComment)
+// the outcome of scanning a single file
+type fileResult struct {
+	matches    map[string][]models.MatchedComment // the matches of the file by token
+	binaryOnly bool                               // true if the file marks its package as binary only
+	errors     []models.FileError                 // the errors found while reading or parsing the file
+}
+
+// Import the packages with the given import paths, then go through all of their sources and collect
+// the comments matched by the matchers of the request tokens. Packages are imported and files are
+// parsed concurrently by the workers of the scanner, and the matches of every package are sorted
+// according to the request once merged, so the results do not depend on the scheduling. If a
+// package cannot be imported, the results of the packages before it are returned with the error.
+// Files that cannot be read or parsed, and the files of a package that are invalid, such as
+// a file with another package clause, are listed in the FileErrors of their package instead
+// of failing the request. When the context is done, no more packages are imported or files
+// parsed, and the results of the packages before the first one that was not completely
+// scanned are returned with an IncompleteScanError. Commands have a result without a PackageName
+func (scanner Scanner) extractPackagesComments(
+	ctx context.Context,
+	resolver packageResolver,
+	importPaths []string,
+	compiled compiledRequest) ([]models.CommentParsingResult, error) {
 
-	result := models.CommentParsingResult{
-		PackageName: p.Name,
-		BinaryOnly:  false,
+	logging := scanner.Logging
+	progress := models.IncompleteScanResult{PackagesTotal: len(importPaths)}
+	scanner.Progress.addPackages(len(importPaths))
+	packages := make([]*build.Package, len(importPaths))
+	importErrors := make([]error, len(importPaths))
+	imported := make([]bool, len(importPaths))
+	cancelErr := runWorkers(ctx, scanner.workers(), len(importPaths), func(idx int) {
+		logging.Debug("Beginning extraction of package %s", importPaths[idx])
+		packages[idx], importErrors[idx] = resolver.importPkg(importPaths[idx], logging)
+		imported[idx] = true
+		scanner.Progress.packageImported()
+	})
+
+	// only the packages before the first one that cannot be imported are scanned
+	var err error
+	packageErrors := make([][]models.FileError, len(importPaths))
+	for idx, importErr := range importErrors {
+		if !imported[idx] {
+			// the context was done before the package was imported
+			packages, importPaths = packages[:idx], importPaths[:idx]
+			break
+		}
+		if hasInvalidFiles(packages[idx], importErr) {
+			logging.Warning("The package %s has invalid files: %v", importPaths[idx], importErr)
+			packageErrors[idx] = importFileErrors(packages[idx], importErr)
+			continue
+		}
+		if importErr != nil {
+			packages, importPaths, err = packages[:idx], importPaths[:idx], importErr
+			break
+		}
 	}
 
-	if p == nil {
-		return result, nil
+	// the files of every package are scanned by the same workers
+	var files []packageFile
+	var filePackages []int
+	for idx, p := range packages {
+		if p == nil {
+			continue
+		}
+		for _, file := range packageFiles(p, compiled.classes) {
+			files = append(files, file)
+			filePackages = append(filePackages, idx)
+		}
+	}
+	fileResults := make([]fileResult, len(files))
+	scanned := make([]bool, len(files))
+	scanner.Progress.addFiles(len(files))
+	if fileErr := runWorkers(ctx, scanner.workers(), len(files), func(idx int) {
+		fileRes := &fileResults[idx]
+		fileRes.matches, fileRes.binaryOnly, fileRes.errors = scanner.extractCommentsWithTerms(compiled, files[idx])
+		if compiled.request.Blame {
+			scanner.blameMatches(ctx, files[idx].path, fileRes.matches)
+		}
+		scanned[idx] = true
+		scanner.Progress.fileScanned()
+	}); fileErr != nil {
+		cancelErr = fileErr
 	}
 
-	for _, goFile := range p.GoFiles {
-		matchesForTokens, binaryOnly := extractCommentsWithTerms(request.Tokens, filepath.Join(p.Dir, goFile), logging)
-		if binaryOnly {
+	results := make([]models.CommentParsingResult, len(packages))
+	for idx, p := range packages {
+		results[idx] = models.CommentParsingResult{
+			ImportPath: importPaths[idx],
+			BinaryOnly: false,
+		}
+		if p != nil {
+			results[idx].PackageName = p.Name
+			results[idx].Matches = make(map[string][]models.MatchedComment)
+			results[idx].FileErrors = packageErrors[idx]
+		}
+	}
+	for idx, fileRes := range fileResults {
+		result := &results[filePackages[idx]]
+		result.FileErrors = append(result.FileErrors, fileRes.errors...)
+		if result.BinaryOnly {
+			continue
+		}
+		if fileRes.binaryOnly {
 			result.Matches = nil
 			result.BinaryOnly = true
-			return result, nil
-			break
-		} else if len(matchesForTokens) > 0 {
-			for key, val := range matchesForTokens {
-				if currentMatches, found := resultMap[key]; found {
-					resultMap[key] = append(currentMatches, val...)
-				} else {
-					resultMap[key] = val
-				}
+			continue
+		}
+		for key, val := range fileRes.matches {
+			result.Matches[key] = append(result.Matches[key], val...)
+		}
+	}
+	for idx := range results {
+		sortMatches(&results[idx], compiled)
+		sortFileErrors(results[idx].FileErrors)
+	}
+	if cancelErr == nil {
+		return results, err
+	}
+
+	// the results are cut before the first package with a file that was not scanned
+	progress.PackagesScanned = len(results)
+	progress.FilesTotal = len(files)
+	for idx := range files {
+		if !scanned[idx] {
+			if filePackages[idx] < progress.PackagesScanned {
+				progress.PackagesScanned = filePackages[idx]
 			}
+			continue
 		}
+		progress.FilesScanned++
+	}
+	logging.Warning("The scan was stopped: %v", cancelErr)
+	return results[:progress.PackagesScanned], incompleteScan(cancelErr, progress)
+}
+
+// Go through all the sources belonging to the provided package name and if there are any comments containing
+// the terms in search terms, return the file name, line number and the comment itself
+func (scanner Scanner) ExtractRelevantComments(
+	ctx context.Context,
+	request models.CommentParsingRequest) (models.CommentParsingResult, error) {
+
+	if IsPackagePattern(request.PackageName) {
+		return models.CommentParsingResult{}, invalidRequest(
+			"The package name `%s` is a pattern, patterns are only supported when scanning multiple packages",
+			request.PackageName)
 	}
-	result.Matches = resultMap
-	return result, nil
+
+	// validate the tokens before doing any work
+	compiled, err := compileRequest(request)
+	if err != nil {
+		return models.CommentParsingResult{}, err
+	}
+	if result, found := scanner.Index.answer(compiled); found {
+		scanner.Logging.Debug("The package %s was found in the index", request.PackageName)
+		return result, nil
+	}
+
+	resolver, importPath, err := scanner.resolveRequest(compiled, false)
+	if err != nil {
+		return models.CommentParsingResult{}, err
+	}
+
+	results, err := scanner.extractPackagesComments(ctx, resolver, []string{importPath}, compiled)
+	if err != nil {
+		return models.CommentParsingResult{}, err
+	}
+	return results[0], nil
+}
+
+// Expand the package pattern in the request's PackageName (such as "net/...", "./..." or "std"),
+// or every package below its Directory, and go through all the sources of every matched package,
+// like ExtractRelevantComments does for a single package. Commands and directories without Go
+// sources are skipped. If the context is done, the packages scanned so far are returned with an
+// IncompleteScanError
+func (scanner Scanner) ExtractRelevantCommentsForPattern(
+	ctx context.Context,
+	request models.CommentParsingRequest) (models.MultiPackageParsingResult, error) {
+
+	result := models.MultiPackageParsingResult{
+		Pattern: request.PackageName,
+	}
+	if len(request.Directory) > 0 {
+		result.Pattern = path.Join(filepath.ToSlash(request.Directory), "...")
+	}
+
+	compiled, err := compileRequest(request)
+	if err != nil {
+		return result, err
+	}
+
+	resolver, pattern, err := scanner.resolveRequest(compiled, true)
+	if err != nil {
+		return result, err
+	}
+
+	importPaths := []string{pattern}
+	if IsPackagePattern(pattern) {
+		importPaths, err = resolver.expandPattern(ctx, pattern)
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return result, incompleteScan(ctxErr, models.IncompleteScanResult{})
+		}
+		if err != nil {
+			return result, err
+		}
+		scanner.Logging.Debug("The pattern %s matched %v packages", result.Pattern, len(importPaths))
+	}
+
+	result.Packages = []models.CommentParsingResult{}
+	packageResults, err := scanner.extractPackagesComments(ctx, resolver, importPaths, compiled)
+	for _, packageResult := range packageResults {
+		if len(packageResult.PackageName) > 0 {
+			result.Packages = append(result.Packages, packageResult)
+		}
+	}
+	return result, err
+}
+
+// Go through all the sources belonging to the provided package name, resolved from the process
+// working directory, see Scanner.ExtractRelevantComments
+func ExtractRelevantComments(
+	request models.CommentParsingRequest,
+	logging logging.Logging) (models.CommentParsingResult, error) {
+	return NewScanner("", logging).ExtractRelevantComments(context.Background(), request)
+}
+
+// Go through all the sources of the packages matched by the pattern in the request, resolved
+// from the process working directory, see Scanner.ExtractRelevantCommentsForPattern
+func ExtractRelevantCommentsForPattern(
+	request models.CommentParsingRequest,
+	logging logging.Logging) (models.MultiPackageParsingResult, error) {
+	return NewScanner("", logging).ExtractRelevantCommentsForPattern(context.Background(), request)
 }
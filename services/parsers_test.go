@@ -5,8 +5,10 @@ import (
 	"bytes"
 	"commentparser/logging"
 	"commentparser/models"
+	"context"
 	"github.com/stretchr/testify/assert"
 	"github.com/tcnksm/go-binary-only-package"
+	"path/filepath"
 	"strings"
 	"testing"
 )
@@ -65,3 +67,121 @@ func TestMainWithUnknownPackage(t *testing.T) {
 		strings.Contains(errStr, "cannot find package \"voodoo1231\" in any of"),
 		"The output should be empty")
 }
+
+func TestExtractComments_RegexMode(t *testing.T) {
+
+	req := models.CommentParsingRequest{
+		Tokens:    []string{`TODO\(\w+\)`, "FIXME|XXX"},
+		MatchMode: models.MatchMode_REGEX,
+	}
+	compiled, err := compileRequest(req)
+	assert.Nil(t, err)
+
+	res, binaryOnly, _ := NewScanner("", logging.NewMockLogging()).extractCommentsWithTerms(
+		compiled, packageFile{path: "testdata/sample/sample.go"})
+
+	assert.False(t, binaryOnly)
+	assert.Equal(t, 1, len(res[`TODO\(\w+\)`]))
+	assert.Equal(t, "TODO(alice)", res[`TODO\(\w+\)`][0].MatchedText)
+	assert.Equal(t, 0, res[`TODO\(\w+\)`][0].MatchOffset)
+	assert.Equal(t, 2, len(res["FIXME|XXX"]))
+}
+
+func TestExtractComments_LiteralOffset(t *testing.T) {
+
+	req := models.CommentParsingRequest{Tokens: []string{"greeting"}}
+	compiled, _ := compileRequest(req)
+
+	res, _, _ := NewScanner("", logging.NewMockLogging()).extractCommentsWithTerms(
+		compiled, packageFile{path: "testdata/sample/sample.go"})
+
+	assert.Equal(t, 2, len(res["greeting"]))
+	for _, match := range res["greeting"] {
+		assert.Equal(t, "greeting", match.LineContent[match.MatchOffset:match.MatchOffset+len(match.MatchedText)])
+	}
+}
+
+func TestCompileMatchers_Invalid(t *testing.T) {
+
+	_, err := compileMatchers(models.CommentParsingRequest{
+		Tokens:    []string{"TODO("},
+		MatchMode: models.MatchMode_REGEX,
+	})
+	assert.IsType(t, InvalidRequestError{}, err)
+
+	_, err = compileMatchers(models.CommentParsingRequest{
+		Tokens:    []string{"TODO"},
+		MatchMode: "glob",
+	})
+	assert.Equal(t, "Unknown match mode `glob`", err.Error())
+}
+
+func TestExtractComments_FileClasses(t *testing.T) {
+
+	scanner := NewScanner("", logging.NewMockLogging())
+	req := models.CommentParsingRequest{
+		Directory: "testdata/sample",
+		Tokens:    []string{"TODO"},
+	}
+
+	res, err := scanner.ExtractRelevantComments(context.Background(), req)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(res.Matches["TODO"]))
+	assert.Equal(t, models.FileClass_GO, res.Matches["TODO"][0].FileClass)
+
+	req.FileClasses = []string{
+		models.FileClass_GO, models.FileClass_TEST, models.FileClass_XTEST, models.FileClass_IGNORED}
+	res, err = scanner.ExtractRelevantComments(context.Background(), req)
+	assert.Nil(t, err)
+
+	classes := make(map[string]string)
+	for _, match := range res.Matches["TODO"] {
+		classes[filepath.Base(match.FileName)] = match.FileClass
+	}
+	assert.Equal(t, map[string]string{
+		"sample.go":        models.FileClass_GO,
+		"sample_test.go":   models.FileClass_TEST,
+		"sample_x_test.go": models.FileClass_XTEST,
+		"generate.go":      models.FileClass_IGNORED,
+	}, classes)
+
+	req.FileClasses = []string{"generated"}
+	_, err = scanner.ExtractRelevantComments(context.Background(), req)
+	assert.Equal(t, InvalidRequestError{"Unknown file class `generated`"}, err)
+}
+
+func TestExtractComments_ContextLines(t *testing.T) {
+
+	req := models.CommentParsingRequest{
+		Tokens:       []string{"TODO", "FIXME"},
+		ContextLines: 2,
+	}
+	compiled, err := compileRequest(req)
+	assert.Nil(t, err)
+	res, _, _ := NewScanner("", logging.NewMockLogging()).extractCommentsWithTerms(
+		compiled, packageFile{path: "testdata/sample/sample.go"})
+
+	fixme := res["FIXME"][0]
+	assert.Equal(t, 6, fixme.LineNumber)
+	assert.Equal(t, 6, fixme.EndLineNumber)
+	assert.Equal(t, len("\t// FIXME the purposes of this greeting are unclear")+1, fixme.EndColumn)
+	assert.Equal(t, []string{"// TODO(alice): replace the greeting with a configurable one", "func Greet() string {"},
+		fixme.ContextBefore)
+	assert.Equal(t, []string{"\treturn \"hello\"", "}"}, fixme.ContextAfter)
+
+	todo := res["TODO"][0]
+	assert.Equal(t, []string{"package sample", ""}, todo.ContextBefore)
+
+	// the context is cut at the start and the end of the file
+	before, after := contextLines([]string{"a", "b", "c"}, 1, 2, 5)
+	assert.Equal(t, []string{}, before)
+	assert.Equal(t, []string{"c"}, after)
+
+	// the final newline does not add an empty line to the context
+	assert.Equal(t, []string{"a", "b"}, sourceLines([]byte("a\r\nb\n")))
+	assert.Equal(t, []string{"a", "", "b"}, sourceLines([]byte("a\n\nb")))
+
+	req.ContextLines = MaxContextLines + 1
+	_, err = compileRequest(req)
+	assert.Equal(t, InvalidRequestError{"`ContextLines` must be between 0 and 100"}, err)
+}
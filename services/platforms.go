@@ -0,0 +1,220 @@
+package services
+
+import (
+	"commentparser/models"
+	"go/ast"
+	"go/build"
+	"go/build/constraint"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// the GOOS/GOARCH pairs supported by the go command, as listed by "go tool dist list"
+var knownPlatforms = []string{
+	"aix/ppc64",
+	"android/386", "android/amd64", "android/arm", "android/arm64",
+	"darwin/amd64", "darwin/arm64",
+	"dragonfly/amd64",
+	"freebsd/386", "freebsd/amd64", "freebsd/arm", "freebsd/arm64",
+	"illumos/amd64",
+	"ios/amd64", "ios/arm64",
+	"js/wasm",
+	"linux/386", "linux/amd64", "linux/arm", "linux/arm64", "linux/loong64", "linux/mips",
+	"linux/mips64", "linux/mips64le", "linux/mipsle", "linux/ppc64", "linux/ppc64le",
+	"linux/riscv64", "linux/s390x",
+	"netbsd/386", "netbsd/amd64", "netbsd/arm", "netbsd/arm64",
+	"openbsd/386", "openbsd/amd64", "openbsd/arm", "openbsd/arm64", "openbsd/ppc64", "openbsd/riscv64",
+	"plan9/386", "plan9/amd64", "plan9/arm",
+	"solaris/amd64",
+	"wasip1/wasm",
+	"windows/386", "windows/amd64", "windows/arm64",
+}
+
+// the GOOS and GOARCH values of the known platforms
+var knownOS, knownArch = func() (map[string]bool, map[string]bool) {
+	goos, goarch := make(map[string]bool), make(map[string]bool)
+	for _, platform := range knownPlatforms {
+		parts := strings.SplitN(platform, "/", 2)
+		goos[parts[0]] = true
+		goarch[parts[1]] = true
+	}
+	return goos, goarch
+}()
+
+// true if the GOOS/GOARCH pair is one of the known platforms
+func isKnownPlatform(platform string) bool {
+	for _, known := range knownPlatforms {
+		if known == platform {
+			return true
+		}
+	}
+	return false
+}
+
+// the characters a build tag can be made of
+var buildTagExpression = regexp.MustCompile(`^[\pL\pN_.]+$`)
+
+// create the build context of a request from the base context, applying its GOOS, GOARCH,
+// BuildTags and CgoEnabled. An InvalidRequestError is returned for unknown platforms
+func buildContext(base build.Context, request models.CommentParsingRequest) (build.Context, error) {
+
+	ctxt := base
+	if request.AllPlatforms && (len(request.GOOS) > 0 || len(request.GOARCH) > 0) {
+		return ctxt, invalidRequest("`GOOS` and `GOARCH` cannot be given with `AllPlatforms`")
+	}
+	if len(request.GOOS) > 0 {
+		if !knownOS[request.GOOS] {
+			return ctxt, invalidRequest("Unknown GOOS `%s`", request.GOOS)
+		}
+		ctxt.GOOS = request.GOOS
+	}
+	if len(request.GOARCH) > 0 {
+		if !knownArch[request.GOARCH] {
+			return ctxt, invalidRequest("Unknown GOARCH `%s`", request.GOARCH)
+		}
+		ctxt.GOARCH = request.GOARCH
+	}
+	if platform := ctxt.GOOS + "/" + ctxt.GOARCH; !request.AllPlatforms && !isKnownPlatform(platform) {
+		return ctxt, invalidRequest("Unknown platform `%s`", platform)
+	}
+
+	for _, tag := range request.BuildTags {
+		if !buildTagExpression.MatchString(tag) {
+			return ctxt, invalidRequest("Invalid build tag `%s`", tag)
+		}
+	}
+	ctxt.BuildTags = append(append([]string{}, base.BuildTags...), request.BuildTags...)
+
+	if request.CgoEnabled != nil {
+		ctxt.CgoEnabled = *request.CgoEnabled
+	} else if request.AllPlatforms {
+		// cgo files are a part of the package on the platforms that have a C toolchain
+		ctxt.CgoEnabled = true
+	}
+	return ctxt, nil
+}
+
+// the build contexts of every known platform, derived from the context of a request
+func platformContexts(ctxt build.Context) []build.Context {
+	ctxts := make([]build.Context, 0, len(knownPlatforms))
+	for _, platform := range knownPlatforms {
+		parts := strings.SplitN(platform, "/", 2)
+		platformCtxt := ctxt
+		platformCtxt.GOOS, platformCtxt.GOARCH = parts[0], parts[1]
+		ctxts = append(ctxts, platformCtxt)
+	}
+	return ctxts
+}
+
+// merge the packages imported for several platforms, a file belongs to the class it has on any
+// platform it is built for, and is only ignored if it is ignored on every platform
+func mergePlatformPackages(packages []*build.Package) *build.Package {
+
+	merged := *packages[0]
+	classes := make(map[string]string)
+	for _, p := range packages {
+		filesByClass := map[string][]string{
+			models.FileClass_GO:    p.GoFiles,
+			models.FileClass_CGO:   p.CgoFiles,
+			models.FileClass_TEST:  p.TestGoFiles,
+			models.FileClass_XTEST: p.XTestGoFiles,
+		}
+		for class, names := range filesByClass {
+			for _, name := range names {
+				classes[name] = class
+			}
+		}
+		if len(merged.Name) < 1 {
+			merged.Name = p.Name
+		}
+	}
+
+	lists := make(map[string][]string)
+	for _, name := range packages[0].IgnoredGoFiles {
+		if _, built := classes[name]; !built {
+			lists[models.FileClass_IGNORED] = append(lists[models.FileClass_IGNORED], name)
+		}
+	}
+	for name, class := range classes {
+		lists[class] = append(lists[class], name)
+	}
+	for _, names := range lists {
+		sort.Strings(names)
+	}
+	merged.GoFiles = lists[models.FileClass_GO]
+	merged.CgoFiles = lists[models.FileClass_CGO]
+	merged.TestGoFiles = lists[models.FileClass_TEST]
+	merged.XTestGoFiles = lists[models.FileClass_XTEST]
+	merged.IgnoredGoFiles = lists[models.FileClass_IGNORED]
+	return &merged
+}
+
+// the constraint implied by the name of a file, such as "windows" for "file_windows.go" or
+// "linux && arm64" for "file_linux_arm64_test.go", nil if the name has no constraint
+func fileNameConstraint(fileName string) constraint.Expr {
+
+	name := strings.TrimSuffix(filepath.Base(fileName), ".go")
+	name = strings.TrimSuffix(name, "_test")
+	// like go/build, the first element of the name is never a constraint
+	if idx := strings.Index(name, "_"); idx >= 0 {
+		name = name[idx+1:]
+	} else {
+		return nil
+	}
+
+	parts := strings.Split(name, "_")
+	n := len(parts)
+	if n >= 2 && knownOS[parts[n-2]] && knownArch[parts[n-1]] {
+		return &constraint.AndExpr{
+			X: &constraint.TagExpr{Tag: parts[n-2]},
+			Y: &constraint.TagExpr{Tag: parts[n-1]},
+		}
+	}
+	if n >= 1 && (knownOS[parts[n-1]] || knownArch[parts[n-1]]) {
+		return &constraint.TagExpr{Tag: parts[n-1]}
+	}
+	return nil
+}
+
+// the build constraint of a parsed file, combining its file name with its //go:build line, or
+// its // +build lines for older files. The constraint is empty if the file has none
+func fileBuildConstraint(fileName string, f *ast.File) string {
+
+	var directive constraint.Expr
+	var plusBuild constraint.Expr
+	for _, group := range f.Comments {
+		if group.Pos() >= f.Package {
+			break
+		}
+		for _, comment := range group.List {
+			expr, err := constraint.Parse(comment.Text)
+			if err != nil {
+				continue
+			}
+			switch {
+			case constraint.IsGoBuild(comment.Text):
+				directive = expr
+			case plusBuild == nil:
+				plusBuild = expr
+			default:
+				plusBuild = &constraint.AndExpr{X: plusBuild, Y: expr}
+			}
+		}
+	}
+	if directive == nil {
+		directive = plusBuild
+	}
+
+	nameConstraint := fileNameConstraint(fileName)
+	switch {
+	case nameConstraint == nil && directive == nil:
+		return ""
+	case nameConstraint == nil:
+		return directive.String()
+	case directive == nil:
+		return nameConstraint.String()
+	}
+	return (&constraint.AndExpr{X: nameConstraint, Y: directive}).String()
+}
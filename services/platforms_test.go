@@ -0,0 +1,107 @@
+package services
+
+import (
+	"commentparser/logging"
+	"commentparser/models"
+	"context"
+	"github.com/stretchr/testify/assert"
+	"path/filepath"
+	"testing"
+)
+
+// the build constraint of every file with a match, by file name
+func matchedConstraints(res models.CommentParsingResult) map[string]string {
+	constraints := make(map[string]string)
+	for _, match := range res.Matches["TODO"] {
+		constraints[filepath.Base(match.FileName)] = match.BuildConstraint
+	}
+	return constraints
+}
+
+func TestPlatforms_GOOS(t *testing.T) {
+
+	scanner := NewScanner("", logging.NewMockLogging())
+	req := models.CommentParsingRequest{
+		Directory: "testdata/platforms",
+		Tokens:    []string{"TODO"},
+		GOOS:      "windows",
+		GOARCH:    "amd64",
+	}
+
+	res, err := scanner.ExtractRelevantComments(context.Background(), req)
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]string{
+		"platforms.go":         "",
+		"platforms_windows.go": "windows",
+	}, matchedConstraints(res))
+
+	req.GOOS, req.GOARCH = "linux", "arm64"
+	req.BuildTags = []string{"integration"}
+	res, err = scanner.ExtractRelevantComments(context.Background(), req)
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]string{
+		"platforms.go":             "",
+		"platforms_linux_arm64.go": "linux && arm64",
+		"integration.go":           "integration",
+	}, matchedConstraints(res))
+}
+
+func TestPlatforms_AllPlatforms(t *testing.T) {
+
+	scanner := NewScanner("", logging.NewMockLogging())
+	req := models.CommentParsingRequest{
+		Directory:    "testdata/platforms",
+		Tokens:       []string{"TODO"},
+		AllPlatforms: true,
+	}
+
+	res, err := scanner.ExtractRelevantComments(context.Background(), req)
+	assert.Nil(t, err)
+	assert.Equal(t, "platforms", res.PackageName)
+	assert.Equal(t, map[string]string{
+		"platforms.go":             "",
+		"platforms_windows.go":     "windows",
+		"platforms_linux_arm64.go": "linux && arm64",
+		"unix.go":                  "darwin || freebsd",
+	}, matchedConstraints(res))
+
+	// only the files that no platform builds are ignored
+	req.FileClasses = []string{models.FileClass_IGNORED}
+	res, err = scanner.ExtractRelevantComments(context.Background(), req)
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]string{"integration.go": "integration"}, matchedConstraints(res))
+}
+
+func TestPlatforms_Invalid(t *testing.T) {
+
+	scanner := NewScanner("", logging.NewMockLogging())
+	req := models.CommentParsingRequest{
+		Directory: "testdata/platforms",
+		Tokens:    []string{"TODO"},
+	}
+
+	req.GOOS = "beos"
+	_, err := scanner.ExtractRelevantComments(context.Background(), req)
+	assert.Equal(t, InvalidRequestError{"Unknown GOOS `beos`"}, err)
+
+	req.GOOS, req.GOARCH = "windows", "s390x"
+	_, err = scanner.ExtractRelevantComments(context.Background(), req)
+	assert.Equal(t, InvalidRequestError{"Unknown platform `windows/s390x`"}, err)
+
+	req.AllPlatforms = true
+	_, err = scanner.ExtractRelevantComments(context.Background(), req)
+	assert.Equal(t, InvalidRequestError{"`GOOS` and `GOARCH` cannot be given with `AllPlatforms`"}, err)
+
+	req.GOOS, req.GOARCH, req.AllPlatforms = "", "", false
+	req.BuildTags = []string{"a b"}
+	_, err = scanner.ExtractRelevantComments(context.Background(), req)
+	assert.Equal(t, InvalidRequestError{"Invalid build tag `a b`"}, err)
+}
+
+func TestPlatforms_FileNameConstraint(t *testing.T) {
+	assert.Nil(t, fileNameConstraint("windows.go"))
+	assert.Nil(t, fileNameConstraint("file_other.go"))
+	assert.Equal(t, "windows", fileNameConstraint("dir/file_windows.go").String())
+	assert.Equal(t, "arm64", fileNameConstraint("file_arm64_test.go").String())
+	assert.Equal(t, "linux && arm64", fileNameConstraint("file_linux_arm64.go").String())
+}
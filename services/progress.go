@@ -0,0 +1,54 @@
+package services
+
+import (
+	"commentparser/models"
+	"sync/atomic"
+)
+
+// ScanProgress counts the packages and files of the scans of a scanner as they run, so the
+// progress of a long scan can be reported. The counters are updated atomically by the workers
+// of the scans
+type ScanProgress struct {
+	packagesTotal    int64 // the number of packages to scan
+	packagesImported int64 // the number of packages imported
+	filesTotal       int64 // the number of files of the imported packages
+	filesScanned     int64 // the number of files scanned
+}
+
+// add packages to scan, progress can be nil
+func (progress *ScanProgress) addPackages(count int) {
+	if progress != nil {
+		atomic.AddInt64(&progress.packagesTotal, int64(count))
+	}
+}
+
+// count an imported package, progress can be nil
+func (progress *ScanProgress) packageImported() {
+	if progress != nil {
+		atomic.AddInt64(&progress.packagesImported, 1)
+	}
+}
+
+// add files to scan, progress can be nil
+func (progress *ScanProgress) addFiles(count int) {
+	if progress != nil {
+		atomic.AddInt64(&progress.filesTotal, int64(count))
+	}
+}
+
+// count a scanned file, progress can be nil
+func (progress *ScanProgress) fileScanned() {
+	if progress != nil {
+		atomic.AddInt64(&progress.filesScanned, 1)
+	}
+}
+
+// a snapshot of the counters that is safe to read while scans are running
+func (progress *ScanProgress) Snapshot() models.ScanProgress {
+	return models.ScanProgress{
+		PackagesTotal:    int(atomic.LoadInt64(&progress.packagesTotal)),
+		PackagesImported: int(atomic.LoadInt64(&progress.packagesImported)),
+		FilesTotal:       int(atomic.LoadInt64(&progress.filesTotal)),
+		FilesScanned:     int(atomic.LoadInt64(&progress.filesScanned)),
+	}
+}
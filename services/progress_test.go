@@ -0,0 +1,27 @@
+package services
+
+import (
+	"commentparser/logging"
+	"commentparser/models"
+	"context"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestProgress_CountsScan(t *testing.T) {
+
+	scanner := NewScanner("", logging.NewMockLogging())
+	scanner.Progress = &ScanProgress{}
+	assert.Equal(t, models.ScanProgress{}, scanner.Progress.Snapshot())
+
+	_, err := scanner.ExtractRelevantComments(context.Background(), models.CommentParsingRequest{
+		Directory: "testdata/sample",
+		Tokens:    []string{"TODO"},
+	})
+	assert.Nil(t, err)
+	progress := scanner.Progress.Snapshot()
+	assert.Equal(t, 1, progress.PackagesTotal)
+	assert.Equal(t, 1, progress.PackagesImported)
+	assert.True(t, progress.FilesTotal > 0)
+	assert.Equal(t, progress.FilesTotal, progress.FilesScanned)
+}
@@ -0,0 +1,289 @@
+package services
+
+import (
+	"commentparser/models"
+	"strings"
+	"unicode"
+)
+
+// A small boolean query language used by MatchMode_QUERY, for example
+//
+//	deprecated AND NOT (TODO OR "work around")
+//
+// Words and "quoted phrases" are matched as literal terms, honoring the IgnoreCase and
+// WholeWord options of the request. Terms can be combined with AND, OR and NOT (which
+// must be written in upper case) and grouped with parentheses. Two terms without an
+// operator between them are joined with AND, and AND binds tighter than OR.
+
+// the result of evaluating a query against the text of a comment
+type queryResult struct {
+	ok     bool   // true if the query matched the text
+	found  bool   // true if a (not negated) term was found, which is what locates the match
+	text   string // the text matched by the leftmost term
+	offset int    // the byte offset of text
+}
+
+// keep the leftmost located term of the two results
+func (res queryResult) leftmost(other queryResult) queryResult {
+	if other.found && (!res.found || other.offset < res.offset) {
+		res.found = true
+		res.text = other.text
+		res.offset = other.offset
+	}
+	return res
+}
+
+// a node of a parsed query
+type queryNode interface {
+	evaluate(text string) queryResult // evaluate the node against the text of a comment
+}
+
+// a word or phrase of the query
+type termNode struct {
+	matcher tokenMatcher // matches the term according to the options of the request
+}
+
+func (node termNode) evaluate(text string) queryResult {
+	matched, offset, ok := node.matcher.match(text)
+	return queryResult{ok: ok, found: ok, text: matched, offset: offset}
+}
+
+// matches when all of its operands match
+type andNode struct {
+	operands []queryNode
+}
+
+func (node andNode) evaluate(text string) queryResult {
+	res := queryResult{ok: true}
+	for _, operand := range node.operands {
+		operandRes := operand.evaluate(text)
+		if !operandRes.ok {
+			return queryResult{}
+		}
+		res = res.leftmost(operandRes)
+	}
+	return res
+}
+
+// matches when any of its operands match
+type orNode struct {
+	operands []queryNode
+}
+
+func (node orNode) evaluate(text string) queryResult {
+	var res queryResult
+	for _, operand := range node.operands {
+		operandRes := operand.evaluate(text)
+		if operandRes.ok {
+			res.ok = true
+			res = res.leftmost(operandRes)
+		}
+	}
+	return res
+}
+
+// matches when its operand does not match
+type notNode struct {
+	operand queryNode
+}
+
+func (node notNode) evaluate(text string) queryResult {
+	return queryResult{ok: !node.operand.evaluate(text).ok}
+}
+
+// a compiled query, usable as the matcher of a token
+type queryMatcher struct {
+	root queryNode
+}
+
+func (m queryMatcher) match(text string) (string, int, bool) {
+	res := m.root.evaluate(text)
+	return res.text, res.offset, res.ok
+}
+
+// the kinds of lexical items of a query
+const (
+	queryItemWord = iota
+	queryItemPhrase
+	queryItemOpen
+	queryItemClose
+)
+
+// a lexical item of a query
+type queryItem struct {
+	kind  int    // one of the queryItem* constants
+	value string // the word or the unquoted phrase
+}
+
+// split a query into words, phrases and parentheses
+func lexQuery(query string) ([]queryItem, error) {
+
+	var items []queryItem
+	runes := []rune(query)
+	for idx := 0; idx < len(runes); {
+		r := runes[idx]
+		switch {
+		case unicode.IsSpace(r):
+			idx++
+		case r == '(':
+			items = append(items, queryItem{kind: queryItemOpen})
+			idx++
+		case r == ')':
+			items = append(items, queryItem{kind: queryItemClose})
+			idx++
+		case r == '"':
+			var phrase []rune
+			idx++
+			for ; idx < len(runes) && runes[idx] != '"'; idx++ {
+				if runes[idx] == '\\' && idx+1 < len(runes) {
+					idx++
+				}
+				phrase = append(phrase, runes[idx])
+			}
+			if idx >= len(runes) {
+				return nil, invalidRequest("Invalid query `%s`: unterminated quoted phrase", query)
+			}
+			idx++ // the closing quote
+			items = append(items, queryItem{kind: queryItemPhrase, value: string(phrase)})
+		default:
+			start := idx
+			for ; idx < len(runes) && !unicode.IsSpace(runes[idx]) && !strings.ContainsRune(`()"`, runes[idx]); idx++ {
+			}
+			items = append(items, queryItem{kind: queryItemWord, value: string(runes[start:idx])})
+		}
+	}
+	return items, nil
+}
+
+// a recursive descent parser over the lexical items of a query
+type queryParser struct {
+	query   string                       // the query being parsed, for error messages
+	items   []queryItem                  // the lexical items of the query
+	pos     int                          // the index of the next item
+	request models.CommentParsingRequest // the options the terms are compiled with
+}
+
+// true if the next item is the given operator keyword
+func (p *queryParser) peekKeyword(keyword string) bool {
+	return p.pos < len(p.items) &&
+		p.items[p.pos].kind == queryItemWord &&
+		p.items[p.pos].value == keyword
+}
+
+// or := and ("OR" and)*
+func (p *queryParser) parseOr() (queryNode, error) {
+	operand, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	operands := []queryNode{operand}
+	for p.peekKeyword("OR") {
+		p.pos++
+		operand, err = p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		operands = append(operands, operand)
+	}
+	if len(operands) == 1 {
+		return operands[0], nil
+	}
+	return orNode{operands: operands}, nil
+}
+
+// and := not (["AND"] not)*
+func (p *queryParser) parseAnd() (queryNode, error) {
+	operand, err := p.parseNot()
+	if err != nil {
+		return nil, err
+	}
+	operands := []queryNode{operand}
+	for p.pos < len(p.items) && p.items[p.pos].kind != queryItemClose && !p.peekKeyword("OR") {
+		if p.peekKeyword("AND") {
+			p.pos++
+		}
+		operand, err = p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		operands = append(operands, operand)
+	}
+	if len(operands) == 1 {
+		return operands[0], nil
+	}
+	return andNode{operands: operands}, nil
+}
+
+// not := "NOT" not | primary
+func (p *queryParser) parseNot() (queryNode, error) {
+	if p.peekKeyword("NOT") {
+		p.pos++
+		operand, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		return notNode{operand: operand}, nil
+	}
+	return p.parsePrimary()
+}
+
+// primary := "(" or ")" | word | phrase
+func (p *queryParser) parsePrimary() (queryNode, error) {
+	if p.pos >= len(p.items) {
+		return nil, invalidRequest("Invalid query `%s`: unexpected end of query", p.query)
+	}
+	item := p.items[p.pos]
+	p.pos++
+	switch item.kind {
+	case queryItemOpen:
+		node, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if p.pos >= len(p.items) || p.items[p.pos].kind != queryItemClose {
+			return nil, invalidRequest("Invalid query `%s`: missing closing parenthesis", p.query)
+		}
+		p.pos++
+		return node, nil
+	case queryItemClose:
+		return nil, invalidRequest("Invalid query `%s`: unexpected closing parenthesis", p.query)
+	case queryItemWord:
+		if item.value == "AND" || item.value == "OR" {
+			return nil, invalidRequest("Invalid query `%s`: `%s` is missing an operand", p.query, item.value)
+		}
+	}
+
+	if len(item.value) < 1 {
+		return nil, invalidRequest("Invalid query `%s`: empty phrase", p.query)
+	}
+	termRequest := p.request
+	termRequest.MatchMode = models.MatchMode_LITERAL
+	matcher, err := compileMatcher(item.value, termRequest)
+	if err != nil {
+		return nil, err
+	}
+	return termNode{matcher: matcher}, nil
+}
+
+// parse a query into a matcher, the terms of the query are compiled with the
+// IgnoreCase and WholeWord options of the request
+func compileQuery(query string, request models.CommentParsingRequest) (tokenMatcher, error) {
+
+	items, err := lexQuery(query)
+	if err != nil {
+		return nil, err
+	}
+	if len(items) < 1 {
+		return nil, invalidRequest("Invalid query `%s`: the query is empty", query)
+	}
+
+	parser := &queryParser{query: query, items: items, request: request}
+	root, err := parser.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if parser.pos < len(items) {
+		return nil, invalidRequest("Invalid query `%s`: unexpected closing parenthesis", query)
+	}
+	return queryMatcher{root: root}, nil
+}
@@ -0,0 +1,84 @@
+package services
+
+import (
+	"commentparser/models"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func compileTestQuery(t *testing.T, query string) tokenMatcher {
+	matcher, err := compileQuery(query, models.CommentParsingRequest{})
+	assert.Nil(t, err)
+	return matcher
+}
+
+func TestQuery_Operators(t *testing.T) {
+
+	matcher := compileTestQuery(t, "deprecated AND NOT TODO")
+	_, _, ok := matcher.match("this is deprecated, use Bar instead")
+	assert.True(t, ok)
+	_, _, ok = matcher.match("TODO: this is deprecated")
+	assert.False(t, ok)
+
+	matcher = compileTestQuery(t, "FIXME OR XXX")
+	matched, offset, ok := matcher.match("is XXX and FIXME")
+	assert.True(t, ok)
+	assert.Equal(t, "XXX", matched)
+	assert.Equal(t, 3, offset)
+}
+
+func TestQuery_ImplicitAndPrecedence(t *testing.T) {
+
+	// parsed as (a AND b) OR c
+	matcher := compileTestQuery(t, "alpha beta OR gamma")
+	_, _, ok := matcher.match("alpha gamma")
+	assert.True(t, ok)
+	_, _, ok = matcher.match("alpha only")
+	assert.False(t, ok)
+
+	matcher = compileTestQuery(t, "alpha (beta OR gamma)")
+	_, _, ok = matcher.match("gamma alone")
+	assert.False(t, ok)
+	matched, _, ok := matcher.match("gamma and alpha")
+	assert.True(t, ok)
+	assert.Equal(t, "gamma", matched)
+}
+
+func TestQuery_Phrases(t *testing.T) {
+
+	matcher := compileTestQuery(t, `"work around" NOT "see \"issue\""`)
+	matched, _, ok := matcher.match("a work around for the bug")
+	assert.True(t, ok)
+	assert.Equal(t, "work around", matched)
+	_, _, ok = matcher.match("work around, see \"issue\"")
+	assert.False(t, ok)
+	_, _, ok = matcher.match("work and around")
+	assert.False(t, ok)
+}
+
+func TestQuery_Options(t *testing.T) {
+
+	matcher, err := compileQuery("todo NOT os", models.CommentParsingRequest{IgnoreCase: true, WholeWord: true})
+	assert.Nil(t, err)
+	_, _, ok := matcher.match("TODO: for all purposes")
+	assert.True(t, ok)
+	_, _, ok = matcher.match("TODO: remove the OS check")
+	assert.False(t, ok)
+}
+
+func TestQuery_Errors(t *testing.T) {
+
+	for query, message := range map[string]string{
+		"":              "Invalid query ``: the query is empty",
+		"(TODO":         "Invalid query `(TODO`: missing closing parenthesis",
+		"TODO)":         "Invalid query `TODO)`: unexpected closing parenthesis",
+		"TODO AND":      "Invalid query `TODO AND`: unexpected end of query",
+		"OR TODO":       "Invalid query `OR TODO`: `OR` is missing an operand",
+		`"unterminated`: "Invalid query `\"unterminated`: unterminated quoted phrase",
+		`TODO ""`:       "Invalid query `TODO \"\"`: empty phrase",
+	} {
+		_, err := compileQuery(query, models.CommentParsingRequest{})
+		assert.IsType(t, InvalidRequestError{}, err)
+		assert.Equal(t, message, err.Error())
+	}
+}
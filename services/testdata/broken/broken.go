@@ -0,0 +1,9 @@
+package broken
+
+// TODO: before the syntax error
+func Broken() {
+	x :=
+}
+
+// TODO: after the syntax error
+func After() {}
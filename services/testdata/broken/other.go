@@ -0,0 +1,4 @@
+package other
+
+// TODO: in another package
+func Other() {}
@@ -0,0 +1,4 @@
+package broken
+
+// TODO: a valid file
+func Valid() {}
@@ -0,0 +1,44 @@
+// Package declarations is a fixture with a NOTE in comments of every kind of declaration
+package declarations
+
+import (
+	// NOTE: only used by the stringer
+	"fmt"
+)
+
+// NOTE: the limits of a buffer
+const (
+	MinSize = 1
+	maxSize = 64
+)
+
+// NOTE: the default buffer
+var defaultBuffer = &Buffer{}
+
+// Buffer collects strings, NOTE not safe for concurrent use
+type Buffer struct {
+	// NOTE: the collected strings
+	Values []string
+	fmt.Stringer // NOTE: embedded to show the interface
+
+	size int // NOTE: the size of Values in bytes
+}
+
+// Writer writes strings
+type Writer interface {
+	// NOTE: write a single string
+	Write(value string)
+}
+
+// NOTE: add a value to the buffer
+func (b *Buffer) Add(value string) {
+	b.Values = append(b.Values, value)
+	b.size += len(value) // NOTE: no overflow check
+}
+
+func reset(b *Buffer) {
+	// NOTE: keep the capacity
+	b.Values = b.Values[:0]
+}
+
+// NOTE: a comment at the end of the file
@@ -0,0 +1,2 @@
+// Package upper has an upper case module path, which is escaped in the module cache
+package upper
@@ -0,0 +1,5 @@
+// Package dep is a module in the module cache fixture
+package dep
+
+// TODO: found in the module cache
+func Dep() {}
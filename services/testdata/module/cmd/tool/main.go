@@ -0,0 +1,4 @@
+package main
+
+// TODO: commands are not scanned
+func main() {}
@@ -0,0 +1,11 @@
+module example.com/fixture
+
+go 1.22
+
+require (
+	example.com/Upper v0.1.0
+	example.com/dep v1.2.0
+	example.com/local v0.0.0
+)
+
+replace example.com/local => ../replaced
@@ -0,0 +1,7 @@
+// Package greeter is part of the module fixture used by the tests of the services package
+package greeter
+
+// TODO(bob): greet in more languages
+func Greet() string {
+	return "hello"
+}
@@ -0,0 +1,2 @@
+// Package nested is a nested module, it is not part of the fixture module
+package nested
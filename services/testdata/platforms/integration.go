@@ -0,0 +1,6 @@
+//go:build integration
+
+package platforms
+
+// TODO: only built with the integration tag
+func integration() {}
@@ -0,0 +1,2 @@
+// TODO: shared by every platform
+package platforms
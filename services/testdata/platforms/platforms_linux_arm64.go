@@ -0,0 +1,4 @@
+package platforms
+
+// TODO: only built on linux/arm64
+func pageSize() int { return 65536 }
@@ -0,0 +1,4 @@
+package platforms
+
+// TODO: only built on windows
+func volumeName() string { return "C:" }
@@ -0,0 +1,6 @@
+//go:build darwin || freebsd
+
+package platforms
+
+// TODO: only built on darwin and freebsd
+func unixOnly() {}
@@ -0,0 +1,5 @@
+// Package local replaces example.com/local with a local directory
+package local
+
+// TODO: found through a replace directive
+func Local() {}
@@ -0,0 +1,6 @@
+//go:build ignore
+
+// TODO: a generator excluded by its build constraint
+package main
+
+func main() {}
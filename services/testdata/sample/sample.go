@@ -0,0 +1,14 @@
+// Package sample is a fixture used by the tests of the services package
+package sample
+
+// TODO(alice): replace the greeting with a configurable one
+func Greet() string {
+	// FIXME the purposes of this greeting are unclear
+	return "hello"
+}
+
+// Counter counts things, XXX not safe for concurrent use
+type Counter struct {
+	// todo: make this unexported
+	Value int
+}
@@ -0,0 +1,8 @@
+package sample
+
+import "testing"
+
+// TODO: test the greeting in more languages
+func TestGreet(t *testing.T) {
+	Greet()
+}
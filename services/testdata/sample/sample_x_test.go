@@ -0,0 +1,4 @@
+package sample_test
+
+// TODO: an example in the external test package
+func ExampleGreet() {}
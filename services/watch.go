@@ -0,0 +1,245 @@
+package services
+
+import (
+	"commentparser/models"
+	"context"
+	"github.com/fsnotify/fsnotify"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// the default delay Watch waits for the files to stop changing before scanning them again
+const DefaultWatchDebounce = 200 * time.Millisecond
+
+// the changes of the matches of a watched request since its previous scan, the first scan
+// adds every match
+type WatchEvent struct {
+	Added   []models.MatchedComment // the matches found by this scan and not by the previous one
+	Removed []models.MatchedComment // the matches of the previous scan that are gone, with their previous position
+	Err     error                   // the error of the scan, the matches are not compared when it is set
+}
+
+// a string identifying a match across scans, which does not depend on its position so the
+// matches that only moved because lines were added or removed above them are not reported
+func watchKey(match models.MatchedComment) string {
+	return match.FileName + "\x00" + match.Token + "\x00" + match.LineContent
+}
+
+// the matches of the results of a scan, in the order of the results
+func resultMatches(results []models.CommentParsingResult) []models.MatchedComment {
+	var matches []models.MatchedComment
+	for _, res := range results {
+		matches = append(matches, res.OrderedMatches...)
+	}
+	return matches
+}
+
+// compare the matches of two scans, a match found several times is added or removed as many
+// times as its count changed
+func diffMatches(before, after []models.MatchedComment) ([]models.MatchedComment, []models.MatchedComment) {
+
+	counts := make(map[string]int)
+	for _, match := range before {
+		counts[watchKey(match)]++
+	}
+	var added []models.MatchedComment
+	for _, match := range after {
+		key := watchKey(match)
+		if counts[key] > 0 {
+			counts[key]--
+			continue
+		}
+		added = append(added, match)
+	}
+	var removed []models.MatchedComment
+	for idx := len(before) - 1; idx >= 0; idx-- {
+		// the last occurrences of a match are the ones removed
+		key := watchKey(before[idx])
+		if counts[key] > 0 {
+			counts[key]--
+			removed = append(removed, before[idx])
+		}
+	}
+	for i, j := 0, len(removed)-1; i < j; i, j = i+1, j-1 {
+		removed[i], removed[j] = removed[j], removed[i]
+	}
+	return added, removed
+}
+
+// scan a single package or, when its PackageName is a pattern, every package it matches
+func (scanner Scanner) scanRequest(
+	ctx context.Context,
+	request models.CommentParsingRequest) ([]models.CommentParsingResult, error) {
+
+	if IsPackagePattern(request.PackageName) {
+		res, err := scanner.ExtractRelevantCommentsForPattern(ctx, request)
+		return res.Packages, err
+	}
+	res, err := scanner.ExtractRelevantComments(ctx, request)
+	if err != nil {
+		return nil, err
+	}
+	return []models.CommentParsingResult{res}, nil
+}
+
+// the directories of the packages scanned by a request, and the directories below the root of
+// a local pattern, ordered by name
+func (scanner Scanner) packageDirectories(
+	ctx context.Context,
+	request models.CommentParsingRequest) ([]string, error) {
+
+	compiled, err := compileRequest(request)
+	if err != nil {
+		return nil, err
+	}
+	pattern := IsPackagePattern(request.PackageName)
+	resolver, importPath, err := scanner.resolveRequest(compiled, pattern)
+	if err != nil {
+		return nil, err
+	}
+	importPaths := []string{importPath}
+	if IsPackagePattern(importPath) {
+		if importPaths, err = resolver.expandPattern(ctx, importPath); err != nil {
+			return nil, err
+		}
+	}
+	var dirs []string
+	if isLocalPattern(importPath) && IsPackagePattern(importPath) {
+		// every directory a new package can appear in, the directory tree below the literal
+		// prefix of the pattern
+		prefix := importPath[:strings.Index(importPath, "...")]
+		baseDir := filepath.Join(resolver.workingDir, filepath.FromSlash(path.Dir(prefix+"_")))
+		walkPackages(ctx, baseDir, ".", func(string) bool { return true }, func(dir string) bool {
+			dirs = append(dirs, dir)
+			return false
+		}, resolver.module != nil)
+	}
+	for _, importPath := range importPaths {
+		p, _ := resolver.importWith(resolver.ctxt, importPath)
+		if p != nil && len(p.Dir) > 0 {
+			dirs = append(dirs, p.Dir)
+		}
+	}
+	sort.Strings(dirs)
+	return dirs, nil
+}
+
+// true if a change of the file can change the matches of a request
+func isWatchedFile(fileName string) bool {
+	base := filepath.Base(fileName)
+	return strings.HasSuffix(base, ".go") || base == "go.mod" || base == "modules.txt"
+}
+
+// Scan the packages of a request, then scan them again whenever their files change until the
+// context is done, calling changed with the matches added and removed by every scan. The
+// directories of the packages are watched with the notifications of the operating system,
+// directories created below them and the directories of packages that appear are watched too.
+// A scan only starts once the files stopped changing for the debounce delay, and the files
+// that did not change are not parsed again. The matches are compared by file, token and
+// content, so a comment that only moved is not reported. Returns the error of the watcher, or
+// of the first scan if it fails before anything can be watched
+func (scanner Scanner) Watch(
+	ctx context.Context,
+	request models.CommentParsingRequest,
+	debounce time.Duration,
+	changed func(WatchEvent)) error {
+
+	if debounce <= 0 {
+		debounce = DefaultWatchDebounce
+	}
+	if len(request.SortBy) < 1 {
+		request.SortBy = []string{sortKeyFile, sortKeyLine}
+	}
+	if scanner.Cache == nil {
+		// only the files that changed are parsed by the next scans
+		cache, err := NewParseCache(0, "")
+		if err != nil {
+			return err
+		}
+		scanner.Cache = cache
+	}
+
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+	defer watcher.Close()
+
+	watched := make(map[string]bool)
+	watch := func(dir string) {
+		if watched[dir] {
+			return
+		}
+		if err := watcher.Add(dir); err != nil {
+			scanner.Logging.Warning("Could not watch %s: %v", dir, err)
+			return
+		}
+		watched[dir] = true
+	}
+
+	var previous []models.MatchedComment
+	scan := func() error {
+		dirs, err := scanner.packageDirectories(ctx, request)
+		if err != nil {
+			return err
+		}
+		for _, dir := range dirs {
+			watch(dir)
+		}
+		results, err := scanner.scanRequest(ctx, request)
+		if err != nil {
+			return err
+		}
+		matches := resultMatches(results)
+		added, removed := diffMatches(previous, matches)
+		previous = matches
+		if len(added) > 0 || len(removed) > 0 {
+			changed(WatchEvent{Added: added, Removed: removed})
+		}
+		return nil
+	}
+
+	if err := scan(); err != nil {
+		if len(watched) < 1 || ctx.Err() != nil {
+			return err
+		}
+		changed(WatchEvent{Err: err})
+	}
+
+	// the timer fires once the files stopped changing for the debounce delay
+	timer := time.NewTimer(debounce)
+	timer.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case err := <-watcher.Errors:
+			return err
+		case event := <-watcher.Events:
+			if event.Has(fsnotify.Remove) || event.Has(fsnotify.Rename) {
+				// the watch of a directory is gone with it, it is watched again if it is created again
+				delete(watched, event.Name)
+			}
+			if event.Has(fsnotify.Create) {
+				if info, err := os.Stat(event.Name); err == nil && info.IsDir() {
+					// the files written to the directory before it was watched are found by the next scan
+					watch(event.Name)
+					timer.Reset(debounce)
+					continue
+				}
+			}
+			if event.Op == fsnotify.Chmod || !isWatchedFile(event.Name) {
+				continue
+			}
+			timer.Reset(debounce)
+		case <-timer.C:
+			if err := scan(); err != nil && ctx.Err() == nil {
+				changed(WatchEvent{Err: err})
+			}
+		}
+	}
+}
@@ -0,0 +1,83 @@
+package services
+
+import (
+	"commentparser/logging"
+	"commentparser/models"
+	"context"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestWatch_DiffMatches(t *testing.T) {
+
+	match := func(line int, content string) models.MatchedComment {
+		return models.MatchedComment{FileName: "a.go", Token: "TODO", LineNumber: line, LineContent: content}
+	}
+	before := []models.MatchedComment{match(1, "TODO: one"), match(5, "TODO: two"), match(9, "TODO: two")}
+	after := []models.MatchedComment{match(3, "TODO: one"), match(7, "TODO: two"), match(12, "TODO: three")}
+
+	added, removed := diffMatches(before, after)
+	assert.Equal(t, []models.MatchedComment{match(12, "TODO: three")}, added)
+	assert.Equal(t, []models.MatchedComment{match(9, "TODO: two")}, removed, "moved matches are not reported")
+}
+
+func TestWatch_Changes(t *testing.T) {
+
+	root, _ := ioutil.TempDir("", "watch")
+	defer os.RemoveAll(root)
+	ioutil.WriteFile(filepath.Join(root, "go.mod"), []byte("module example.com/watched\n\ngo 1.22\n"), 0644)
+	os.Mkdir(filepath.Join(root, "a"), 0755)
+	fileName := filepath.Join(root, "a", "a.go")
+	ioutil.WriteFile(fileName, []byte("package a\n\n// TODO: first\nfunc A() {}\n"), 0644)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	events := make(chan WatchEvent, 10)
+	done := make(chan error)
+	go func() {
+		request := models.CommentParsingRequest{PackageName: "./...", Tokens: []string{"TODO"}}
+		done <- NewScanner(root, logging.NewMockLogging()).Watch(ctx, request, 10*time.Millisecond, func(event WatchEvent) {
+			events <- event
+		})
+	}()
+
+	next := func() WatchEvent {
+		select {
+		case event := <-events:
+			return event
+		case <-time.After(5 * time.Second):
+			t.Fatal("no change was reported")
+		}
+		return WatchEvent{}
+	}
+
+	event := next()
+	assert.Nil(t, event.Err)
+	assert.Equal(t, 1, len(event.Added))
+	assert.Equal(t, 0, len(event.Removed))
+
+	// the first comment only moves
+	ioutil.WriteFile(fileName, []byte("package a\n\n\n// TODO: first\nfunc A() {}\n\n// TODO: second\nfunc B() {}\n"), 0644)
+	event = next()
+	assert.Equal(t, 1, len(event.Added))
+	assert.Equal(t, "TODO: second\n", event.Added[0].LineContent)
+	assert.Equal(t, 7, event.Added[0].LineNumber)
+	assert.Equal(t, 0, len(event.Removed))
+
+	// a new package is watched
+	os.Mkdir(filepath.Join(root, "b"), 0755)
+	ioutil.WriteFile(filepath.Join(root, "b", "b.go"), []byte("package b\n\n// TODO: in b\nfunc B() {}\n"), 0644)
+	event = next()
+	assert.Equal(t, 1, len(event.Added))
+	assert.Equal(t, "TODO: in b\n", event.Added[0].LineContent)
+
+	os.Remove(fileName)
+	event = next()
+	assert.Equal(t, 2, len(event.Removed))
+
+	cancel()
+	assert.Nil(t, <-done)
+}
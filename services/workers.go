@@ -0,0 +1,72 @@
+package services
+
+import (
+	"context"
+	"runtime"
+	"sync"
+)
+
+// the number of workers used by a scanner when Scanner.Workers is not set
+func defaultWorkers() int {
+	return runtime.NumCPU()
+}
+
+// the number of files or packages the scanner processes concurrently
+func (scanner Scanner) workers() int {
+	if scanner.Workers < 1 {
+		return defaultWorkers()
+	}
+	return scanner.Workers
+}
+
+// call work for every index from 0 to count-1 with at most workers calls running concurrently,
+// and return once they are all done. Each call must only write the results of its own index,
+// which keeps the results in a deterministic order whatever the scheduling. A panic of a call
+// is raised again in the calling goroutine, once every call is done. When the context is done,
+// the remaining indexes are skipped and the error of the context is returned once the calls in
+// progress are done
+func runWorkers(ctx context.Context, workers int, count int, work func(idx int)) error {
+
+	if workers > count {
+		workers = count
+	}
+	indexes := make(chan int)
+	var group sync.WaitGroup
+	var panicOnce sync.Once
+	var panicValue interface{}
+
+	for worker := 0; worker < workers; worker++ {
+		group.Add(1)
+		go func() {
+			defer group.Done()
+			for idx := range indexes {
+				func() {
+					defer func() {
+						if r := recover(); r != nil {
+							panicOnce.Do(func() { panicValue = r })
+						}
+					}()
+					work(idx)
+				}()
+			}
+		}()
+	}
+	var err error
+	for idx := 0; idx < count && err == nil; idx++ {
+		if err = ctx.Err(); err != nil {
+			break
+		}
+		select {
+		case indexes <- idx:
+		case <-ctx.Done():
+			err = ctx.Err()
+		}
+	}
+	close(indexes)
+	group.Wait()
+
+	if panicValue != nil {
+		panic(panicValue)
+	}
+	return err
+}
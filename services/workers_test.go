@@ -0,0 +1,85 @@
+package services
+
+import (
+	"commentparser/logging"
+	"commentparser/models"
+	"context"
+	"github.com/stretchr/testify/assert"
+	"sync/atomic"
+	"testing"
+)
+
+func TestWorkers_Bounded(t *testing.T) {
+
+	var running, maxRunning int32
+	results := make([]int, 100)
+	runWorkers(context.Background(), 4, len(results), func(idx int) {
+		current := atomic.AddInt32(&running, 1)
+		for {
+			observed := atomic.LoadInt32(&maxRunning)
+			if current <= observed || atomic.CompareAndSwapInt32(&maxRunning, observed, current) {
+				break
+			}
+		}
+		results[idx] = idx * idx
+		atomic.AddInt32(&running, -1)
+	})
+
+	assert.True(t, maxRunning <= 4)
+	for idx, result := range results {
+		assert.Equal(t, idx*idx, result)
+	}
+}
+
+func TestWorkers_Panic(t *testing.T) {
+
+	var done int32
+	assert.PanicsWithValue(t, "failed", func() {
+		runWorkers(context.Background(), 2, 10, func(idx int) {
+			if idx == 3 {
+				panic("failed")
+			}
+			atomic.AddInt32(&done, 1)
+		})
+	})
+	// the other calls still run before the panic is raised again
+	assert.Equal(t, int32(9), done)
+}
+
+func TestWorkers_Cancelled(t *testing.T) {
+
+	ctx, cancel := context.WithCancel(context.Background())
+	var done int32
+	err := runWorkers(ctx, 1, 10, func(idx int) {
+		if idx == 2 {
+			cancel()
+		}
+		atomic.AddInt32(&done, 1)
+	})
+
+	assert.Equal(t, context.Canceled, err)
+	// the call in progress completes, the next ones are skipped
+	assert.Equal(t, int32(3), done)
+}
+
+func TestWorkers_DeterministicResults(t *testing.T) {
+
+	req := models.CommentParsingRequest{
+		Directory:   "testdata/module",
+		Tokens:      []string{"TODO"},
+		FileClasses: []string{models.FileClass_GO, models.FileClass_TEST},
+	}
+	sequential := NewScanner("", logging.NewMockLogging())
+	sequential.Workers = 1
+	expected, err := sequential.ExtractRelevantCommentsForPattern(context.Background(), req)
+	assert.Nil(t, err)
+	assert.NotEqual(t, 0, len(expected.Packages))
+
+	concurrent := NewScanner("", logging.NewMockLogging())
+	concurrent.Workers = 8
+	for run := 0; run < 5; run++ {
+		res, err := concurrent.ExtractRelevantCommentsForPattern(context.Background(), req)
+		assert.Nil(t, err)
+		assert.Equal(t, expected, res)
+	}
+}
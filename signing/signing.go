@@ -0,0 +1,71 @@
+/*
+	Package signing implements the HMAC signatures of the requests of the API, which the server
+	verifies and the client computes
+*/
+package signing
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// the headers of a signed request
+const (
+	// the ID of the key the request is signed with
+	Header_KEY_ID = "X-Signature-Key-Id"
+	// when the request was signed, in seconds since the Unix epoch
+	Header_TIMESTAMP = "X-Signature-Timestamp"
+	// a random value that is never used twice with the same key, so a request cannot be replayed
+	Header_NONCE = "X-Signature-Nonce"
+	// the hex encoded HMAC-SHA256 of the request, see Signature
+	Header_SIGNATURE = "X-Signature"
+)
+
+// the signature of a request: the hex encoded HMAC-SHA256, keyed by the secret, of its method,
+// path, query, the SHA-256 of its body, its timestamp and its nonce, each on its own line
+func Signature(
+	secret []byte,
+	method string,
+	path string,
+	rawQuery string,
+	body []byte,
+	timestamp int64,
+	nonce string) string {
+
+	bodyHash := sha256.Sum256(body)
+	mac := hmac.New(sha256.New, secret)
+	fmt.Fprintf(mac, "%s\n%s\n%s\n%s\n%v\n%s",
+		method, path, rawQuery, hex.EncodeToString(bodyHash[:]), timestamp, nonce)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// a random nonce of 16 bytes, hex encoded
+func newNonce() (string, error) {
+	nonce := make([]byte, 16)
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(nonce), nil
+}
+
+// sign a request with the key of the given ID, setting the signature headers. body must be the
+// content of the body of the request, nil if it has none
+func SignRequest(request *http.Request, keyID string, secret []byte, body []byte, now time.Time) error {
+	nonce, err := newNonce()
+	if err != nil {
+		return err
+	}
+	timestamp := now.Unix()
+	request.Header.Set(Header_KEY_ID, keyID)
+	request.Header.Set(Header_TIMESTAMP, strconv.FormatInt(timestamp, 10))
+	request.Header.Set(Header_NONCE, nonce)
+	request.Header.Set(Header_SIGNATURE,
+		Signature(secret, request.Method, request.URL.Path, request.URL.RawQuery, body, timestamp, nonce))
+	return nil
+}
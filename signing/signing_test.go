@@ -0,0 +1,30 @@
+package signing
+
+import (
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestSigning_SignRequest(t *testing.T) {
+
+	body := []byte(`{"Tokens": ["TODO"]}`)
+	request, _ := http.NewRequest("POST", "http://localhost:8080/parse?x=1", nil)
+	assert.Nil(t, SignRequest(request, "ci", []byte("secret"), body, time.Unix(1700000000, 0)))
+
+	assert.Equal(t, "ci", request.Header.Get(Header_KEY_ID))
+	assert.Equal(t, "1700000000", request.Header.Get(Header_TIMESTAMP))
+	nonce := request.Header.Get(Header_NONCE)
+	assert.Len(t, nonce, 32)
+	assert.Equal(t,
+		Signature([]byte("secret"), "POST", "/parse", "x=1", body, 1700000000, nonce),
+		request.Header.Get(Header_SIGNATURE))
+	assert.NotEqual(t,
+		Signature([]byte("secret"), "POST", "/parse", "x=2", body, 1700000000, nonce),
+		request.Header.Get(Header_SIGNATURE))
+
+	other, _ := http.NewRequest("POST", "http://localhost:8080/parse?x=1", nil)
+	assert.Nil(t, SignRequest(other, "ci", []byte("secret"), body, time.Unix(1700000000, 0)))
+	assert.NotEqual(t, nonce, other.Header.Get(Header_NONCE))
+}